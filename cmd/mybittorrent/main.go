@@ -2,17 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/dht"
 	"github.com/codecrafters-io/bittorrent-starter-go/internal/torrent"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/tracker"
 )
 
 func run(args []string) (string, error) {
@@ -35,6 +47,14 @@ func run(args []string) (string, error) {
 		return downloadPiece(args)
 	case "download":
 		return download(args)
+	case "create":
+		return create(args)
+	case "scrape":
+		return scrape(args)
+	case "tracker":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runTracker(ctx, args, nil)
 	default:
 		return "", fmt.Errorf("Unknown command: %s", command)
 	}
@@ -48,18 +68,40 @@ type pieceResult struct {
 
 func download(args []string) (string, error) {
 	if len(args) < 4 {
-		return "", fmt.Errorf("Usage: mybittorrent download -o <output-file> <torrent-file>")
+		return "", fmt.Errorf("Usage: mybittorrent download -o <output-file-or-directory> <torrent-file-or-magnet-link>")
 	}
 	outputFile := args[3]
 	torrentFile := args[4]
-	info, err := torrent.ReadFromFile(torrentFile)
+	info, err := openTorrent(torrentFile)
 	if err != nil {
 		return "", err
 	}
+	if info.IsV2() && !info.IsHybrid() {
+		return "", fmt.Errorf("downloading v2-only torrents is not supported")
+	}
 
-	peers, err := torrent.Peers(http.DefaultClient, info)
+	var downloaded atomic.Int64
+	announcer, peers, err := startDownload(info, func() torrent.TransferStats {
+		return torrent.TransferStats{Downloaded: downloaded.Load(), Left: info.Length - downloaded.Load()}
+	})
 	if err != nil {
-		return "", fmt.Errorf("Error getting peers: %v", err)
+		return "", err
+	}
+	if announcer != nil {
+		// Keep announcing on the tracker's interval while the download runs,
+		// and announce stopped once it is over.
+		announcer.OnResult = func(result torrent.AnnounceResult) {
+			if result.Err != nil && result.Event == torrent.EventCompleted {
+				fmt.Fprintln(os.Stderr, "Failed to announce completion:", result.Err)
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error, 1)
+		go func() { stopped <- announcer.Run(ctx) }()
+		defer func() {
+			cancel()
+			<-stopped
+		}()
 	}
 
 	// Create a buffer for each piece
@@ -71,9 +113,14 @@ func download(args []string) (string, error) {
 	resultChan := make(chan pieceResult, len(info.PieceHashes))
 	peerChan := make(chan string, len(peers))
 
-	// Fill peer channel with available peers
+	// Fill peer channel with available peers, and the pool that peer
+	// exchange adds to with all of them
+	pool := torrent.NewPeerPool()
 	for _, peer := range peers {
 		peerChan <- peer
+		if addr, err := netip.ParseAddrPort(peer); err == nil {
+			pool.Add(addr, 0, torrent.SourceTracker)
+		}
 	}
 
 	// Start parallel downloads
@@ -84,27 +131,27 @@ func download(args []string) (string, error) {
 			// Return the peer to the channel when done
 			defer func() { peerChan <- peerAddr }()
 
-			// Create a new connection for this piece
-			conn, err := net.Dial("tcp", peerAddr)
-			if err != nil {
-				resultChan <- pieceResult{pieceIndex, fmt.Errorf("Failed to connect to peer %s: %v", peerAddr, err)}
-				return
-			}
-			defer conn.Close()
+			err := downloadVerifiedPiece([]string{peerAddr}, info, pool, pieceBuffers[pieceIndex], pieceIndex)
 
-			_, err = torrent.Handshake(conn, info)
+			// Fall back to the other peers, including those that peer
+			// exchange found, so that a piece that failed its hash check
+			// comes from a different peer
 			if err != nil {
-				resultChan <- pieceResult{pieceIndex, fmt.Errorf("Handshake failed: %v", err)}
-				return
+				var others []string
+				for _, alternative := range pool.Peers() {
+					if addr := alternative.Addr.String(); addr != peerAddr {
+						others = append(others, addr)
+					}
+				}
+				if len(others) > 0 {
+					err = downloadVerifiedPiece(others, info, pool, pieceBuffers[pieceIndex], pieceIndex)
+				}
 			}
-
-			err = torrent.DownloadPiece(conn, pieceBuffers[pieceIndex], info, pieceIndex)
-			if err != nil {
-				resultChan <- pieceResult{pieceIndex, fmt.Errorf("Failed to download piece %d: %v", pieceIndex, err)}
-				return
+			if err == nil {
+				downloaded.Add(int64(pieceBuffers[pieceIndex].Len()))
 			}
 
-			resultChan <- pieceResult{pieceIndex, nil}
+			resultChan <- pieceResult{pieceIndex, err}
 		}(i)
 	}
 
@@ -116,76 +163,203 @@ func download(args []string) (string, error) {
 		}
 	}
 
-	// Write all pieces to the output file in order
-	outputFileHandle, err := os.Create(outputFile)
+	// Write all pieces to the output. Multi-file torrents are written into
+	// a folder inside the output directory.
+	storage, err := torrent.CreateFiles(info, outputFile)
 	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %v", err)
+		return "", fmt.Errorf("failed to create output files: %v", err)
 	}
-	defer outputFileHandle.Close()
+	defer storage.Close()
 
 	for i, buffer := range pieceBuffers {
-		_, err := io.Copy(outputFileHandle, buffer)
-		if err != nil {
+		offset := int64(i) * int64(info.PieceLength)
+		if _, err := storage.WriteAt(buffer.Bytes(), offset); err != nil {
 			return "", fmt.Errorf("failed to write piece %d to file: %v", i, err)
 		}
 	}
 
+	if announcer != nil {
+		// Run announces completed, in order with its other announces.
+		announcer.Completed()
+	}
+
 	return "download complete", nil
 }
 
-func downloadPiece(args []string) (string, error) {
-	if len(args) < 5 {
-		return "", fmt.Errorf("Usage: mybittorrent download_piece -o <output-file> <torrent-file> <piece-index>")
-	}
+// listFlag collects the values of a flag that may be repeated.
+type listFlag []string
 
-	outputFile := args[3]
-	torrentFile := args[4]
-	pieceIndex, err := strconv.Atoi(args[5])
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func create(args []string) (string, error) {
+	usage := fmt.Errorf("Usage: mybittorrent create [-o <torrent-file>] [-t <tracker>[,<tracker>...]]... [-w <web-seed>]... [-c <comment>] [-s <source>] [-l <piece-length>] [-p] <file-or-directory>")
+
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	output := flags.String("o", "", "output torrent file")
+	comment := flags.String("c", "", "comment")
+	source := flags.String("s", "", "source tag")
+	pieceLength := flags.Int("l", 0, "piece length in bytes")
+	private := flags.Bool("p", false, "private torrent")
+	var trackers, webSeeds listFlag
+	flags.Var(&trackers, "t", "tracker tier, as comma-separated URLs")
+	flags.Var(&webSeeds, "w", "web seed URL")
+	if err := flags.Parse(args[2:]); err != nil || flags.NArg() != 1 {
+		return "", usage
+	}
+	path := flags.Arg(0)
+
+	tiers := make([][]string, 0, len(trackers))
+	for _, tier := range trackers {
+		tiers = append(tiers, strings.Split(tier, ","))
+	}
+
+	data, err := torrent.Build(path, torrent.BuildOptions{
+		PieceLength:  *pieceLength,
+		Trackers:     tiers,
+		WebSeeds:     webSeeds,
+		Comment:      *comment,
+		Private:      *private,
+		Source:       *source,
+		CreatedBy:    "mybittorrent",
+		CreationDate: time.Now(),
+	})
 	if err != nil {
-		return "", fmt.Errorf("Invalid piece index: %v", err)
+		return "", fmt.Errorf("failed to create torrent: %v", err)
 	}
 
-	info, err := torrent.ReadFromFile(torrentFile)
+	info, err := torrent.Info(data)
 	if err != nil {
 		return "", err
 	}
 
-	peers, err := torrent.Peers(http.DefaultClient, info)
-	if err != nil {
-		return "", fmt.Errorf("Error getting peers: %v", err)
+	if *output == "" {
+		*output = info.Name + ".torrent"
 	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write torrent file: %v", err)
+	}
+	return fmt.Sprintf("Created %s\nInfo Hash: %x", *output, info.InfoHash), nil
+}
 
-	peerAddr := peers[0]
-
-	// Create TCP connection to peer
+// downloadPieceFrom downloads a piece over a new connection to a peer.
+// While it is open, peer exchange adds the peers it learns to pool and tells
+// the peer about the others in pool.
+func downloadPieceFrom(peerAddr string, info *torrent.Metadata, pool *torrent.PeerPool, buffer *bytes.Buffer, pieceIndex int) error {
 	conn, err := net.Dial("tcp", peerAddr)
 	if err != nil {
-		return "", fmt.Errorf("Failed to connect to peer %s: %v", peerAddr, err)
+		return fmt.Errorf("Failed to connect to peer %s: %v", peerAddr, err)
 	}
 	defer conn.Close()
 
-	_, err = torrent.Handshake(conn, info)
+	peer, err := torrent.NewPeerConn(conn, info, pool)
 	if err != nil {
-		return "", fmt.Errorf("Handshake failed: %v", err)
+		return fmt.Errorf("Handshake failed: %v", err)
+	}
+
+	if peer.PEX != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go peer.PEX.Run(ctx, peer.Extensions, func() []torrent.PoolPeer {
+			var others []torrent.PoolPeer
+			for _, other := range pool.Peers() {
+				if other.Addr.String() != peerAddr {
+					others = append(others, other)
+				}
+			}
+			return others
+		})
+	}
+
+	if err := peer.DownloadPiece(buffer, info, pieceIndex); err != nil {
+		return fmt.Errorf("Failed to download piece %d: %w", pieceIndex, err)
+	}
+	return nil
+}
+
+// downloadVerifiedPiece downloads a piece into buffer from the first of
+// peers that sends it intact, skipping banned peers. Only data that passed
+// the hash check is left in buffer. A peer whose data fails the check is
+// charged with the failure in pool, and the piece moves on to the next peer.
+func downloadVerifiedPiece(peers []string, info *torrent.Metadata, pool *torrent.PeerPool, buffer *bytes.Buffer, pieceIndex int) error {
+	err := fmt.Errorf("no peers to download piece %d from", pieceIndex)
+	for _, peerAddr := range peers {
+		addr, parseErr := netip.ParseAddrPort(peerAddr)
+		if parseErr == nil && pool.Banned(addr) {
+			continue
+		}
+		buffer.Reset()
+		if err = downloadPieceFrom(peerAddr, info, pool, buffer, pieceIndex); err == nil {
+			return nil
+		}
+		var hashErr *torrent.PieceHashError
+		if errors.As(err, &hashErr) && parseErr == nil && pool.RecordHashFailure(addr) {
+			fmt.Fprintf(os.Stderr, "Banned peer %s after %d pieces failed their hash check\n", peerAddr, torrent.MaxHashFailures)
+		}
+	}
+	return err
+}
+
+func downloadPiece(args []string) (string, error) {
+	if len(args) < 6 {
+		return "", fmt.Errorf("Usage: mybittorrent download_piece -o <output-file> <torrent-file-or-magnet-link> <piece-index>")
+	}
+
+	outputFile := args[3]
+	torrentFile := args[4]
+	pieceIndex, err := strconv.Atoi(args[5])
+	if err != nil {
+		return "", fmt.Errorf("Invalid piece index: %v", err)
+	}
+	if pieceIndex < 0 {
+		return "", fmt.Errorf("Invalid piece index %d: must not be negative", pieceIndex)
 	}
 
-	outputFileHandle, err := os.Create(outputFile)
+	info, err := openTorrent(torrentFile)
 	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %v", err)
+		return "", err
+	}
+	if info.IsV2() && !info.IsHybrid() {
+		return "", fmt.Errorf("downloading v2-only torrents is not supported")
+	}
+	if pieceIndex >= len(info.PieceHashes) {
+		return "", fmt.Errorf("Invalid piece index %d: the torrent has %d pieces", pieceIndex, len(info.PieceHashes))
 	}
-	defer outputFileHandle.Close()
 
-	// Use the file writer for the piece data
-	err = torrent.DownloadPiece(conn, outputFileHandle, info, pieceIndex)
+	peers, err := findPeers(info)
 	if err != nil {
+		return "", err
+	}
+
+	pool := torrent.NewPeerPool()
+	for _, peer := range peers {
+		if addr, err := netip.ParseAddrPort(peer); err == nil {
+			pool.Add(addr, 0, torrent.SourceTracker)
+		}
+	}
+
+	// The output file is only created once the piece has passed its hash
+	// check, so a failed download leaves nothing behind.
+	var piece bytes.Buffer
+	if err := downloadVerifiedPiece(peers, info, pool, &piece, pieceIndex); err != nil {
 		return "", fmt.Errorf("Failed to download piece: %v", err)
 	}
+	if err := os.WriteFile(outputFile, piece.Bytes(), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write output file: %v", err)
+	}
 
 	return "Piece downloaded successfully", nil
 }
 
 func handshake(args []string) (string, error) {
-	info, err := torrent.ReadFromFile(args[2])
+	info, err := knownMetadata(args[2])
 	if err != nil {
 		return "", err
 	}
@@ -213,22 +387,412 @@ func handshake(args []string) (string, error) {
 	return "Peer ID: " + peerID, nil
 }
 
+// metadataTimeout bounds fetching the info dictionary from one peer.
+var metadataTimeout = time.Minute
+
+// openTorrent returns the metadata of the torrent given as a file name or a
+// magnet link. The info dictionary of a magnet link's torrent is fetched from
+// the first of its peers that has it.
+func openTorrent(arg string) (*torrent.Metadata, error) {
+	if !strings.HasPrefix(arg, "magnet:") {
+		return torrent.ReadFromFile(arg)
+	}
+
+	magnet, err := torrent.ParseMagnet(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	peerAddrs := magnet.Peers
+	if len(magnet.Trackers) > 0 {
+		response, err := torrent.Peers(http.DefaultClient, magnet.Metadata())
+		if err != nil && len(peerAddrs) == 0 {
+			return nil, fmt.Errorf("Error getting peers: %v", err)
+		}
+		if err == nil {
+			warn(response.Warning)
+			peerAddrs = append(peerAddrs, peerStrings(response.Peers)...)
+		}
+	}
+	if len(peerAddrs) == 0 {
+		dhtPeers, err := findDHTPeers(magnet.InfoHash)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting peers: %v", err)
+		}
+		peerAddrs = dhtPeers
+	}
+
+	lastErr := fmt.Errorf("no peers to fetch the torrent's metadata from")
+	for _, peerAddr := range peerAddrs {
+		conn, err := net.DialTimeout("tcp", peerAddr, 10*time.Second)
+		if err != nil {
+			lastErr = fmt.Errorf("Failed to connect to peer %s: %v", peerAddr, err)
+			continue
+		}
+
+		// A peer that goes silent or never sends the metadata would
+		// otherwise hold up the rest.
+		conn.SetDeadline(time.Now().Add(metadataTimeout))
+		metadata, err := torrent.FetchMetadata(conn, magnet)
+		conn.Close()
+		if err == nil {
+			return metadata, nil
+		}
+		lastErr = fmt.Errorf("failed to fetch metadata from %s: %v", peerAddr, err)
+	}
+	return nil, lastErr
+}
+
+// knownMetadata is like openTorrent, but does not fetch the info dictionary
+// of a magnet link's torrent, which finding and greeting peers do not need.
+func knownMetadata(arg string) (*torrent.Metadata, error) {
+	if !strings.HasPrefix(arg, "magnet:") {
+		return torrent.ReadFromFile(arg)
+	}
+
+	magnet, err := torrent.ParseMagnet(arg)
+	if err != nil {
+		return nil, err
+	}
+	return magnet.Metadata(), nil
+}
+
+// dhtBootstrapNodes are the nodes that DHT lookups join the network through.
+var dhtBootstrapNodes = dht.DefaultBootstrapNodes
+
+// dhtTimeout bounds joining the DHT and looking up a torrent's peers.
+const dhtTimeout = 30 * time.Second
+
+// startDownload announces the start of a download to the torrent's
+// trackers and returns the announcer for the rest of its events, with stats
+// giving the counters, along with the tracker's peers. Torrents without a
+// tracker find peers through the DHT instead, and have no announcer.
+func startDownload(info *torrent.Metadata, stats func() torrent.TransferStats) (*torrent.Announcer, []string, error) {
+	tiers := torrent.TrackerTiers(info)
+	if len(tiers) == 0 {
+		peers, err := findPeers(info)
+		return nil, peers, err
+	}
+
+	tracker := torrent.NewTrackerList(tiers, http.DefaultClient)
+	var peerID [20]byte
+	copy(peerID[:], "99999999999999999999")
+	announcer := torrent.NewAnnouncer(tracker, info.InfoHash, peerID, 6881)
+	announcer.Stats = stats
+
+	response, err := announce(announcer, torrent.EventStarted)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error getting peers: %v", err)
+	}
+	warn(response.Warning)
+	return announcer, peerStrings(response.Peers), nil
+}
+
+// announceTimeout bounds each announce of a download, across all the
+// trackers it tries.
+const announceTimeout = 2 * time.Minute
+
+// announce sends event to the download's trackers, giving up after
+// announceTimeout.
+func announce(announcer *torrent.Announcer, event torrent.AnnounceEvent) (*torrent.AnnounceResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), announceTimeout)
+	defer cancel()
+	return announcer.Announce(ctx, event)
+}
+
+// findPeers returns the peers of a torrent from its trackers, or from the
+// DHT if it has none.
+func findPeers(info *torrent.Metadata) ([]string, error) {
+	if len(torrent.TrackerTiers(info)) == 0 {
+		if info.Private {
+			// Peers of private torrents come from their trackers only.
+			return nil, fmt.Errorf("Error getting peers: private torrent has no tracker URL")
+		}
+		peers, err := findDHTPeers(info.InfoHash)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting peers: %v", err)
+		}
+		return peers, nil
+	}
+
+	response, err := torrent.Peers(http.DefaultClient, info)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting peers: %v", err)
+	}
+	warn(response.Warning)
+	return peerStrings(response.Peers), nil
+}
+
+// peerStrings formats peer addresses for dialing, with IPv6 addresses in
+// brackets.
+func peerStrings[T fmt.Stringer](addrs []T) []string {
+	peers := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		peers = append(peers, addr.String())
+	}
+	return peers
+}
+
+// warn prints a tracker's warning message, if there is one.
+func warn(warning string) {
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, "Tracker warning:", warning)
+	}
+}
+
+// findDHTPeers looks up the peers of a torrent in the DHT. The routing table
+// is kept in the user's cache directory between runs, so that later lookups
+// need not start from the bootstrap nodes alone.
+func findDHTPeers(infoHash [20]byte) ([]string, error) {
+	config := dht.Config{BootstrapNodes: dhtBootstrapNodes}
+	if dir, err := os.UserCacheDir(); err == nil {
+		dir = filepath.Join(dir, "mybittorrent")
+		if err := os.MkdirAll(dir, 0o755); err == nil {
+			config.StateFile = filepath.Join(dir, "dht.dat")
+		}
+	}
+
+	server, err := dht.NewServer(config)
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dhtTimeout)
+	defer cancel()
+	if err := server.Bootstrap(ctx); err != nil {
+		return nil, err
+	}
+	addrs, err := server.FindPeers(ctx, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no peers found in the DHT")
+	}
+	return peerStrings(addrs), nil
+}
+
+// scrapeTimeout bounds each scrape request.
+const scrapeTimeout = 15 * time.Second
+
+// scrapeTarget is a torrent being scraped, and its trackers in the order
+// they are tried.
+type scrapeTarget struct {
+	arg      string
+	info     *torrent.Metadata
+	trackers []string
+	next     int // Index of the next tracker to try.
+
+	tracker string // The tracker that answered.
+	stats   torrent.ScrapeStats
+	err     error
+}
+
+func scrape(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("Usage: mybittorrent scrape <torrent-file-or-magnet-link>...")
+	}
+
+	var targets []*scrapeTarget
+	for _, arg := range args[2:] {
+		info, err := knownMetadata(arg)
+		if err != nil {
+			return "", err
+		}
+		target := &scrapeTarget{arg: arg, info: info, err: fmt.Errorf("torrent has no tracker URL")}
+		for _, tier := range torrent.TrackerTiers(info) {
+			target.trackers = append(target.trackers, tier...)
+		}
+		targets = append(targets, target)
+	}
+
+	// Torrents that try the same tracker next are scraped in one request.
+	// Those it fails for, or does not know, move on to their next tracker.
+	for {
+		groups := make(map[string][]*scrapeTarget)
+		var order []string
+		for _, target := range targets {
+			if target.tracker != "" || target.next >= len(target.trackers) {
+				continue
+			}
+			url := target.trackers[target.next]
+			if groups[url] == nil {
+				order = append(order, url)
+			}
+			groups[url] = append(groups[url], target)
+		}
+		if len(order) == 0 {
+			break
+		}
+
+		for _, url := range order {
+			group := groups[url]
+			stats, err := scrapeTracker(url, group)
+			for _, target := range group {
+				target.next++
+				if err != nil {
+					target.err = fmt.Errorf("%s: %v", url, err)
+				} else if s, ok := stats[target.info.InfoHash]; ok {
+					target.tracker, target.stats, target.err = url, s, nil
+				} else {
+					target.err = fmt.Errorf("%s: tracker does not know the torrent", url)
+				}
+			}
+		}
+	}
+
+	var blocks []string
+	failed := 0
+	for _, target := range targets {
+		name := target.info.Name
+		if name == "" {
+			name = target.arg
+		}
+		block := name + "\n" + fmt.Sprintf("Info Hash: %x\n", target.info.InfoHash)
+		if target.err != nil {
+			block += "Error: " + target.err.Error()
+			failed++
+		} else {
+			block += "Tracker: " + target.tracker + "\n" +
+				"Seeders: " + fmt.Sprint(target.stats.Seeders) + "\n" +
+				"Leechers: " + fmt.Sprint(target.stats.Leechers) + "\n" +
+				"Downloaded: " + fmt.Sprint(target.stats.Downloaded)
+		}
+		blocks = append(blocks, block)
+	}
+	if failed == len(targets) {
+		return "", fmt.Errorf("Error scraping:\n%s", strings.Join(blocks, "\n\n"))
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// scrapeTracker scrapes the torrents of targets from one tracker.
+func scrapeTracker(url string, targets []*scrapeTarget) (map[[20]byte]torrent.ScrapeStats, error) {
+	scraper, err := torrent.NewScraper(url, http.DefaultClient)
+	if err != nil {
+		return nil, err
+	}
+	infoHashes := make([][20]byte, 0, len(targets))
+	for _, target := range targets {
+		infoHashes = append(infoHashes, target.info.InfoHash)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+	return scraper.Scrape(ctx, infoHashes)
+}
+
+// trackerSaveInterval is how often the tracker command saves its state.
+const trackerSaveInterval = time.Minute
+
+// runTracker serves a tracker until ctx is done. listening, if not nil, is
+// called with the address once the tracker accepts connections.
+func runTracker(ctx context.Context, args []string, listening func(net.Addr)) (string, error) {
+	usage := fmt.Errorf("Usage: mybittorrent tracker [--listen <address>] [--interval <duration>] [--min-interval <duration>] [--peer-timeout <duration>] [--allow <info-hash-or-torrent>]... [--state <file>]")
+
+	flags := flag.NewFlagSet("tracker", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	listen := flags.String("listen", ":6969", "address to listen on")
+	interval := flags.Duration("interval", tracker.DefaultInterval, "announce interval")
+	minInterval := flags.Duration("min-interval", tracker.DefaultMinInterval, "minimum announce interval")
+	peerTimeout := flags.Duration("peer-timeout", 0, "how long peers are listed after their last announce")
+	stateFile := flags.String("state", "", "file to save the swarms in")
+	var allow listFlag
+	flags.Var(&allow, "allow", "info hash, torrent file or magnet link of a torrent to serve")
+	if err := flags.Parse(args[2:]); err != nil || flags.NArg() != 0 {
+		return "", usage
+	}
+
+	config := tracker.Config{
+		Interval:    *interval,
+		MinInterval: *minInterval,
+		PeerTimeout: *peerTimeout,
+		StateFile:   *stateFile,
+	}
+	for _, arg := range allow {
+		var infoHash [20]byte
+		if decoded, err := hex.DecodeString(arg); err == nil && len(decoded) == len(infoHash) {
+			copy(infoHash[:], decoded)
+		} else {
+			info, err := knownMetadata(arg)
+			if err != nil {
+				return "", err
+			}
+			infoHash = info.InfoHash
+		}
+		config.AllowList = append(config.AllowList, infoHash)
+	}
+
+	server, err := tracker.NewServer(config)
+	if err != nil {
+		return "", err
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen: %v", err)
+	}
+	httpServer := &http.Server{Handler: server}
+	served := make(chan error, 1)
+	go func() { served <- httpServer.Serve(ln) }()
+	fmt.Fprintf(os.Stderr, "Tracker listening on http://%s/announce\n", ln.Addr())
+	if listening != nil {
+		listening(ln.Addr())
+	}
+
+	ticker := time.NewTicker(trackerSaveInterval)
+	defer ticker.Stop()
+serve:
+	for {
+		select {
+		case <-ticker.C:
+			if err := server.Save(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		case err := <-served:
+			return "", fmt.Errorf("tracker failed: %v", err)
+		case <-ctx.Done():
+			break serve
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	httpServer.Shutdown(shutdownCtx)
+	if err := server.Save(); err != nil {
+		return "", err
+	}
+	return "Tracker stopped", nil
+}
+
 func peers(args []string) (string, error) {
 	if len(args) < 3 {
 		return "", fmt.Errorf("Missing torrent file")
 	}
 
-	info, err := torrent.ReadFromFile(args[2])
+	info, err := knownMetadata(args[2])
 	if err != nil {
 		return "", err
 	}
 
-	peers, err := torrent.Peers(http.DefaultClient, info)
+	if len(torrent.TrackerTiers(info)) == 0 {
+		peers, err := findPeers(info)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(peers, "\n"), nil
+	}
+
+	response, err := torrent.Peers(http.DefaultClient, info)
 	if err != nil {
 		return "", fmt.Errorf("Error getting peers: %v", err)
 	}
+	warn(response.Warning)
 
-	return strings.Join(peers, "\n"), nil
+	// Standard output lists only the peers, one per line, so the answering
+	// tracker goes to standard error.
+	fmt.Fprintln(os.Stderr, "Tracker:", strings.Join(response.Trackers, " "))
+	return strings.Join(peerStrings(response.Peers), "\n"), nil
 }
 
 func info(args []string) (string, error) {
@@ -236,23 +800,74 @@ func info(args []string) (string, error) {
 		return "", fmt.Errorf("Missing torrent file")
 	}
 
-	info, err := torrent.ReadFromFile(args[2])
+	info, err := openTorrent(args[2])
 	if err != nil {
 		return "", err
 	}
 
 	output := "Tracker URL: " + info.Announce + "\n" +
-		"Length: " + fmt.Sprint(info.Length) + "\n" +
-		"Info Hash: " + fmt.Sprintf("%x", info.InfoHash) + "\n" +
-		"Piece Length: " + fmt.Sprint(info.PieceLength) + "\n" +
+		"Length: " + fmt.Sprint(info.Length) + "\n"
+	if info.IsMultiFile() {
+		output += "Files:\n"
+		for _, f := range info.Files {
+			if f.Padding {
+				continue
+			}
+			output += fmt.Sprintf("%d %s\n", f.Length, strings.Join(f.Path, "/"))
+		}
+	}
+	output += "Info Hash: " + fmt.Sprintf("%x", info.InfoHash) + "\n"
+	if info.IsV2() {
+		output += "Meta Version: " + fmt.Sprint(info.MetaVersion) + "\n" +
+			"Info Hash v2: " + fmt.Sprintf("%x", info.InfoHashV2) + "\n"
+	}
+	output += "Piece Length: " + fmt.Sprint(info.PieceLength) + "\n" +
+		optionalInfo(info) +
 		"Piece Hashes:\n" + strings.Join(info.PieceHashes, "\n")
 	return output, nil
 }
 
+// optionalInfo formats the metainfo fields that only some torrents set, one
+// line each, skipping those that are missing.
+func optionalInfo(info *torrent.Metadata) string {
+	var output string
+	for i, tier := range info.AnnounceList {
+		output += fmt.Sprintf("Announce Tier %d: %s\n", i+1, strings.Join(tier, " "))
+	}
+	if info.Private {
+		output += "Private: true\n"
+	}
+	if len(info.URLList) > 0 {
+		output += "URL List: " + strings.Join(info.URLList, " ") + "\n"
+	}
+	if len(info.HTTPSeeds) > 0 {
+		output += "HTTP Seeds: " + strings.Join(info.HTTPSeeds, " ") + "\n"
+	}
+	if info.Comment != "" {
+		output += "Comment: " + info.Comment + "\n"
+	}
+	if info.CreatedBy != "" {
+		output += "Created By: " + info.CreatedBy + "\n"
+	}
+	if !info.CreationDate.IsZero() {
+		output += "Creation Date: " + info.CreationDate.Format(time.RFC3339) + "\n"
+	}
+	if info.Encoding != "" {
+		output += "Encoding: " + info.Encoding + "\n"
+	}
+	if info.Source != "" {
+		output += "Source: " + info.Source + "\n"
+	}
+	return output
+}
+
 func decode(args []string) (string, error) {
 	if len(args) < 3 {
 		return "", fmt.Errorf("Missing bencoded value")
 	}
+	if args[2] == "-" {
+		return decodeStream(os.Stdin)
+	}
 	decoded, _, err := bencode.Decode([]byte(args[2]))
 	if err != nil {
 		return "", err
@@ -265,6 +880,29 @@ func decode(args []string) (string, error) {
 	return string(jsonOutput), nil
 }
 
+// decodeStream decodes consecutive bencoded values from r, printing each as
+// JSON on its own line.
+func decodeStream(r io.Reader) (string, error) {
+	dec := bencode.NewDecoder(r)
+	lines := make([]string, 0)
+	for {
+		var decoded any
+		err := dec.Decode(&decoded)
+		if err == io.EOF {
+			return strings.Join(lines, "\n"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		jsonOutput, err := customMarshal(decoded)
+		if err != nil {
+			return "", fmt.Errorf("Error converting to JSON: %v", err)
+		}
+		lines = append(lines, string(jsonOutput))
+	}
+}
+
 // convertBytesToStrings recursively converts all []byte to strings in the interface{}
 func convertBytesToStrings(v any) any {
 	switch v := v.(type) {
@@ -1,14 +1,42 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/dht"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/torrent"
 )
 
+// withoutDHT keeps a test from contacting the real DHT, and from saving its
+// routing table in the user's cache directory.
+func withoutDHT(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	saved := dhtBootstrapNodes
+	dhtBootstrapNodes = nil
+	t.Cleanup(func() { dhtBootstrapNodes = saved })
+}
+
 func TestRun(t *testing.T) {
+	withoutDHT(t)
 	tests := []struct {
 		name    string
 		args    []string
@@ -51,12 +79,23 @@ func TestRun(t *testing.T) {
 				"Length: 92063",
 				"Info Hash: d69f91e6b2ae4c542468d1073a71d4ea13879a7f",
 				"Piece Length: 32768",
+				"Created By: mktorrent 1.1",
 				"Piece Hashes:",
 				"e876f67a2a8886e8f36b136726c30fa29703022d",
 				"6e2275e604a0766656736e81ff10b55204ad8d35",
 				"f00d937a0213df1982bc8d097227ad9e909acc17",
 			}, "\n"),
 		},
+		{
+			name:    "info of magnet link without info hash",
+			args:    []string{"program", "info", "magnet:?dn=sample.txt"},
+			wantErr: true,
+		},
+		{
+			name:    "info of magnet link without peers",
+			args:    []string{"program", "info", "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f"},
+			wantErr: true,
+		},
 		{
 			name: "peers of torrent file",
 			args: []string{"program", "peers", "../../sample.torrent"},
@@ -87,6 +126,70 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestDecodeStream(t *testing.T) {
+	got, err := decodeStream(strings.NewReader("5:helloi42eld3:fooi1eee"))
+	if err != nil {
+		t.Fatalf("decodeStream() error = %v", err)
+	}
+
+	want := strings.Join([]string{`"hello"`, "42", `[{"foo":1}]`}, "\n")
+	if got != want {
+		t.Errorf("decodeStream() = %v, want %v", got, want)
+	}
+}
+
+func TestInfoMagnetSilentPeer(t *testing.T) {
+	saved := metadataTimeout
+	metadataTimeout = 100 * time.Millisecond
+	defer func() { metadataTimeout = saved }()
+
+	// The first peer accepts the connection and never answers; the second
+	// hangs up at once.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	closing, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closing.Close()
+	go func() {
+		for {
+			conn, err := closing.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	magnet := "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&x.pe=" + silent.Addr().String() + "&x.pe=" + closing.Addr().String()
+	done := make(chan error, 1)
+	go func() {
+		_, err := run([]string{"program", "info", magnet})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), closing.Addr().String()) {
+			t.Errorf("run() error = %v, want the error of the second peer", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run() did not give up on a silent peer")
+	}
+}
+
 func TestDownload(t *testing.T) {
 	run([]string{"program", "download", "-o", "output.txt", "../../sample.torrent"})
 
@@ -107,3 +210,541 @@ func TestDownload(t *testing.T) {
 		t.Errorf("output.txt SHA-1 hash = %v, want %v", gotHash, wantHash)
 	}
 }
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	input := dir + "/artifact.txt"
+	output := dir + "/artifact.torrent"
+	if err := os.WriteFile(input, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	got, err := run([]string{"program", "create", "-o", output, "-t", "http://a/announce,http://b/announce", "-t", "http://c/announce", "-p", "-s", "builds", input})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if !strings.HasPrefix(got, "Created "+output+"\nInfo Hash: ") {
+		t.Errorf("run() = %v, want created message with info hash", got)
+	}
+
+	got, err = run([]string{"program", "info", output})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	for _, want := range []string{
+		"Tracker URL: http://a/announce",
+		"Length: 11",
+		"Announce Tier 1: http://a/announce http://b/announce",
+		"Announce Tier 2: http://c/announce",
+		"Private: true",
+		"Source: builds",
+		"Piece Length: 16384",
+		fmt.Sprintf("%x", sha1.Sum([]byte("hello world"))),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("info output %q does not contain %q", got, want)
+		}
+	}
+
+	if _, err := run([]string{"program", "create"}); err == nil {
+		t.Errorf("expected an error without a path")
+	}
+}
+
+func TestPeersDHT(t *testing.T) {
+	withoutDHT(t)
+	dir := t.TempDir()
+	input := dir + "/artifact.txt"
+	output := dir + "/artifact.torrent"
+	if err := os.WriteFile(input, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	if _, err := run([]string{"program", "create", "-o", output, input}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	info, err := torrent.ReadFromFile(output)
+	if err != nil {
+		t.Fatalf("failed to read torrent: %v", err)
+	}
+
+	// A small network on loopback, with a peer announced to it.
+	var servers []*dht.Server
+	for range 5 {
+		config := dht.Config{Addr: "127.0.0.1:0", QueryTimeout: time.Second}
+		if len(servers) > 0 {
+			config.BootstrapNodes = []string{servers[0].Addr().String()}
+		}
+		server, err := dht.NewServer(config)
+		if err != nil {
+			t.Fatalf("NewServer failed: %v", err)
+		}
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range servers[1:] {
+		if err := server.Bootstrap(ctx); err != nil {
+			t.Fatalf("Bootstrap failed: %v", err)
+		}
+	}
+	if _, err := servers[3].Announce(ctx, info.InfoHash, 6881); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+
+	dhtBootstrapNodes = []string{servers[0].Addr().String()}
+	got, err := run([]string{"program", "peers", output})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if got != "127.0.0.1:6881" {
+		t.Errorf("run() = %v, want %v", got, "127.0.0.1:6881")
+	}
+}
+
+func TestPeersIPv6(t *testing.T) {
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := bencode.Marshal(map[string]any{
+			"interval": 1800,
+			"peers":    []any{map[string]any{"ip": "2001:db8::1", "port": 6881}},
+			"peers6":   append(netip.MustParseAddr("2001:db8::2").AsSlice(), 0x1a, 0xe2),
+		})
+		w.Write(body)
+	}))
+	defer tracker.Close()
+
+	dir := t.TempDir()
+	input := dir + "/artifact.txt"
+	output := dir + "/artifact.torrent"
+	if err := os.WriteFile(input, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	if _, err := run([]string{"program", "create", "-o", output, "-t", tracker.URL + "/announce", input}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	got, err := run([]string{"program", "peers", output})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if want := "[2001:db8::1]:6881\n[2001:db8::2]:6882"; got != want {
+		t.Errorf("run() = %q, want %q", got, want)
+	}
+}
+
+func TestPeersFailover(t *testing.T) {
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := bencode.Marshal(map[string]any{"interval": 1800, "peers": []byte{10, 0, 0, 1, 0x1a, 0xe1}})
+		w.Write(body)
+	}))
+	defer tracker.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	dir := t.TempDir()
+	input := dir + "/artifact.txt"
+	output := dir + "/artifact.torrent"
+	if err := os.WriteFile(input, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	if _, err := run([]string{"program", "create", "-o", output, "-t", dead.URL + "/announce", "-t", tracker.URL + "/announce", input}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	got, err := run([]string{"program", "peers", output})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if want := "10.0.0.1:6881"; got != want {
+		t.Errorf("run() = %q, want %q", got, want)
+	}
+}
+
+func TestScrape(t *testing.T) {
+	var scrapes int
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" {
+			http.NotFound(w, r)
+			return
+		}
+		scrapes++
+		files := make(map[string]any)
+		for i, infoHash := range r.URL.Query()["info_hash"] {
+			files[infoHash] = map[string]any{"complete": 10 + i, "incomplete": 3, "downloaded": 42}
+		}
+		body, _ := bencode.Marshal(map[string]any{"files": files})
+		w.Write(body)
+	}))
+	defer tracker.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	dir := t.TempDir()
+	create := func(name string, trackers ...string) string {
+		input := dir + "/" + name
+		output := input + ".torrent"
+		if err := os.WriteFile(input, []byte(name), 0o644); err != nil {
+			t.Fatalf("failed to write input: %v", err)
+		}
+		args := []string{"program", "create", "-o", output}
+		for _, tracker := range trackers {
+			args = append(args, "-t", tracker+"/announce")
+		}
+		if _, err := run(append(args, input)); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		return output
+	}
+	infoHash := func(path string) string {
+		info, err := knownMetadata(path)
+		if err != nil {
+			t.Fatalf("knownMetadata() error = %v", err)
+		}
+		return hex.EncodeToString(info.InfoHash[:])
+	}
+	first := create("first.txt", tracker.URL)
+	second := create("second.txt", dead.URL, tracker.URL)
+	orphan := create("orphan.txt", dead.URL)
+
+	got, err := run([]string{"program", "scrape", first, second, orphan})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	want := "first.txt\nInfo Hash: " + infoHash(first) + "\nTracker: " + tracker.URL + "/announce\nSeeders: 10\nLeechers: 3\nDownloaded: 42\n\n" +
+		"second.txt\nInfo Hash: " + infoHash(second) + "\nTracker: " + tracker.URL + "/announce\nSeeders: 10\nLeechers: 3\nDownloaded: 42\n\n" +
+		"orphan.txt\nInfo Hash: " + infoHash(orphan) + "\nError: " + dead.URL + "/announce: "
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("run() = %q, want prefix %q", got, want)
+	}
+	if scrapes != 2 {
+		t.Errorf("tracker scraped %d times, want 2", scrapes)
+	}
+
+	if _, err := run([]string{"program", "scrape", orphan}); err == nil {
+		t.Errorf("run() expected error when every scrape fails")
+	}
+	if _, err := run([]string{"program", "scrape"}); err == nil {
+		t.Errorf("run() expected usage error")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	dir := t.TempDir()
+	input := dir + "/artifact.txt"
+	output := dir + "/artifact.torrent"
+	stateFile := dir + "/tracker.dat"
+	if err := os.WriteFile(input, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listening := make(chan net.Addr, 1)
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	allowed := strings.Repeat("ab", 20)
+	go func() {
+		output, err := runTracker(ctx, []string{"program", "tracker", "--listen", "127.0.0.1:0", "--allow", allowed, "--state", stateFile}, func(addr net.Addr) { listening <- addr })
+		done <- result{output, err}
+	}()
+	var announceURL string
+	select {
+	case addr := <-listening:
+		announceURL = "http://" + addr.String() + "/announce"
+	case r := <-done:
+		t.Fatalf("runTracker() = %q, %v before listening", r.output, r.err)
+	}
+
+	if _, err := run([]string{"program", "create", "-o", output, "-t", announceURL, input}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	info, err := knownMetadata(output)
+	if err != nil {
+		t.Fatalf("knownMetadata() error = %v", err)
+	}
+
+	// Only allowed torrents are served.
+	if _, err := run([]string{"program", "peers", output}); err == nil || !strings.Contains(err.Error(), "torrent not allowed") {
+		t.Errorf("run() error = %v, want torrent not allowed", err)
+	}
+	cancel()
+	if r := <-done; r.err != nil {
+		t.Fatalf("runTracker() error = %v", r.err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		output, err := runTracker(ctx, []string{"program", "tracker", "--listen", "127.0.0.1:0", "--allow", output, "--state", stateFile}, func(addr net.Addr) { listening <- addr })
+		done <- result{output, err}
+	}()
+	announceURL = "http://" + (<-listening).String() + "/announce"
+	if _, err := run([]string{"program", "create", "-o", output, "-t", announceURL, input}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	seeder := &torrent.HTTPTracker{URL: announceURL, Client: http.DefaultClient}
+	if _, err := seeder.Announce(context.Background(), &torrent.AnnounceRequest{InfoHash: info.InfoHash, PeerID: [20]byte{1}, Port: 7001}); err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	got, err := run([]string{"program", "peers", output})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if want := "127.0.0.1:7001"; got != want {
+		t.Errorf("run() = %q, want %q", got, want)
+	}
+	got, err = run([]string{"program", "scrape", output})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if !strings.Contains(got, "Seeders: 1\nLeechers: 1\n") {
+		t.Errorf("run() = %q, want 1 seeder and 1 leecher", got)
+	}
+
+	cancel()
+	if r := <-done; r.err != nil || r.output != "Tracker stopped" {
+		t.Fatalf("runTracker() = %q, %v", r.output, r.err)
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Errorf("expected the tracker to save its state: %v", err)
+	}
+
+	if _, err := run([]string{"program", "tracker", "extra"}); err == nil {
+		t.Errorf("run() expected usage error")
+	}
+}
+
+// fakeSeeder serves data to any number of connections, as a peer that has
+// every piece. If corrupt, it flips a byte of every block it sends.
+func fakeSeeder(t *testing.T, info *torrent.Metadata, data []byte, corrupt bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		handshake := make([]byte, torrent.HandshakeLength)
+		if _, err := io.ReadFull(conn, handshake); err != nil {
+			return
+		}
+		copy(handshake[48:], "-FAKE-SEEDER-0000000")
+		conn.Write(handshake)
+
+		r := peerwire.NewReader(conn)
+		for {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch msg := msg.(type) {
+			case peerwire.Interested:
+				bitfield := make(peerwire.Bitfield, (len(info.PieceHashes)+7)/8)
+				for i := range bitfield {
+					bitfield[i] = 0xff
+				}
+				peerwire.WriteMessages(conn, bitfield, peerwire.Unchoke{})
+			case peerwire.Request:
+				start := int64(msg.Index)*int64(info.PieceLength) + int64(msg.Begin)
+				block := bytes.Clone(data[start : start+int64(msg.Length)])
+				if corrupt {
+					block[0] ^= 0xff
+				}
+				peerwire.WriteMessages(conn, peerwire.Piece{Index: msg.Index, Begin: msg.Begin, Block: block})
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDownloadVerified(t *testing.T) {
+	dir := t.TempDir()
+	input := dir + "/artifact.bin"
+	torrentFile := dir + "/artifact.torrent"
+	data := make([]byte, 40000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(input, data, 0o644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	var peers atomic.Value
+	var mu sync.Mutex
+	var events []string
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.URL.Query().Get("event"))
+		mu.Unlock()
+		body, _ := bencode.Marshal(map[string]any{"interval": 1800, "peers": peers.Load().([]byte)})
+		w.Write(body)
+	}))
+	defer tracker.Close()
+	if _, err := run([]string{"program", "create", "-o", torrentFile, "-t", tracker.URL + "/announce", "-l", "16384", input}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	info, err := knownMetadata(torrentFile)
+	if err != nil {
+		t.Fatalf("knownMetadata() error = %v", err)
+	}
+
+	compact := func(addrs ...string) []byte {
+		var b []byte
+		for _, addr := range addrs {
+			ap := netip.MustParseAddrPort(addr)
+			ip := ap.Addr().As4()
+			b = append(append(b, ip[:]...), byte(ap.Port()>>8), byte(ap.Port()))
+		}
+		return b
+	}
+	bad := fakeSeeder(t, info, data, true)
+	good := fakeSeeder(t, info, data, false)
+
+	// With only a corrupt peer, nothing is written.
+	peers.Store(compact(bad))
+	pieceFile := dir + "/piece"
+	if _, err := run([]string{"program", "download_piece", "-o", pieceFile, torrentFile, "1"}); err == nil || !strings.Contains(err.Error(), "failed its hash check") {
+		t.Errorf("run() error = %v, want a hash check failure", err)
+	}
+	if _, err := os.Stat(pieceFile); !os.IsNotExist(err) {
+		t.Errorf("expected no output file after a failed hash check, got %v", err)
+	}
+
+	// The piece is retried on the next peer.
+	peers.Store(compact(bad, good))
+	if _, err := run([]string{"program", "download_piece", "-o", pieceFile, torrentFile, "1"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(pieceFile); !bytes.Equal(got, data[16384:32768]) {
+		t.Errorf("download_piece wrote %d bytes that differ from piece 1", len(got))
+	}
+
+	mu.Lock()
+	events = nil
+	mu.Unlock()
+	output := dir + "/output.bin"
+	if _, err := run([]string{"program", "download", "-o", output, torrentFile}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, data) {
+		t.Errorf("download wrote %d bytes that differ from the input", len(got))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"started", "completed", "stopped"}; !slices.Equal(events, want) {
+		t.Errorf("download announced %q, want %q", events, want)
+	}
+}
+
+func TestDownloadPieceIndex(t *testing.T) {
+	dir := t.TempDir()
+	input := dir + "/artifact.bin"
+	torrentFile := dir + "/artifact.torrent"
+	if err := os.WriteFile(input, make([]byte, 40000), 0o644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	// The tracker is never contacted for an index out of range.
+	if _, err := run([]string{"program", "create", "-o", torrentFile, "-t", "http://127.0.0.1:1/announce", "-l", "16384", input}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	for _, index := range []string{"-1", "3", "99999999999"} {
+		_, err := run([]string{"program", "download_piece", "-o", dir + "/piece", torrentFile, index})
+		if err == nil || !strings.HasPrefix(err.Error(), "Invalid piece index") {
+			t.Errorf("run() with piece %s error = %v, want an invalid piece index", index, err)
+		}
+	}
+	if _, err := run([]string{"program", "download_piece", "-o", dir + "/piece", torrentFile}); err == nil || !strings.HasPrefix(err.Error(), "Usage") {
+		t.Errorf("run() without a piece index error = %v, want usage", err)
+	}
+}
+
+func TestDownloadPieceAdvertisesPeers(t *testing.T) {
+	data := bytes.Repeat([]byte{0x2a}, 100)
+	sum := sha1.Sum(data)
+	info := &torrent.Metadata{InfoHash: [20]byte{1}, PieceLength: 16384, Length: int64(len(data)), PieceHashes: []string{hex.EncodeToString(sum[:])}}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The peer only unchokes once we have told it about the other peers.
+	advertised := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handshake := make([]byte, torrent.HandshakeLength)
+		if _, err := io.ReadFull(conn, handshake); err != nil {
+			return
+		}
+		handshake[20+5] |= 0x10
+		conn.Write(handshake)
+		payload, _ := bencode.Marshal(torrent.ExtendedHandshake{M: map[string]int{"ut_pex": 1}})
+		peerwire.WriteMessages(conn, peerwire.Extended{ID: 0, Payload: payload})
+
+		r := peerwire.NewReader(conn)
+		for {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch msg := msg.(type) {
+			case peerwire.Extended:
+				if msg.ID != 1 {
+					continue
+				}
+				var pex struct {
+					Added []byte `bencode:"added"`
+				}
+				bencode.Unmarshal(msg.Payload, &pex)
+				advertised <- pex.Added
+				peerwire.WriteMessages(conn, peerwire.Unchoke{})
+			case peerwire.Request:
+				peerwire.WriteMessages(conn, peerwire.Piece{Index: msg.Index, Begin: msg.Begin, Block: data[msg.Begin : msg.Begin+msg.Length]})
+			}
+		}
+	}()
+
+	peerAddr := ln.Addr().String()
+	pool := torrent.NewPeerPool()
+	pool.Add(netip.MustParseAddrPort(peerAddr), 0, torrent.SourceTracker)
+	pool.Add(netip.MustParseAddrPort("10.0.0.1:6881"), 0, torrent.SourceTracker)
+
+	var buffer bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- downloadPieceFrom(peerAddr, info, pool, &buffer, 0) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("downloadPieceFrom() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("downloadPieceFrom() did not advertise peers to the peer")
+	}
+	if got, want := <-advertised, []byte{10, 0, 0, 1, 0x1a, 0xe1}; !bytes.Equal(got, want) {
+		t.Errorf("advertised peers %v, want %v without the peer itself", got, want)
+	}
+	if !bytes.Equal(buffer.Bytes(), data) {
+		t.Errorf("downloadPieceFrom() wrote %v, want %v", buffer.Bytes(), data)
+	}
+}
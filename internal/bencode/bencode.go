@@ -5,209 +5,358 @@ package bencode
 import (
 	"bytes"
 	"fmt"
-	"sort"
+	"math/big"
 	"strconv"
-	"unicode"
 )
 
-func decodeString(bencodedString []byte) ([]byte, int, error) {
-	var firstColonIndex int
-	for i := 0; i < len(bencodedString); i++ {
-		if bencodedString[i] == ':' {
-			firstColonIndex = i
-			break
-		}
+// A SyntaxError describes malformed bencode input.
+type SyntaxError struct {
+	Offset int    // byte offset in the input where the error was detected
+	Msg    string // description of the error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// decodeState tracks the position of a decoder in its input.
+type decodeState struct {
+	data      []byte
+	off       int
+	strict    bool // reject input that is not in canonical form
+	useBigInt bool // decode integers that overflow int64 as *big.Int
+	limits    Limits
+
+	depth    int // lists and dictionaries currently open
+	elements int // values decoded so far
+}
+
+func (d *decodeState) syntaxError(offset int, format string, args ...any) error {
+	return &SyntaxError{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// str decodes the byte string at the current offset. The result aliases the
+// input.
+func (d *decodeState) str() ([]byte, error) {
+	start := d.off
+	if err := d.count(); err != nil {
+		return nil, err
+	}
+	colon := bytes.IndexByte(d.data[start:], ':')
+	if colon == -1 {
+		return nil, d.syntaxError(start, "invalid string: missing ':'")
 	}
 
-	lengthStr := bencodedString[:firstColonIndex]
+	lengthStr := d.data[start : start+colon]
 	length, err := strconv.Atoi(string(lengthStr))
-	if err != nil {
-		return []byte{}, 0, fmt.Errorf("invalid string length: %v", err)
+	if err != nil || length < 0 {
+		return nil, d.syntaxError(start, "invalid string length %q", lengthStr)
+	}
+	if d.strict && !isCanonicalLength(lengthStr) {
+		return nil, d.syntaxError(start, "non-canonical string length %q", lengthStr)
+	}
+	if d.limits.MaxStringLength > 0 && length > d.limits.MaxStringLength {
+		return nil, limitError(ErrMaxStringLength, start)
 	}
 
-	if firstColonIndex+1+length > len(bencodedString) {
-		return []byte{}, 0, fmt.Errorf("string length exceeds input length")
+	begin := start + colon + 1
+	if length > len(d.data)-begin {
+		return nil, d.syntaxError(start, "string length %d exceeds input length", length)
 	}
 
-	return bencodedString[firstColonIndex+1 : firstColonIndex+1+length], firstColonIndex + 1 + length, nil
+	d.off = begin + length
+	return d.data[begin:d.off], nil
 }
 
-func decodeInteger(bencodedString []byte) (int, int, error) {
-	// Expect 'i' prefix
-	if len(bencodedString) == 0 || bencodedString[0] != 'i' {
-		return 0, 0, fmt.Errorf("invalid integer format: missing 'i' prefix")
+// integer decodes the integer at the current offset and returns its digits,
+// leaving the conversion to a Go type to the caller.
+func (d *decodeState) integer() ([]byte, error) {
+	start := d.off
+	if err := d.count(); err != nil {
+		return nil, err
 	}
-
-	lastIndex := bytes.IndexByte(bencodedString, 'e')
-	if lastIndex == -1 {
-		return 0, 0, fmt.Errorf("invalid integer format: missing 'e'")
+	end := bytes.IndexByte(d.data[start:], 'e')
+	if end == -1 {
+		return nil, d.syntaxError(start, "invalid integer: missing 'e'")
 	}
 
 	// Get the number string between 'i' and 'e'
-	numStr := bencodedString[1:lastIndex]
-	num, err := strconv.Atoi(string(numStr))
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid integer: %v", err)
+	numStr := d.data[start+1 : start+end]
+	if d.strict && !isCanonicalInteger(numStr) {
+		return nil, d.syntaxError(start, "non-canonical integer %q", numStr)
+	}
+	if !isInteger(numStr) {
+		return nil, d.syntaxError(start, "invalid integer %q", numStr)
 	}
 
-	return num, lastIndex + 1, nil
+	d.off = start + end + 1
+	return numStr, nil
 }
 
-func decodeList(bencodedString []byte) ([]any, int, error) {
-	if len(bencodedString) == 0 || bencodedString[0] != 'l' {
-		return nil, 0, fmt.Errorf("invalid list format: missing 'l' prefix")
+// int64 decodes the integer at the current offset as an int64, or as a
+// *big.Int if it does not fit and d.useBigInt is set.
+func (d *decodeState) int64() (any, error) {
+	start := d.off
+	numStr, err := d.integer()
+	if err != nil {
+		return nil, err
 	}
 
-	list := make([]any, 0)
-	consumed := 1 // Start with 1 for the 'l'
-	remaining := bencodedString[1:]
+	num, err := strconv.ParseInt(string(numStr), 10, 64)
+	if err == nil {
+		return num, nil
+	}
+	if d.useBigInt {
+		b, _ := new(big.Int).SetString(string(numStr), 10)
+		return b, nil
+	}
+	return nil, d.syntaxError(start, "integer %s overflows int64", numStr)
+}
 
-	for len(remaining) > 0 && remaining[0] != 'e' {
-		decoded, i, err := Decode(remaining)
-		if err != nil {
-			return nil, 0, err
-		}
-		list = append(list, decoded)
-		consumed += i
-		remaining = remaining[i:]
+// key decodes a dictionary key. In strict mode keys must be unique and
+// sorted, so each key is compared with the previous one, if any.
+func (d *decodeState) key(prev []byte, first bool) ([]byte, error) {
+	start := d.off
+	if c := d.data[start]; c < '0' || c > '9' {
+		return nil, d.syntaxError(start, "dictionary key must be a string, got %q", c)
 	}
 
-	if len(remaining) == 0 || remaining[0] != 'e' {
-		return nil, 0, fmt.Errorf("unterminated list")
+	key, err := d.str()
+	if err != nil {
+		return nil, err
 	}
 
-	return list, consumed + 1, nil // +1 for the 'e'
+	if d.strict && !first {
+		switch bytes.Compare(prev, key) {
+		case 0:
+			return nil, d.syntaxError(start, "duplicate dictionary key %q", key)
+		case 1:
+			return nil, d.syntaxError(start, "dictionary key %q is not sorted after %q", key, prev)
+		}
+	}
+	return key, nil
 }
 
-func decodeDictionary(bencodedString []byte) (map[string]any, int, error) {
-	if len(bencodedString) == 0 || bencodedString[0] != 'd' {
-		return nil, 0, fmt.Errorf("invalid dictionary format: missing 'd' prefix")
+// value decodes the value at the current offset into the types documented on
+// Decode.
+func (d *decodeState) value() (any, error) {
+	if d.off >= len(d.data) {
+		return nil, d.syntaxError(d.off, "unexpected end of input")
 	}
 
-	dict := make(map[string]any)
-	consumed := 1 // Start with 1 for the 'd'
-	remaining := bencodedString[1:]
-	for len(remaining) > 0 && remaining[0] != 'e' {
-		key, i, err := decodeString(remaining)
+	switch c := d.data[d.off]; {
+	case c >= '0' && c <= '9':
+		s, err := d.str()
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
-		consumed += i
-		remaining = remaining[i:]
+		return s, nil
+	case c == 'i':
+		return d.int64()
+	case c == 'l':
+		return d.list()
+	case c == 'd':
+		return d.dictionary()
+	default:
+		return nil, d.syntaxError(d.off, "invalid character %q at start of value", c)
+	}
+}
 
-		value, i, err := Decode(remaining)
+func (d *decodeState) list() ([]any, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	d.off++ // 'l'
+
+	list := make([]any, 0)
+	for d.off < len(d.data) && d.data[d.off] != 'e' {
+		decoded, err := d.value()
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
-		consumed += i
-		remaining = remaining[i:]
-
-		dict[string(key)] = value
+		list = append(list, decoded)
 	}
 
-	return dict, consumed + 1, nil
+	if d.off >= len(d.data) {
+		return nil, d.syntaxError(d.off, "unterminated list")
+	}
+	d.off++ // 'e'
+	return list, nil
 }
 
-// Decode takes a bencoded string and decodes it into a Go value.
-// It returns the decoded value, the number of bytes consumed, and any error encountered.
-func Decode(bencodedString []byte) (any, int, error) {
-	if len(bencodedString) == 0 {
-		return "", 0, fmt.Errorf("empty string is not valid bencode")
+func (d *decodeState) dictionary() (map[string]any, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
 	}
+	defer d.leave()
+	d.off++ // 'd'
 
-	switch {
-	case unicode.IsDigit(rune(bencodedString[0])):
-		value, i, err := decodeString(bencodedString)
-		return value, i, err
-
-	case bencodedString[0] == 'i':
-		value, i, err := decodeInteger(bencodedString[0:])
-		return value, i, err
-
-	case bencodedString[0] == 'l':
-		value, i, err := decodeList(bencodedString)
-		return value, i, err
-
-	case bencodedString[0] == 'd':
-		value, i, err := decodeDictionary(bencodedString)
-		return value, i, err
+	dict := make(map[string]any)
+	var prev []byte
+	for first := true; d.off < len(d.data) && d.data[d.off] != 'e'; first = false {
+		key, err := d.key(prev, first)
+		if err != nil {
+			return nil, err
+		}
+		prev = key
 
-	default:
-		return "", 0, fmt.Errorf("unsupported bencode type")
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		dict[string(key)] = value
 	}
-}
 
-func Encode(value any) ([]byte, error) {
-	switch value.(type) {
-	case []byte:
-		return encodeByteSlice(value.([]byte))
-	case int:
-		return encodeInteger(value.(int))
-	case []any:
-		return encodeList(value.([]any))
-	case map[string]any:
-		return encodeDictionary(value.(map[string]any))
+	if d.off >= len(d.data) {
+		return nil, d.syntaxError(d.off, "unterminated dictionary")
 	}
-
-	return []byte{}, nil
+	d.off++ // 'e'
+	return dict, nil
 }
 
-func encodeDictionary(m map[string]any) (result []byte, err error) {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
+// skip consumes the value at the current offset without storing it.
+func (d *decodeState) skip() error {
+	if d.off >= len(d.data) {
+		return d.syntaxError(d.off, "unexpected end of input")
 	}
-	sort.Strings(keys)
-
-	result = append(result, 'd')
 
-	for _, k := range keys {
-		keyEnc, err := Encode([]byte(k))
-		if err != nil {
-			return result, err
+	switch c := d.data[d.off]; {
+	case c >= '0' && c <= '9':
+		_, err := d.str()
+		return err
+	case c == 'i':
+		_, err := d.integer()
+		return err
+	case c == 'l':
+		if err := d.enter(); err != nil {
+			return err
 		}
-		result = append(result, keyEnc...)
-		valueEnc, err := Encode(m[k])
-		if err != nil {
-			return result, err
+		defer d.leave()
+		d.off++
+		for d.off < len(d.data) && d.data[d.off] != 'e' {
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+		if d.off >= len(d.data) {
+			return d.syntaxError(d.off, "unterminated list")
+		}
+	case c == 'd':
+		if err := d.enter(); err != nil {
+			return err
 		}
-		result = append(result, valueEnc...)
+		defer d.leave()
+		d.off++
+		var prev []byte
+		for first := true; d.off < len(d.data) && d.data[d.off] != 'e'; first = false {
+			key, err := d.key(prev, first)
+			if err != nil {
+				return err
+			}
+			prev = key
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+		if d.off >= len(d.data) {
+			return d.syntaxError(d.off, "unterminated dictionary")
+		}
+	default:
+		return d.syntaxError(d.off, "invalid character %q at start of value", c)
 	}
 
-	result = append(result, 'e')
+	d.off++ // 'e'
+	return nil
+}
 
-	return result, err
+// isInteger reports whether s is a base-ten integer with an optional sign.
+func isInteger(s []byte) bool {
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
 
-func encodeList(i []any) (result []byte, err error) {
-	result = append(result, 'l')
+// isCanonicalInteger reports whether s is a base-ten integer without leading
+// zeros, a "+" sign or a negative zero, as BEP 3 requires.
+func isCanonicalInteger(s []byte) bool {
+	if len(s) > 0 && s[0] == '-' {
+		s = s[1:]
+		if len(s) > 0 && s[0] == '0' {
+			return false
+		}
+	}
+	return isCanonicalLength(s)
+}
 
-	for _, item := range i {
-		r, err := Encode(item)
-		if err != nil {
-			return result, err
+// isCanonicalLength reports whether s is a non-negative base-ten integer
+// without leading zeros.
+func isCanonicalLength(s []byte) bool {
+	if len(s) == 0 || (s[0] == '0' && len(s) > 1) {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
 		}
-		result = append(result, r...)
 	}
+	return true
+}
 
-	result = append(result, 'e')
+// Decode takes a bencoded string and decodes it into a Go value.
+// It returns the decoded value, the number of bytes consumed, and any error encountered.
+//
+// Byte strings decode to []byte aliasing the input, integers to int64, lists
+// to []any and dictionaries to map[string]any. Integers that do not fit in an
+// int64 are reported as errors; use a Decoder with UseBigInt to accept them.
+// Malformed input is reported as a *SyntaxError. Decoding is bounded by
+// DefaultLimits.
+func Decode(bencodedString []byte) (any, int, error) {
+	if len(bencodedString) == 0 {
+		return nil, 0, &SyntaxError{Offset: 0, Msg: "empty string is not valid bencode"}
+	}
 
-	return result, err
+	d, err := newDecodeState(bencodedString, DefaultLimits)
+	if err != nil {
+		return nil, 0, err
+	}
+	value, err := d.value()
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, d.off, nil
 }
 
-func encodeInteger(i int) (result []byte, err error) {
-	result = append(result, 'i')
-	result = append(result, strconv.Itoa(i)...)
-	result = append(result, 'e')
+// DecodeStrict is like Decode, but also rejects input that is not in the
+// canonical form required by BEP 3: integers and string lengths with leading
+// zeros, negative zero, and dictionaries whose keys are unsorted or repeated.
+func DecodeStrict(bencodedString []byte) (any, int, error) {
+	if len(bencodedString) == 0 {
+		return nil, 0, &SyntaxError{Offset: 0, Msg: "empty string is not valid bencode"}
+	}
 
-	return result, err
+	d, err := newDecodeState(bencodedString, DefaultLimits)
+	if err != nil {
+		return nil, 0, err
+	}
+	d.strict = true
+	value, err := d.value()
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, d.off, nil
 }
 
-func encodeByteSlice(i []byte) (result []byte, err error) {
-	length := len(i)
-	result = append(result, strconv.Itoa(length)...)
-	result = append(result, ':')
-	result = append(result, i...)
-
-	return result, err
+// Encode returns the bencoding of value. It is equivalent to Marshal.
+func Encode(value any) ([]byte, error) {
+	return Marshal(value)
 }
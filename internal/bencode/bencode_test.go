@@ -1,7 +1,15 @@
 package bencode
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -33,13 +41,13 @@ func TestDecode(t *testing.T) {
 		{
 			name:     "single number",
 			input:    "i1e",
-			expected: 1,
+			expected: int64(1),
 			wantErr:  false,
 		},
 		{
 			name:     "two digit number",
 			input:    "i12e",
-			expected: 12,
+			expected: int64(12),
 			wantErr:  false,
 		},
 		{
@@ -57,7 +65,7 @@ func TestDecode(t *testing.T) {
 			input: "l5:helloi1ee",
 			expected: []interface{}{
 				[]byte("hello"),
-				1,
+				int64(1),
 			},
 		},
 		{
@@ -71,7 +79,7 @@ func TestDecode(t *testing.T) {
 			input: "lli956e5:appleee",
 			expected: []interface{}{
 				[]interface{}{
-					956,
+					int64(956),
 					[]byte("apple"),
 				},
 			},
@@ -83,9 +91,9 @@ func TestDecode(t *testing.T) {
 			expected: []interface{}{
 				[]interface{}{
 					[]byte("hello"),
-					1,
+					int64(1),
 				},
-				2,
+				int64(2),
 			},
 			wantErr: false,
 		},
@@ -94,8 +102,8 @@ func TestDecode(t *testing.T) {
 			input: "l5:helloi1ei2ee",
 			expected: []interface{}{
 				[]byte("hello"),
-				1,
-				2,
+				int64(1),
+				int64(2),
 			},
 			wantErr: false,
 		},
@@ -104,7 +112,7 @@ func TestDecode(t *testing.T) {
 			input: "d3:foo3:bar5:helloi52ee",
 			expected: map[string]interface{}{
 				"foo":   []byte("bar"),
-				"hello": 52,
+				"hello": int64(52),
 			},
 		},
 	}
@@ -195,6 +203,62 @@ func TestEncode(t *testing.T) {
 			},
 			want: []byte("d3:foo3:bar5:helloi123ee"),
 		},
+		{
+			name: "string",
+			args: args{
+				value: "hello",
+			},
+			want: []byte("5:hello"),
+		},
+		{
+			name: "typed map",
+			args: args{
+				value: map[string]string{"foo": "bar"},
+			},
+			want: []byte("d3:foo3:bare"),
+		},
+		{
+			name: "typed slice",
+			args: args{
+				value: []int64{1, 2},
+			},
+			want: []byte("li1ei2ee"),
+		},
+		{
+			name: "bools",
+			args: args{
+				value: []bool{true, false},
+			},
+			want: []byte("li1ei0ee"),
+		},
+		{
+			name: "text marshaler",
+			args: args{
+				value: netip.MustParseAddr("::1"),
+			},
+			want: []byte("3:::1"),
+		},
+		{
+			name: "marshaler",
+			args: args{
+				value: map[string]any{"v": upperMarshaler("abc")},
+			},
+			want: []byte("d1:v3:ABCe"),
+		},
+		{
+			name: "marshaler returning invalid bencode",
+			args: args{
+				value: RawMessage("i1"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "float",
+			args: args{
+				value: 1.5,
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -203,9 +267,239 @@ func TestEncode(t *testing.T) {
 				t.Errorf("Encode() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Encode() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestDecodeSyntaxErrorOffset(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantOffset int
+	}{
+		{name: "empty input", input: "", wantOffset: 0},
+		{name: "invalid character", input: "li1ex", wantOffset: 4},
+		{name: "missing e", input: "i12", wantOffset: 0},
+		{name: "string too long", input: "l10:helloe", wantOffset: 1},
+		{name: "unterminated list", input: "li1e", wantOffset: 4},
+		{name: "unterminated dictionary", input: "d3:fooi1e", wantOffset: 9},
+		{name: "non-string key", input: "di1ei2ee", wantOffset: 1},
+		{name: "negative string length", input: "-1:a", wantOffset: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Decode([]byte(tt.input))
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Decode() error = %v, want *SyntaxError", err)
+			}
+			if syntaxErr.Offset != tt.wantOffset {
+				t.Errorf("SyntaxError.Offset = %d, want %d (%v)", syntaxErr.Offset, tt.wantOffset, err)
+			}
+		})
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantOffset int
+		wantErr    bool
+	}{
+		{name: "canonical dictionary", input: "d1:ai0e1:bli-1e0:ee"},
+		{name: "leading zero", input: "i03e", wantErr: true, wantOffset: 0},
+		{name: "negative zero", input: "li-0ee", wantErr: true, wantOffset: 1},
+		{name: "plus sign", input: "i+3e", wantErr: true, wantOffset: 0},
+		{name: "string length leading zero", input: "03:abc", wantErr: true, wantOffset: 0},
+		{name: "unsorted keys", input: "d1:bi1e1:ai2ee", wantErr: true, wantOffset: 7},
+		{name: "duplicate keys", input: "d1:ai1e1:ai2ee", wantErr: true, wantOffset: 7},
+		{name: "unsorted nested keys", input: "ld1:bi1e1:ai2eee", wantErr: true, wantOffset: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, n, err := DecodeStrict([]byte(tt.input))
+			if !tt.wantErr {
+				if err != nil || n != len(tt.input) {
+					t.Errorf("DecodeStrict() = %d, %v, want %d, nil", n, err, len(tt.input))
+				}
+				return
+			}
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("DecodeStrict() error = %v, want *SyntaxError", err)
+			}
+			if syntaxErr.Offset != tt.wantOffset {
+				t.Errorf("SyntaxError.Offset = %d, want %d (%v)", syntaxErr.Offset, tt.wantOffset, err)
+			}
+
+			// Decode stays lenient for the same input.
+			if _, _, err := Decode([]byte(tt.input)); err != nil {
+				t.Errorf("Decode() error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		"5:hello", "i-42e", "i03e", "le", "l5:helloi1ee", "d3:foo3:bar5:helloi52ee",
+		"d1:bi1e1:ai2ee", "lli956e5:appleee", "d4:infod6:lengthi1e4:name1:xee",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded, n, err := Decode(data)
+		if err != nil {
+			return
+		}
+		if n <= 0 || n > len(data) {
+			t.Fatalf("Decode() consumed %d of %d bytes", n, len(data))
+		}
+
+		encoded, err := Encode(decoded)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		redecoded, m, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("Decode() of re-encoded value error = %v", err)
+		}
+		if m != len(encoded) || !reflect.DeepEqual(redecoded, decoded) {
+			t.Fatalf("round trip = %v, want %v", redecoded, decoded)
+		}
+
+		// Canonical input re-encodes to exactly the same bytes.
+		if _, _, err := DecodeStrict(data[:n]); err == nil && !bytes.Equal(encoded, data[:n]) {
+			t.Fatalf("Encode() = %q, want %q", encoded, data[:n])
+		}
+	})
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, seed := range []string{
+		"d8:announce1:x4:infod5:filesld6:lengthi1e4:pathl1:aeee4:name3:dir7:privatei1eee",
+		"d4:infod4:namei1eee", "d8:announce1:xe", "le",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var got testMetainfo
+		if err := Unmarshal(data, &got); err != nil {
+			return
+		}
+
+		encoded, err := Marshal(got)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		var again testMetainfo
+		if err := Unmarshal(encoded, &again); err != nil {
+			t.Fatalf("Unmarshal() of re-encoded value error = %v", err)
+		}
+		if !reflect.DeepEqual(normalize(again), normalize(got)) {
+			t.Fatalf("round trip = %+v, want %+v", again, got)
+		}
+	})
+}
+
+// normalize clears differences that do not survive a round trip: empty
+// slices that are omitted by omitempty decode as nil.
+func normalize(m testMetainfo) testMetainfo {
+	if m.Info != nil {
+		info := *m.Info
+		if len(info.Files) == 0 {
+			info.Files = nil
+		}
+		if len(info.Pieces) == 0 {
+			info.Pieces = nil
+		}
+		for i := range info.Files {
+			if len(info.Files[i].Path) == 0 {
+				info.Files[i].Path = nil
+			}
+		}
+		m.Info = &info
+	}
+	return m
+}
+
+// upperMarshaler encodes itself as an upper-cased byte string.
+type upperMarshaler string
+
+func (u upperMarshaler) MarshalBencode() ([]byte, error) {
+	s := strings.ToUpper(string(u))
+	return []byte(strconv.Itoa(len(s)) + ":" + s), nil
+}
+
+func TestEncodeUnsupportedType(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     any
+		wantType  string
+		wantField string
+	}{
+		{name: "nil", value: nil, wantType: "<nil>"},
+		{name: "float", value: 1.5, wantType: "float64"},
+		{name: "channel in list", value: []any{1, make(chan int)}, wantType: "chan int", wantField: "[1]"},
+		{name: "func in dictionary", value: map[string]any{"f": func() {}}, wantType: "func()", wantField: "f"},
+		{name: "non-string keys", value: map[int]string{1: "a"}, wantType: "map[int]string"},
+		{name: "complex struct field", value: struct{ C complex64 }{}, wantType: "complex64", wantField: "C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.value)
+			var typeErr *UnsupportedTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("Encode() = %q, %v, want *UnsupportedTypeError", got, err)
+			}
+			if fmt.Sprint(typeErr.Type) != tt.wantType || typeErr.Field != tt.wantField {
+				t.Errorf("UnsupportedTypeError = {%v %q}, want {%s %q}", typeErr.Type, typeErr.Field, tt.wantType, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestIntegerSizes(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+
+	encodeTests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{name: "int64 max", value: int64(math.MaxInt64), want: "i9223372036854775807e"},
+		{name: "int64 min", value: int64(math.MinInt64), want: "i-9223372036854775808e"},
+		{name: "uint64 max", value: uint64(math.MaxUint64), want: "i18446744073709551615e"},
+		{name: "uint32", value: uint32(math.MaxUint32), want: "i4294967295e"},
+		{name: "int8", value: int8(-8), want: "i-8e"},
+		{name: "big.Int", value: huge, want: "i-123456789012345678901234567890e"},
+	}
+	for _, tt := range encodeTests {
+		t.Run("encode "+tt.name, func(t *testing.T) {
+			got, err := Encode(tt.value)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("Encode() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+
+	got, _, err := Decode([]byte("i9223372036854775807e"))
+	if err != nil || got != int64(math.MaxInt64) {
+		t.Errorf("Decode() = %v, %v, want %d", got, err, int64(math.MaxInt64))
+	}
+
+	var syntaxErr *SyntaxError
+	if _, _, err := Decode([]byte("i9223372036854775808e")); !errors.As(err, &syntaxErr) {
+		t.Errorf("Decode() of int64 overflow error = %v, want *SyntaxError", err)
+	}
+}
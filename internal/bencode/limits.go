@@ -0,0 +1,76 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when decoding input that exceeds a Limits field. They are
+// wrapped with the offset at which the limit was hit; use errors.Is to test
+// for them.
+var (
+	ErrMaxDepth        = errors.New("maximum nesting depth exceeded")
+	ErrMaxStringLength = errors.New("maximum string length exceeded")
+	ErrMaxElements     = errors.New("maximum number of elements exceeded")
+	ErrMaxInputSize    = errors.New("maximum input size exceeded")
+)
+
+// Limits bounds the resources spent decoding a single top-level value, which
+// matters when the input comes from an untrusted tracker or peer. A zero
+// field means no limit.
+type Limits struct {
+	MaxDepth        int // nesting depth of lists and dictionaries
+	MaxStringLength int // length of any one byte string
+	MaxElements     int // strings, integers, lists and dictionaries, counting keys
+	MaxInputSize    int // encoded size of the value in bytes
+}
+
+// DefaultLimits are used by Decode, DecodeStrict, Unmarshal, Spans and new
+// Decoders. They are generous enough for the metainfo of very large torrents.
+var DefaultLimits = Limits{
+	MaxDepth:        512,
+	MaxStringLength: 128 << 20,
+	MaxElements:     4 << 20,
+	MaxInputSize:    256 << 20,
+}
+
+func limitError(err error, offset int) error {
+	return fmt.Errorf("%w at offset %d", err, offset)
+}
+
+// newDecodeState returns a decodeState for data, checking it against the
+// input size limit.
+func newDecodeState(data []byte, limits Limits) (*decodeState, error) {
+	if limits.MaxInputSize > 0 && len(data) > limits.MaxInputSize {
+		return nil, limitError(ErrMaxInputSize, limits.MaxInputSize)
+	}
+	return &decodeState{data: data, limits: limits}, nil
+}
+
+// count records that another element has been decoded.
+func (d *decodeState) count() error {
+	d.elements++
+	if d.limits.MaxElements > 0 && d.elements > d.limits.MaxElements {
+		return limitError(ErrMaxElements, d.off)
+	}
+	return nil
+}
+
+// enter records the start of a list or dictionary. Each successful call must
+// be paired with a call to leave.
+func (d *decodeState) enter() error {
+	d.depth++
+	if d.limits.MaxDepth > 0 && d.depth > d.limits.MaxDepth {
+		d.depth--
+		return limitError(ErrMaxDepth, d.off)
+	}
+	if err := d.count(); err != nil {
+		d.depth--
+		return err
+	}
+	return nil
+}
+
+func (d *decodeState) leave() {
+	d.depth--
+}
@@ -0,0 +1,110 @@
+package bencode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecoderLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		limits  Limits
+		wantErr error
+	}{
+		{
+			name:    "depth",
+			input:   "llleee",
+			limits:  Limits{MaxDepth: 2},
+			wantErr: ErrMaxDepth,
+		},
+		{
+			name:   "depth at limit",
+			input:  "llee",
+			limits: Limits{MaxDepth: 2},
+		},
+		{
+			name:    "string length",
+			input:   "6:abcdef",
+			limits:  Limits{MaxStringLength: 5},
+			wantErr: ErrMaxStringLength,
+		},
+		{
+			name:    "string length is checked before reading the body",
+			input:   "999999999999:",
+			limits:  Limits{MaxStringLength: 1 << 20},
+			wantErr: ErrMaxStringLength,
+		},
+		{
+			name:    "elements",
+			input:   "li1ei2ei3ee",
+			limits:  Limits{MaxElements: 3},
+			wantErr: ErrMaxElements,
+		},
+		{
+			name:   "elements at limit",
+			input:  "li1ei2ee",
+			limits: Limits{MaxElements: 3},
+		},
+		{
+			name:    "input size",
+			input:   "l5:hello5:worlde",
+			limits:  Limits{MaxInputSize: 10},
+			wantErr: ErrMaxInputSize,
+		},
+		{
+			name:    "input size of long integer",
+			input:   "i" + strings.Repeat("1", 100) + "e",
+			limits:  Limits{MaxInputSize: 10},
+			wantErr: ErrMaxInputSize,
+		},
+		{
+			name:   "no limits",
+			input:  strings.Repeat("l", 1000) + strings.Repeat("e", 1000),
+			limits: Limits{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoder(strings.NewReader(tt.input))
+			dec.SetLimits(tt.limits)
+
+			var v any
+			err := dec.Decode(&v)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Decode() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultLimits(t *testing.T) {
+	deep := []byte(strings.Repeat("l", DefaultLimits.MaxDepth+1) + strings.Repeat("e", DefaultLimits.MaxDepth+1))
+
+	if _, _, err := Decode(deep); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrMaxDepth)
+	}
+
+	var v any
+	if err := Unmarshal(deep, &v); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrMaxDepth)
+	}
+
+	if _, err := Spans(deep); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("Spans() error = %v, want %v", err, ErrMaxDepth)
+	}
+
+	// Skipped values are bounded as well.
+	var s struct{}
+	if err := Unmarshal(append(append([]byte("d1:x"), deep...), 'e'), &s); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("Unmarshal() of unknown field error = %v, want %v", err, ErrMaxDepth)
+	}
+}
@@ -0,0 +1,349 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Marshal returns the bencoding of v.
+//
+// Strings, byte slices and byte arrays are encoded as byte strings; integers
+// of any size, big.Int values and bools (as 0 or 1) as integers; slices and
+// arrays as lists; and maps with string keys and structs as dictionaries.
+// Pointers and interfaces are encoded as the value they point to.
+//
+// Types implementing Marshaler encode themselves, and types implementing
+// encoding.TextMarshaler are encoded as the byte string of their text form.
+// Any other type, such as a float, channel or function, or a map whose keys
+// are not strings, results in an *UnsupportedTypeError.
+//
+// Struct fields are encoded using the key given in the field's "bencode" tag,
+// or the field name if there is none. The "omitempty" option skips the field
+// when it holds the zero value for its type, and a tag of "-" always skips it.
+// Unexported fields, and nil pointers and interfaces, are never encoded.
+//
+//	Name   string `bencode:"name"`
+//	Length int    `bencode:"length,omitempty"`
+func Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	e := &encodeState{writer: &buf}
+	if err := e.marshal(reflect.ValueOf(v), ""); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Marshaler is the interface implemented by types that can marshal
+// themselves into a valid bencoded value.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// An UnsupportedTypeError is returned by Marshal when asked to encode a value
+// of a type that has no bencode representation.
+type UnsupportedTypeError struct {
+	Type  reflect.Type // nil for an untyped nil value
+	Field string       // path of the value, such as "info.files[0].length"
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	if e.Type == nil {
+		return fmt.Sprintf("unsupported nil value at %s", displayPath(e.Field))
+	}
+	return fmt.Sprintf("unsupported type %s at %s", e.Type, displayPath(e.Field))
+}
+
+var (
+	bigIntType        = reflect.TypeFor[big.Int]()
+	marshalerType     = reflect.TypeFor[Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// writer is implemented by both bytes.Buffer and bufio.Writer, so the same
+// encoder can build a slice for Marshal or stream to an Encoder.
+type writer interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// encodeState writes the bencoding of a value to its writer. Write errors are
+// not checked here; bufio.Writer reports them when it is flushed.
+type encodeState struct {
+	writer
+}
+
+func (e *encodeState) marshal(v reflect.Value, path string) error {
+	if !v.IsValid() {
+		return &UnsupportedTypeError{Field: path}
+	}
+
+	// Pointers and interfaces are unwrapped first so that methods are looked
+	// up on the value they hold.
+	if k := v.Kind(); k == reflect.Pointer || k == reflect.Interface {
+		if v.IsNil() {
+			return fmt.Errorf("cannot marshal nil %s at %s", v.Type(), displayPath(path))
+		}
+		return e.marshal(v.Elem(), path)
+	}
+
+	if m, ok := asInterface(v, marshalerType).(Marshaler); ok {
+		return e.marshalMarshaler(m, v.Type(), path)
+	}
+
+	if v.Type() == bigIntType {
+		if v.CanAddr() {
+			e.writeInteger(v.Addr().Interface().(*big.Int).String())
+		} else {
+			b := v.Interface().(big.Int)
+			e.writeInteger(b.String())
+		}
+		return nil
+	}
+
+	if m, ok := asInterface(v, textMarshalerType).(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return fmt.Errorf("error calling MarshalText for type %s at %s: %w", v.Type(), displayPath(path), err)
+		}
+		e.writeBytes(text)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			e.writeInteger("1")
+		} else {
+			e.writeInteger("0")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInteger(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeInteger(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return nil
+		}
+		return e.marshalList(v, path)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeBytes(b)
+			return nil
+		}
+		return e.marshalList(v, path)
+	case reflect.Map:
+		return e.marshalMap(v, path)
+	case reflect.Struct:
+		return e.marshalStruct(v, path)
+	default:
+		return &UnsupportedTypeError{Type: v.Type(), Field: path}
+	}
+
+	return nil
+}
+
+// asInterface returns v, or a pointer to v if only the pointer implements
+// iface, as an interface value. It returns nil if neither implements iface.
+func asInterface(v reflect.Value, iface reflect.Type) any {
+	switch {
+	case v.Type().Implements(iface):
+		return v.Interface()
+	case v.CanAddr() && v.Addr().Type().Implements(iface):
+		return v.Addr().Interface()
+	}
+	return nil
+}
+
+func (e *encodeState) marshalMarshaler(m Marshaler, t reflect.Type, path string) error {
+	b, err := m.MarshalBencode()
+	if err != nil {
+		return fmt.Errorf("error calling MarshalBencode for type %s at %s: %w", t, displayPath(path), err)
+	}
+
+	// Check the output is exactly one value so a bad Marshaler cannot
+	// corrupt the surrounding encoding.
+	d := &decodeState{data: b}
+	if err := d.skip(); err != nil || d.off != len(b) {
+		return fmt.Errorf("MarshalBencode for type %s at %s returned invalid bencode", t, displayPath(path))
+	}
+
+	e.Write(b)
+	return nil
+}
+
+func (e *encodeState) marshalList(v reflect.Value, path string) error {
+	e.WriteByte('l')
+	for i := 0; i < v.Len(); i++ {
+		if err := e.marshal(v.Index(i), indexPath(path, i)); err != nil {
+			return err
+		}
+	}
+	e.WriteByte('e')
+	return nil
+}
+
+func (e *encodeState) marshalMap(v reflect.Value, path string) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return &UnsupportedTypeError{Type: v.Type(), Field: path}
+	}
+
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+
+	e.WriteByte('d')
+	for _, k := range keys {
+		elem := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))
+		if isNilValue(elem) {
+			continue
+		}
+		e.writeString(k)
+		if err := e.marshal(elem, fieldPath(path, k)); err != nil {
+			return err
+		}
+	}
+	e.WriteByte('e')
+	return nil
+}
+
+func (e *encodeState) marshalStruct(v reflect.Value, path string) error {
+	e.WriteByte('d')
+	// Fields are sorted by key, which is the order bencode requires.
+	for _, f := range cachedFields(v.Type()) {
+		fv := v.Field(f.index)
+		if isNilValue(fv) || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		e.writeString(f.name)
+		if err := e.marshal(fv, fieldPath(path, f.name)); err != nil {
+			return err
+		}
+	}
+	e.WriteByte('e')
+	return nil
+}
+
+func (e *encodeState) writeString(s string) {
+	e.WriteString(strconv.Itoa(len(s)))
+	e.WriteByte(':')
+	e.WriteString(s)
+}
+
+func (e *encodeState) writeBytes(b []byte) {
+	e.WriteString(strconv.Itoa(len(b)))
+	e.WriteByte(':')
+	e.Write(b)
+}
+
+func (e *encodeState) writeInteger(digits string) {
+	e.WriteByte('i')
+	e.WriteString(digits)
+	e.WriteByte('e')
+}
+
+// field describes a struct field that is encoded as a dictionary entry.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedFields returns the encodable fields of t sorted by key.
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+func typeFields(t reflect.Type) []field {
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+
+		fields = append(fields, field{
+			name:      name,
+			index:     i,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// fieldPath appends a dictionary key to a value path such as "info.files[0]".
+func fieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// indexPath appends a list index to a value path.
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "top-level value"
+	}
+	return "field " + strconv.Quote(path)
+}
@@ -0,0 +1,169 @@
+package bencode
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testInfo struct {
+	Name        string     `bencode:"name"`
+	PieceLength int        `bencode:"piece length"`
+	Pieces      []byte     `bencode:"pieces"`
+	Length      int        `bencode:"length,omitempty"`
+	Files       []testFile `bencode:"files,omitempty"`
+	Private     *int       `bencode:"private,omitempty"`
+	Ignored     string     `bencode:"-"`
+	unexported  string
+}
+
+type testMetainfo struct {
+	Announce string    `bencode:"announce"`
+	Info     *testInfo `bencode:"info"`
+	Comment  string    `bencode:"comment,omitempty"`
+}
+
+func TestMarshal(t *testing.T) {
+	private := 1
+	tests := []struct {
+		name    string
+		value   any
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "string",
+			value: "hello",
+			want:  "5:hello",
+		},
+		{
+			name:  "byte slice",
+			value: []byte("hello"),
+			want:  "5:hello",
+		},
+		{
+			name:  "byte array",
+			value: [3]byte{'a', 'b', 'c'},
+			want:  "3:abc",
+		},
+		{
+			name:  "negative integer",
+			value: int64(-42),
+			want:  "i-42e",
+		},
+		{
+			name:  "unsigned integer",
+			value: uint16(42),
+			want:  "i42e",
+		},
+		{
+			name:  "typed slice",
+			value: []string{"a", "bc"},
+			want:  "l1:a2:bce",
+		},
+		{
+			name:  "typed map sorts keys",
+			value: map[string]int{"zebra": 1, "apple": 2},
+			want:  "d5:applei2e5:zebrai1ee",
+		},
+		{
+			name: "struct with tags",
+			value: testInfo{
+				Name:        "sample.txt",
+				PieceLength: 32768,
+				Pieces:      []byte("abc"),
+				Length:      92063,
+				Ignored:     "ignored",
+				unexported:  "ignored",
+			},
+			want: "d6:lengthi92063e4:name10:sample.txt12:piece lengthi32768e6:pieces3:abce",
+		},
+		{
+			name: "nested structs and pointers",
+			value: &testMetainfo{
+				Announce: "http://tracker",
+				Info: &testInfo{
+					Name:    "dir",
+					Files:   []testFile{{Length: 1, Path: []string{"a", "b"}}},
+					Private: &private,
+				},
+			},
+			want: "d8:announce14:http://tracker4:infod5:filesld6:lengthi1e4:pathl1:a1:beee4:name3:dir12:piece lengthi0e6:pieces0:7:privatei1eee",
+		},
+		{
+			name:  "nil pointer field is omitted",
+			value: testMetainfo{Announce: "x"},
+			want:  "d8:announce1:xe",
+		},
+		{
+			name:    "unsupported type names the field",
+			value:   map[string]any{"info": map[string]any{"ratio": 1.5}},
+			wantErr: `field "info.ratio"`,
+		},
+		{
+			name:    "non-string map keys",
+			value:   map[int]int{1: 1},
+			wantErr: "unsupported type map[int]int",
+		},
+		{
+			name:    "nil",
+			value:   nil,
+			wantErr: "top-level value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Marshal() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	private := 1
+	want := testMetainfo{
+		Announce: "http://tracker",
+		Comment:  "hello",
+		Info: &testInfo{
+			Name:        "dir",
+			PieceLength: 16384,
+			Pieces:      []byte{0x00, 0xff},
+			Files: []testFile{
+				{Length: 1, Path: []string{"a", "b"}},
+				{Length: 2, Path: []string{"c"}},
+			},
+			Private: &private,
+		},
+	}
+
+	data, err := Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var got testMetainfo
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
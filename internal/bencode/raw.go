@@ -0,0 +1,107 @@
+package bencode
+
+import (
+	"fmt"
+)
+
+// RawMessage is a raw encoded bencode value. It can be used to delay decoding
+// part of a message, or to keep the exact bytes of a value, such as the info
+// dictionary of a torrent whose hash must match the original encoding.
+type RawMessage []byte
+
+// MarshalBencode returns m as the bencoding of m.
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, fmt.Errorf("empty RawMessage")
+	}
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data.
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	if m == nil {
+		return fmt.Errorf("UnmarshalBencode on nil pointer")
+	}
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// A Span is the half-open byte range [Start, End) that a value occupies in
+// its input.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Spans decodes data and reports where each value starts and ends, keyed by
+// its path. The top-level value has the empty path, dictionary values are
+// named by joining keys with ".", and list elements by appending "[i]", so
+// the info dictionary of a torrent is "info" and the length of its first file
+// is "info.files[0].length".
+func Spans(data []byte) (map[string]Span, error) {
+	spans := make(map[string]Span)
+	d, err := newDecodeState(data, DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.spans("", spans); err != nil {
+		return nil, err
+	}
+	if d.off != len(data) {
+		return nil, d.syntaxError(d.off, "unexpected data after top-level value")
+	}
+	return spans, nil
+}
+
+func (d *decodeState) spans(path string, spans map[string]Span) error {
+	if d.off >= len(d.data) {
+		return d.syntaxError(d.off, "unexpected end of input")
+	}
+	start := d.off
+
+	switch d.data[d.off] {
+	case 'l':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		d.off++
+		for i := 0; d.off < len(d.data) && d.data[d.off] != 'e'; i++ {
+			if err := d.spans(indexPath(path, i), spans); err != nil {
+				return err
+			}
+		}
+		if d.off >= len(d.data) {
+			return d.syntaxError(d.off, "unterminated list")
+		}
+		d.off++
+	case 'd':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		d.off++
+		var prev []byte
+		for first := true; d.off < len(d.data) && d.data[d.off] != 'e'; first = false {
+			key, err := d.key(prev, first)
+			if err != nil {
+				return err
+			}
+			prev = key
+			if err := d.spans(fieldPath(path, string(key)), spans); err != nil {
+				return err
+			}
+		}
+		if d.off >= len(d.data) {
+			return d.syntaxError(d.off, "unterminated dictionary")
+		}
+		d.off++
+	default:
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+
+	spans[path] = Span{Start: start, End: d.off}
+	return nil
+}
@@ -0,0 +1,80 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRawMessage(t *testing.T) {
+	// The info dictionary is deliberately not in canonical form: its keys are
+	// unsorted and it holds an unknown key, both of which must be preserved.
+	input := "d8:announce1:x4:infod4:name3:dir1:zi1e1:ai2eee"
+
+	var got struct {
+		Announce string     `bencode:"announce"`
+		Info     RawMessage `bencode:"info"`
+	}
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	want := "d4:name3:dir1:zi1e1:ai2ee"
+	if string(got.Info) != want {
+		t.Errorf("Info = %s, want %s", got.Info, want)
+	}
+
+	encoded, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(encoded) != input {
+		t.Errorf("Marshal() = %s, want %s", encoded, input)
+	}
+}
+
+func TestRawMessageEmpty(t *testing.T) {
+	if _, err := Marshal(struct{ Info RawMessage }{}); err == nil {
+		t.Errorf("Marshal() error = nil, want error for empty RawMessage")
+	}
+
+	encoded, err := Marshal(struct {
+		Info RawMessage `bencode:"info,omitempty"`
+	}{})
+	if err != nil || string(encoded) != "de" {
+		t.Errorf("Marshal() = %s, %v, want de", encoded, err)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	input := "d8:announce1:x4:infod5:filesld6:lengthi1eee4:name3:diree"
+
+	got, err := Spans([]byte(input))
+	if err != nil {
+		t.Fatalf("Spans() error = %v", err)
+	}
+
+	want := map[string]Span{
+		"":                     {0, 56},
+		"announce":             {11, 14},
+		"info":                 {20, 55},
+		"info.files":           {28, 43},
+		"info.files[0]":        {29, 42},
+		"info.files[0].length": {38, 41},
+		"info.name":            {49, 54},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Spans() = %v, want %v", got, want)
+	}
+
+	if string(input[got["info"].Start:got["info"].End]) != "d5:filesld6:lengthi1eee4:name3:dire" {
+		t.Errorf("info span = %q", input[got["info"].Start:got["info"].End])
+	}
+}
+
+func TestSpansInvalid(t *testing.T) {
+	for _, input := range []string{"", "d1:a", "l", "i1ei2e"} {
+		if _, err := Spans([]byte(input)); err == nil {
+			t.Errorf("Spans(%q) error = nil, want error", input)
+		}
+	}
+}
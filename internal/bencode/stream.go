@@ -0,0 +1,209 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// A Decoder reads and decodes bencoded values from an input stream.
+type Decoder struct {
+	r         *bufio.Reader
+	buf       bytes.Buffer
+	offset    int // bytes consumed by previous values
+	strict    bool
+	useBigInt bool
+	limits    Limits
+
+	elements int // values read so far in the current top-level value
+}
+
+// NewDecoder returns a new decoder that reads from r.
+//
+// The decoder introduces its own buffering and may read data from r beyond
+// the bencoded values requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), limits: DefaultLimits}
+}
+
+// UseBigInt makes the decoder store integers that do not fit in an int64 as
+// *big.Int when decoding into an empty interface, instead of failing.
+func (dec *Decoder) UseBigInt() {
+	dec.useBigInt = true
+}
+
+// SetLimits replaces the limits applied to each value read by the decoder,
+// which start out as DefaultLimits.
+func (dec *Decoder) SetLimits(limits Limits) {
+	dec.limits = limits
+}
+
+// Strict makes the decoder reject values that are not in canonical form, as
+// described for DecodeStrict.
+func (dec *Decoder) Strict() {
+	dec.strict = true
+}
+
+// Decode reads the next bencoded value from its input and stores it in the
+// value pointed to by v, following the rules of Unmarshal. It returns io.EOF
+// when the input ends cleanly between values, and io.ErrUnexpectedEOF when it
+// ends in the middle of one. The offset of a *SyntaxError is relative to the
+// start of the stream.
+func (dec *Decoder) Decode(v any) error {
+	dec.buf.Reset()
+	dec.elements = 0
+	if err := dec.readValue(0); err != nil {
+		if err == io.EOF && dec.buf.Len() > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	d := &decodeState{data: dec.buf.Bytes(), strict: dec.strict, useBigInt: dec.useBigInt, limits: dec.limits}
+	err := d.unmarshal(v)
+	if serr, ok := err.(*SyntaxError); ok {
+		serr.Offset += dec.offset
+	}
+	dec.offset += dec.buf.Len()
+	return err
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer.
+func (dec *Decoder) Buffered() io.Reader {
+	n := dec.r.Buffered()
+	b, _ := dec.r.Peek(n)
+	return bytes.NewReader(b)
+}
+
+// readValue copies exactly one complete value from the input into dec.buf.
+// String bodies are copied as they arrive rather than allocated up front, so
+// a bogus length prefix cannot force a large allocation, and the decoder's
+// limits are enforced while reading so oversized input is rejected before it
+// is buffered.
+func (dec *Decoder) readValue(depth int) error {
+	c, err := dec.readByte()
+	if err != nil {
+		return err
+	}
+
+	dec.elements++
+	if dec.limits.MaxElements > 0 && dec.elements > dec.limits.MaxElements {
+		return dec.limitError(ErrMaxElements)
+	}
+
+	switch {
+	case c >= '0' && c <= '9':
+		return dec.readString()
+	case c == 'i':
+		return dec.readUntil('e')
+	case c == 'l' || c == 'd':
+		depth++
+		if dec.limits.MaxDepth > 0 && depth > dec.limits.MaxDepth {
+			return dec.limitError(ErrMaxDepth)
+		}
+		for {
+			next, err := dec.r.Peek(1)
+			if err != nil {
+				return err
+			}
+			if next[0] == 'e' {
+				_, err := dec.readByte()
+				return err
+			}
+			if err := dec.readValue(depth); err != nil {
+				return err
+			}
+		}
+	default:
+		return dec.syntaxError("invalid character %q at start of value", c)
+	}
+}
+
+func (dec *Decoder) readString() error {
+	start := dec.buf.Len() - 1
+	if err := dec.readUntil(':'); err != nil {
+		return err
+	}
+	lengthStr := dec.buf.Bytes()[start : dec.buf.Len()-1]
+	length, err := strconv.ParseInt(string(lengthStr), 10, 64)
+	if err != nil || length < 0 {
+		return dec.syntaxError("invalid string length %q", lengthStr)
+	}
+	if dec.limits.MaxStringLength > 0 && length > int64(dec.limits.MaxStringLength) {
+		return dec.limitError(ErrMaxStringLength)
+	}
+	if dec.limits.MaxInputSize > 0 && length > int64(dec.limits.MaxInputSize-dec.buf.Len()) {
+		return dec.limitError(ErrMaxInputSize)
+	}
+
+	n, err := io.CopyN(&dec.buf, dec.r, length)
+	if err != nil && n < length {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// readUntil copies bytes up to and including delim into dec.buf.
+func (dec *Decoder) readUntil(delim byte) error {
+	for {
+		c, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		if c == delim {
+			return nil
+		}
+		if c != '-' && (c < '0' || c > '9') {
+			return dec.syntaxError("unexpected character %q while looking for %q", c, delim)
+		}
+	}
+}
+
+// readByte copies the next byte of input into dec.buf.
+func (dec *Decoder) readByte() (byte, error) {
+	if dec.limits.MaxInputSize > 0 && dec.buf.Len() >= dec.limits.MaxInputSize {
+		return 0, dec.limitError(ErrMaxInputSize)
+	}
+	c, err := dec.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	dec.buf.WriteByte(c)
+	return c, nil
+}
+
+func (dec *Decoder) limitError(err error) error {
+	return limitError(err, dec.offset+dec.buf.Len())
+}
+
+// syntaxError reports an error at the last byte read from the stream.
+func (dec *Decoder) syntaxError(format string, args ...any) error {
+	return &SyntaxError{Offset: dec.offset + dec.buf.Len() - 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// An Encoder writes bencoded values to an output stream.
+type Encoder struct {
+	out io.Writer
+	w   *bufio.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{out: w, w: bufio.NewWriter(w)}
+}
+
+// Encode writes the bencoding of v to the stream, following the rules of
+// Marshal. The value is written as it is encoded instead of being built up in
+// memory first, so if v cannot be encoded a prefix of it may already have
+// been written.
+func (enc *Encoder) Encode(v any) error {
+	e := &encodeState{writer: enc.w}
+	if err := e.marshal(reflect.ValueOf(v), ""); err != nil {
+		enc.w.Reset(enc.out)
+		return err
+	}
+	return enc.w.Flush()
+}
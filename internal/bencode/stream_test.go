@@ -0,0 +1,168 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoderConsecutiveValues(t *testing.T) {
+	input := "5:helloi42eli1ei2eed3:foo3:bare"
+	// OneByteReader makes sure values split across reads are reassembled.
+	dec := NewDecoder(iotest.OneByteReader(strings.NewReader(input)))
+
+	want := []any{
+		[]byte("hello"),
+		int64(42),
+		[]any{int64(1), int64(2)},
+		map[string]any{"foo": []byte("bar")},
+	}
+
+	for i, w := range want {
+		var got any
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode() value %d error = %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("Decode() value %d = %v, want %v", i, got, w)
+		}
+	}
+
+	var extra any
+	if err := dec.Decode(&extra); err != io.EOF {
+		t.Errorf("Decode() at end of input error = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderIntoStruct(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d8:announce1:x4:infod4:name3:diree"))
+
+	var got testMetainfo
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got.Announce != "x" || got.Info == nil || got.Info.Name != "dir" {
+		t.Errorf("Decode() = %+v, want announce x and name dir", got)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{name: "truncated string", input: "10:hello", wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated list", input: "li1e", wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated integer", input: "i12", wantErr: io.ErrUnexpectedEOF},
+		{name: "large declared length", input: "99999:x", wantErr: io.ErrUnexpectedEOF},
+		{name: "invalid type", input: "x", wantErr: nil},
+		{name: "invalid length", input: "1a:x", wantErr: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			err := NewDecoder(strings.NewReader(tt.input)).Decode(&v)
+			if err == nil {
+				t.Fatalf("Decode() error = nil, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+
+	if err := enc.Encode(map[string]any{"foo": []byte("bar")}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if err := enc.Encode(testFile{Length: 3, Path: []string{"a"}}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if err := enc.Encode(1.5); err == nil {
+		t.Errorf("Encode() error = nil, want error for unsupported type")
+	}
+	if err := enc.Encode(7); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	want := "d3:foo3:bare" + "d6:lengthi3e4:pathl1:aee" + "i7e"
+	if buf.String() != want {
+		t.Errorf("Encode() wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestEncoderDecoderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	want := []testFile{{Length: 1, Path: []string{"a"}}, {Length: 2, Path: []string{"b", "c"}}}
+
+	enc := NewEncoder(&buf)
+	for _, f := range want {
+		if err := enc.Encode(f); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	for i, w := range want {
+		var got testFile
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode() value %d error = %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("Decode() value %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestDecoderStrict(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1ed1:bi1e1:ai2ee"))
+	dec.Strict()
+
+	var first int
+	if err := dec.Decode(&first); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	var second map[string]int
+	err := dec.Decode(&second)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("Decode() error = %v, want *SyntaxError", err)
+	}
+	// The offset counts the 3 bytes of the first value.
+	if syntaxErr.Offset != 10 {
+		t.Errorf("SyntaxError.Offset = %d, want 10", syntaxErr.Offset)
+	}
+}
+
+func TestDecoderUseBigInt(t *testing.T) {
+	input := "li1ei99999999999999999999ee"
+
+	var v any
+	if err := NewDecoder(strings.NewReader(input)).Decode(&v); err == nil {
+		t.Errorf("Decode() error = nil, want overflow error")
+	}
+
+	dec := NewDecoder(strings.NewReader(input))
+	dec.UseBigInt()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want, _ := new(big.Int).SetString("99999999999999999999", 10)
+	list := v.([]any)
+	if list[0] != int64(1) || list[1].(*big.Int).Cmp(want) != 0 {
+		t.Errorf("Decode() = %v, want [1 %v]", v, want)
+	}
+}
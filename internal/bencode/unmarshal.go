@@ -0,0 +1,307 @@
+package bencode
+
+import (
+	"encoding"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An UnmarshalTypeError describes a bencode value that was not appropriate
+// for the Go value it was being decoded into.
+type UnmarshalTypeError struct {
+	Value string       // bencode value: "string", "integer", "list" or "dictionary"
+	Type  reflect.Type // type of the Go value it could not be assigned to
+	Field string       // path of the value, such as "info.files[0].length"
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("cannot unmarshal bencode %s into %s of type %s", e.Value, displayPath(e.Field), e.Type)
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal a
+// bencoded description of themselves. The input is a single complete value,
+// which must be copied if it is retained after returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+var (
+	unmarshalerType     = reflect.TypeFor[Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// Unmarshal parses the bencoded data and stores the result in the value
+// pointed to by v, which must be a non-nil pointer.
+//
+// Unmarshal is the inverse of Marshal: byte strings decode into strings, byte
+// slices and byte arrays of the same length; integers into any integer type
+// that can hold them, a big.Int, or a bool if they are 0 or 1; lists into
+// slices and arrays; and dictionaries into maps with string keys and structs.
+// Dictionary keys are matched against struct fields using the same rules as
+// Marshal; keys without a matching field are ignored. Values decoded into an
+// empty interface take the types returned by Decode.
+//
+// Values implementing Unmarshaler are handed their encoded bytes, and byte
+// strings are decoded into values implementing encoding.TextUnmarshaler by
+// calling UnmarshalText. Decoding is bounded by DefaultLimits.
+func Unmarshal(data []byte, v any) error {
+	d, err := newDecodeState(data, DefaultLimits)
+	if err != nil {
+		return err
+	}
+	return d.unmarshal(v)
+}
+
+func (d *decodeState) unmarshal(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cannot unmarshal into non-pointer or nil value of type %T", v)
+	}
+
+	if err := d.reflectValue(rv.Elem(), ""); err != nil {
+		return err
+	}
+	if d.off != len(d.data) {
+		return d.syntaxError(d.off, "unexpected data after top-level value")
+	}
+	return nil
+}
+
+func (d *decodeState) reflectValue(v reflect.Value, path string) error {
+	if d.off >= len(d.data) {
+		return d.syntaxError(d.off, "unexpected end of input")
+	}
+
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		start := d.off
+		if err := d.skip(); err != nil {
+			return err
+		}
+		return v.Addr().Interface().(Unmarshaler).UnmarshalBencode(d.data[start:d.off])
+	}
+
+	if v.Kind() == reflect.Interface {
+		if v.NumMethod() != 0 {
+			return &UnmarshalTypeError{Value: d.kind(), Type: v.Type(), Field: path}
+		}
+		decoded, err := d.value()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(decoded))
+		return nil
+	}
+
+	if v.Type() == bigIntType && d.data[d.off] != 'i' {
+		return &UnmarshalTypeError{Value: d.kind(), Type: v.Type(), Field: path}
+	}
+
+	switch c := d.data[d.off]; {
+	case c >= '0' && c <= '9':
+		return d.stringValue(v, path)
+	case c == 'i':
+		return d.integerValue(v, path)
+	case c == 'l':
+		return d.listValue(v, path)
+	case c == 'd':
+		return d.dictValue(v, path)
+	default:
+		return d.syntaxError(d.off, "invalid character %q at start of value", c)
+	}
+}
+
+// kind describes the bencode value at the current offset for error messages.
+func (d *decodeState) kind() string {
+	switch c := d.data[d.off]; {
+	case c == 'i':
+		return "integer"
+	case c == 'l':
+		return "list"
+	case c == 'd':
+		return "dictionary"
+	default:
+		return "string"
+	}
+}
+
+func (d *decodeState) stringValue(v reflect.Value, path string) error {
+	s, err := d.str()
+	if err != nil {
+		return err
+	}
+
+	if u, ok := asInterface(v, textUnmarshalerType).(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText(s); err != nil {
+			return fmt.Errorf("error calling UnmarshalText for type %s at %s: %w", v.Type(), displayPath(path), err)
+		}
+		return nil
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(s))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(append([]byte(nil), s...))
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if len(s) != v.Len() {
+			return &UnmarshalTypeError{Value: fmt.Sprintf("string of length %d", len(s)), Type: v.Type(), Field: path}
+		}
+		reflect.Copy(v, reflect.ValueOf(s))
+	default:
+		return &UnmarshalTypeError{Value: "string", Type: v.Type(), Field: path}
+	}
+	return nil
+}
+
+func (d *decodeState) integerValue(v reflect.Value, path string) error {
+	numStr, err := d.integer()
+	if err != nil {
+		return err
+	}
+	typeError := &UnmarshalTypeError{Value: "integer " + string(numStr), Type: v.Type(), Field: path}
+
+	if v.Type() == bigIntType {
+		v.Addr().Interface().(*big.Int).SetString(string(numStr), 10)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		switch string(numStr) {
+		case "0":
+			v.SetBool(false)
+		case "1":
+			v.SetBool(true)
+		default:
+			return typeError
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(string(numStr), 10, 64)
+		if err != nil || v.OverflowInt(i) {
+			return typeError
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, err := strconv.ParseUint(strings.TrimPrefix(string(numStr), "+"), 10, 64)
+		if err != nil || v.OverflowUint(i) {
+			return typeError
+		}
+		v.SetUint(i)
+	default:
+		typeError.Value = "integer"
+		return typeError
+	}
+	return nil
+}
+
+func (d *decodeState) listValue(v reflect.Value, path string) error {
+	isList := (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8
+	if !isList {
+		return &UnmarshalTypeError{Value: "list", Type: v.Type(), Field: path}
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'l'
+
+	list := v
+	if v.Kind() == reflect.Slice {
+		list = reflect.MakeSlice(v.Type(), 0, 0)
+	}
+
+	i := 0
+	for ; d.off < len(d.data) && d.data[d.off] != 'e'; i++ {
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := d.reflectValue(elem, indexPath(path, i)); err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Slice {
+			list = reflect.Append(list, elem)
+		} else if i < v.Len() {
+			list.Index(i).Set(elem)
+		} else {
+			return &UnmarshalTypeError{Value: "list longer than array", Type: v.Type(), Field: path}
+		}
+	}
+
+	if d.off >= len(d.data) {
+		return d.syntaxError(d.off, "unterminated list")
+	}
+	d.off++ // 'e'
+
+	if v.Kind() == reflect.Slice {
+		v.Set(list)
+	}
+	return nil
+}
+
+func (d *decodeState) dictValue(v reflect.Value, path string) error {
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case v.Kind() == reflect.Struct:
+	default:
+		return &UnmarshalTypeError{Value: "dictionary", Type: v.Type(), Field: path}
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'd'
+
+	var fields []field
+	if v.Kind() == reflect.Struct {
+		fields = cachedFields(v.Type())
+	}
+
+	var prev []byte
+	for first := true; d.off < len(d.data) && d.data[d.off] != 'e'; first = false {
+		key, err := d.key(prev, first)
+		if err != nil {
+			return err
+		}
+		prev = key
+		keyPath := fieldPath(path, string(key))
+
+		if v.Kind() == reflect.Map {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.reflectValue(elem, keyPath); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(string(key)).Convert(v.Type().Key()), elem)
+			continue
+		}
+
+		i := sort.Search(len(fields), func(i int) bool { return fields[i].name >= string(key) })
+		if i == len(fields) || fields[i].name != string(key) {
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.reflectValue(v.Field(fields[i].index), keyPath); err != nil {
+			return err
+		}
+	}
+
+	if d.off >= len(d.data) {
+		return d.syntaxError(d.off, "unterminated dictionary")
+	}
+	d.off++ // 'e'
+	return nil
+}
@@ -0,0 +1,225 @@
+package bencode
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	private := 1
+	tests := []struct {
+		name  string
+		input string
+		into  any
+		want  any
+	}{
+		{
+			name:  "string",
+			input: "5:hello",
+			into:  new(string),
+			want:  "hello",
+		},
+		{
+			name:  "byte slice",
+			input: "5:hello",
+			into:  new([]byte),
+			want:  []byte("hello"),
+		},
+		{
+			name:  "byte array",
+			input: "3:abc",
+			into:  new([3]byte),
+			want:  [3]byte{'a', 'b', 'c'},
+		},
+		{
+			name:  "integer",
+			input: "i-42e",
+			into:  new(int32),
+			want:  int32(-42),
+		},
+		{
+			name:  "typed list",
+			input: "l1:a2:bce",
+			into:  new([]string),
+			want:  []string{"a", "bc"},
+		},
+		{
+			name:  "empty list",
+			input: "le",
+			into:  new([]int),
+			want:  []int{},
+		},
+		{
+			name:  "typed map",
+			input: "d1:ai1e1:bi2ee",
+			into:  new(map[string]int),
+			want:  map[string]int{"a": 1, "b": 2},
+		},
+		{
+			name:  "empty interface",
+			input: "l5:helloi1ee",
+			into:  new(any),
+			want:  []any{[]byte("hello"), int64(1)},
+		},
+		{
+			name:  "struct with tags, unknown keys and pointers",
+			input: "d8:announce1:x4:infod5:filesld6:lengthi1e4:pathl1:aeee4:name3:dir7:privatei1e7:unknownli1eee5:extra3:fooe",
+			into:  new(testMetainfo),
+			want: testMetainfo{
+				Announce: "x",
+				Info: &testInfo{
+					Name:    "dir",
+					Files:   []testFile{{Length: 1, Path: []string{"a"}}},
+					Private: &private,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unmarshal([]byte(tt.input), tt.into); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			got := reflect.ValueOf(tt.into).Elem().Interface()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		into      any
+		wantField string
+	}{
+		{
+			name:      "integer into string field",
+			input:     "d4:infod4:namei1eee",
+			into:      &testMetainfo{},
+			wantField: "info.name",
+		},
+		{
+			name:      "string into list element",
+			input:     "d4:infod5:filesld6:length1:xeeee",
+			into:      &testMetainfo{},
+			wantField: "info.files[0].length",
+		},
+		{
+			name:      "integer overflow",
+			input:     "i300e",
+			into:      new(uint8),
+			wantField: "",
+		},
+		{
+			name:      "wrong length byte array",
+			input:     "2:ab",
+			into:      new([3]byte),
+			wantField: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tt.input), tt.into)
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+			}
+			if typeErr.Field != tt.wantField {
+				t.Errorf("UnmarshalTypeError.Field = %q, want %q", typeErr.Field, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestUnmarshalInvalidInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		into  any
+	}{
+		{name: "non-pointer", input: "i1e", into: 0},
+		{name: "nil pointer", input: "i1e", into: (*int)(nil)},
+		{name: "trailing data", input: "i1ei2e", into: new(int)},
+		{name: "empty input", input: "", into: new(int)},
+		{name: "unterminated list", input: "li1e", into: new([]int)},
+		{name: "unterminated dictionary", input: "d1:ai1e", into: new(map[string]int)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unmarshal([]byte(tt.input), tt.into); err == nil {
+				t.Errorf("Unmarshal() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestUnmarshalLargeIntegers(t *testing.T) {
+	var got struct {
+		Total   uint64   `bencode:"total"`
+		Size    int64    `bencode:"size"`
+		Big     *big.Int `bencode:"big"`
+		Counter big.Int  `bencode:"counter"`
+	}
+	input := "d3:bigi-123456789012345678901234567890e7:counteri5e4:sizei-9223372036854775808e5:totali18446744073709551615ee"
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if got.Total != math.MaxUint64 || got.Size != math.MinInt64 {
+		t.Errorf("Unmarshal() = %d, %d, want %d, %d", got.Total, got.Size, uint64(math.MaxUint64), int64(math.MinInt64))
+	}
+	if got.Big.String() != "-123456789012345678901234567890" || got.Counter.Int64() != 5 {
+		t.Errorf("Unmarshal() = %v, %v", got.Big, &got.Counter)
+	}
+
+	encoded, err := Marshal(&got)
+	if err != nil || string(encoded) != input {
+		t.Errorf("Marshal() = %s, %v, want %s", encoded, err, input)
+	}
+
+	var negative uint64
+	var typeErr *UnmarshalTypeError
+	if err := Unmarshal([]byte("i-1e"), &negative); !errors.As(err, &typeErr) {
+		t.Errorf("Unmarshal() of negative into uint64 error = %v, want *UnmarshalTypeError", err)
+	}
+
+	var b big.Int
+	if err := Unmarshal([]byte("le"), &b); !errors.As(err, &typeErr) {
+		t.Errorf("Unmarshal() of list into big.Int error = %v, want *UnmarshalTypeError", err)
+	}
+}
+
+func TestUnmarshalBoolsAndText(t *testing.T) {
+	var got struct {
+		Private bool       `bencode:"private"`
+		Seed    bool       `bencode:"seed"`
+		IP      netip.Addr `bencode:"ip"`
+	}
+	if err := Unmarshal([]byte("d2:ip9:127.0.0.17:privatei1e4:seedi0ee"), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !got.Private || got.Seed || got.IP != netip.MustParseAddr("127.0.0.1") {
+		t.Errorf("Unmarshal() = %+v", got)
+	}
+
+	var b bool
+	var typeErr *UnmarshalTypeError
+	if err := Unmarshal([]byte("i2e"), &b); !errors.As(err, &typeErr) {
+		t.Errorf("Unmarshal() of i2e into bool error = %v, want *UnmarshalTypeError", err)
+	}
+
+	var addr netip.Addr
+	if err := Unmarshal([]byte("3:bad"), &addr); err == nil {
+		t.Errorf("Unmarshal() of invalid address error = nil, want error")
+	}
+}
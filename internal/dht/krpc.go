@@ -0,0 +1,89 @@
+package dht
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// KRPC error codes.
+const (
+	ErrorGeneric       = 201
+	ErrorServer        = 202
+	ErrorProtocol      = 203
+	ErrorMethodUnknown = 204
+)
+
+// An Error is a KRPC error, sent in reply to a query that failed.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+// MarshalBencode encodes e as a list of its code and message.
+func (e *Error) MarshalBencode() ([]byte, error) {
+	return bencode.Marshal([]any{e.Code, e.Message})
+}
+
+// UnmarshalBencode decodes a list of a code and a message.
+func (e *Error) UnmarshalBencode(data []byte) error {
+	var list []bencode.RawMessage
+	if err := bencode.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	if len(list) != 2 {
+		return fmt.Errorf("expected error to be a list of 2 values, but got %d", len(list))
+	}
+	if err := bencode.Unmarshal(list[0], &e.Code); err != nil {
+		return err
+	}
+	return bencode.Unmarshal(list[1], &e.Message)
+}
+
+// message is a KRPC message: a query, a response or an error, told apart by
+// Y. The transaction ID T matches responses to queries.
+type message struct {
+	T string     `bencode:"t"`
+	Y string     `bencode:"y"`
+	Q string     `bencode:"q,omitempty"`
+	A *arguments `bencode:"a,omitempty"`
+	R *response  `bencode:"r,omitempty"`
+	E *Error     `bencode:"e,omitempty"`
+}
+
+// arguments holds the arguments of every query type.
+type arguments struct {
+	ID          ID       `bencode:"id"`
+	Target      *ID      `bencode:"target,omitempty"`
+	InfoHash    *ID      `bencode:"info_hash,omitempty"`
+	Port        int      `bencode:"port,omitempty"`
+	ImpliedPort bool     `bencode:"implied_port,omitempty"`
+	Token       []byte   `bencode:"token,omitempty"`
+	Want        []string `bencode:"want,omitempty"`
+}
+
+// response holds the values of every response type.
+type response struct {
+	ID     ID       `bencode:"id"`
+	Nodes  []byte   `bencode:"nodes,omitempty"`
+	Nodes6 []byte   `bencode:"nodes6,omitempty"`
+	Token  []byte   `bencode:"token,omitempty"`
+	Values [][]byte `bencode:"values,omitempty"`
+}
+
+// nodes returns the nodes of both address families in the response.
+func (r *response) nodes() ([]Node, error) {
+	nodes, err := parseCompactNodes(r.Nodes, 4)
+	if err != nil {
+		return nil, err
+	}
+	nodes6, err := parseCompactNodes(r.Nodes6, 16)
+	if err != nil {
+		return nil, err
+	}
+	return append(nodes, nodes6...), nil
+}
@@ -0,0 +1,203 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// alpha is the number of queries a lookup keeps in flight.
+const alpha = 3
+
+// lookupNode is a node that a lookup found.
+type lookupNode struct {
+	Node
+	queried bool
+	token   []byte // Set if the node answered a get_peers query.
+}
+
+type lookupResult struct {
+	node  Node
+	nodes []Node
+	peers []netip.AddrPort
+	token []byte
+	err   error
+}
+
+// lookup iteratively queries the nodes closest to target, starting from the
+// routing table, until the closest BucketSize nodes it knows have answered.
+// With getPeers it sends get_peers queries and collects peers along the
+// way, otherwise find_node queries. It returns the peers and the closest
+// nodes that answered.
+func (s *Server) lookup(ctx context.Context, target ID, getPeers bool) ([]netip.AddrPort, []*lookupNode, error) {
+	seeds := s.table.closest(target, BucketSize)
+	if len(seeds) == 0 {
+		return nil, nil, errors.New("no nodes in the routing table")
+	}
+
+	var candidates []*lookupNode
+	seen := make(map[ID]bool)
+	addCandidate := func(node Node) {
+		if node.ID == s.id || seen[node.ID] || !node.Addr.IsValid() || node.Addr.Port() == 0 {
+			return
+		}
+		seen[node.ID] = true
+		candidates = append(candidates, &lookupNode{Node: node})
+	}
+	for _, node := range seeds {
+		addCandidate(node)
+	}
+
+	var peers []netip.AddrPort
+	seenPeers := make(map[netip.AddrPort]bool)
+	var answered []*lookupNode
+	results := make(chan lookupResult)
+	inFlight := 0
+
+	for {
+		slices.SortFunc(candidates, func(a, b *lookupNode) int { return compareDistance(target, a.ID, b.ID) })
+
+		// Query the closest nodes not yet queried, among the closest
+		// BucketSize that have not failed.
+		if ctx.Err() == nil {
+			for i := 0; i < len(candidates) && i < BucketSize && inFlight < alpha; i++ {
+				c := candidates[i]
+				if c.queried {
+					continue
+				}
+				c.queried = true
+				inFlight++
+				go func(node Node) {
+					results <- s.lookupQuery(ctx, node, target, getPeers)
+				}(c.Node)
+			}
+		}
+		if inFlight == 0 {
+			break
+		}
+
+		result := <-results
+		inFlight--
+		i := slices.IndexFunc(candidates, func(c *lookupNode) bool { return c.ID == result.node.ID })
+		if result.err != nil {
+			// Forget failed nodes so that the next closest take their place.
+			candidates = slices.Delete(candidates, i, i+1)
+			continue
+		}
+		candidates[i].token = result.token
+		answered = append(answered, candidates[i])
+		for _, node := range result.nodes {
+			addCandidate(node)
+		}
+		for _, peer := range result.peers {
+			if !seenPeers[peer] {
+				seenPeers[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil && len(answered) == 0 {
+		return nil, nil, err
+	}
+	if len(answered) == 0 {
+		return nil, nil, errors.New("no nodes answered")
+	}
+	slices.SortFunc(answered, func(a, b *lookupNode) int { return compareDistance(target, a.ID, b.ID) })
+	return peers, answered[:min(BucketSize, len(answered))], nil
+}
+
+func (s *Server) lookupQuery(ctx context.Context, node Node, target ID, getPeers bool) lookupResult {
+	result := lookupResult{node: node}
+	if getPeers {
+		resp, err := s.GetPeers(ctx, node.Addr, target)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		result.nodes, result.peers, result.token = resp.Nodes, resp.Peers, resp.Token
+		return result
+	}
+	result.nodes, result.err = s.FindNode(ctx, node.Addr, target)
+	return result
+}
+
+// Bootstrap joins the DHT: it asks the bootstrap nodes and the nodes loaded
+// from the state file for the nodes closest to our ID, then looks up our ID
+// to fill the routing table.
+func (s *Server) Bootstrap(ctx context.Context) error {
+	var addrs []netip.AddrPort
+	for _, node := range s.table.nodes() {
+		addrs = append(addrs, node.Addr)
+	}
+	for _, hostport := range s.config.BootstrapNodes {
+		addr, err := net.ResolveUDPAddr("udp", hostport)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr.AddrPort())
+	}
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr netip.AddrPort) {
+			defer wg.Done()
+			nodes, err := s.FindNode(ctx, addr, s.id)
+			if err != nil {
+				return
+			}
+			for _, node := range nodes {
+				s.table.add(node)
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	if len(s.table.nodes()) == 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return errors.New("failed to bootstrap: no nodes answered")
+	}
+	_, _, err := s.lookup(ctx, s.id, false)
+	return err
+}
+
+// FindPeers looks up the peers of the torrent with infoHash.
+func (s *Server) FindPeers(ctx context.Context, infoHash ID) ([]netip.AddrPort, error) {
+	peers, _, err := s.lookup(ctx, infoHash, true)
+	return peers, err
+}
+
+// Announce looks up the peers of the torrent with infoHash, and announces
+// that we are a peer on port to the closest nodes. A port of 0 announces
+// the server's own port. It returns the peers found.
+func (s *Server) Announce(ctx context.Context, infoHash ID, port int) ([]netip.AddrPort, error) {
+	peers, closest, err := s.lookup(ctx, infoHash, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(closest))
+	for i, node := range closest {
+		wg.Add(1)
+		go func(i int, node *lookupNode) {
+			defer wg.Done()
+			errs[i] = s.AnnouncePeer(ctx, node.Addr, infoHash, port, node.token)
+		}(i, node)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			return peers, nil
+		}
+	}
+	return peers, fmt.Errorf("failed to announce: %w", errors.Join(errs...))
+}
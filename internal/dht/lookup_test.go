@@ -0,0 +1,91 @@
+package dht
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// testNetwork starts n servers on loopback that bootstrap from the first.
+func testNetwork(t *testing.T, n int) []*Server {
+	t.Helper()
+	router := newTestServer(t, Config{})
+	servers := []*Server{router}
+	for range n - 1 {
+		servers = append(servers, newTestServer(t, Config{
+			BootstrapNodes: []string{router.Addr().String()},
+		}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, s := range servers[1:] {
+		if err := s.Bootstrap(ctx); err != nil {
+			t.Fatalf("Bootstrap failed: %v", err)
+		}
+	}
+	return servers
+}
+
+func TestBootstrap(t *testing.T) {
+	servers := testNetwork(t, 20)
+	for i, s := range servers[1:] {
+		// Every node knows the router, and the lookup of its own ID finds
+		// the nodes that joined before it.
+		if got := len(s.Nodes()); got < min(i+1, BucketSize) {
+			t.Errorf("server %d knows %d nodes, want at least %d", i+1, got, min(i+1, BucketSize))
+		}
+	}
+
+	lonely := newTestServer(t, Config{BootstrapNodes: []string{"127.0.0.1:1"}, QueryTimeout: 50 * time.Millisecond})
+	if err := lonely.Bootstrap(context.Background()); err == nil {
+		t.Errorf("expected Bootstrap to fail without reachable nodes")
+	}
+}
+
+func TestAnnounceAndFindPeers(t *testing.T) {
+	servers := testNetwork(t, 20)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	infoHash := RandomID()
+
+	peers, err := servers[5].Announce(ctx, infoHash, 6881)
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("Announce() found peers %v of a new torrent", peers)
+	}
+	if _, err := servers[9].Announce(ctx, infoHash, 0); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+
+	peers, err = servers[15].FindPeers(ctx, infoHash)
+	if err != nil {
+		t.Fatalf("FindPeers failed: %v", err)
+	}
+	want := map[netip.AddrPort]bool{
+		netip.AddrPortFrom(servers[5].Addr().Addr(), 6881): true,
+		servers[9].Addr(): true,
+	}
+	if len(peers) != len(want) {
+		t.Fatalf("FindPeers() = %v, want %v", peers, want)
+	}
+	for _, peer := range peers {
+		if !want[peer] {
+			t.Errorf("FindPeers() returned unexpected peer %v", peer)
+		}
+	}
+
+	if peers, err := servers[15].FindPeers(ctx, RandomID()); err != nil || len(peers) != 0 {
+		t.Errorf("FindPeers() for an unknown torrent = %v, %v, want no peers", peers, err)
+	}
+}
+
+func TestLookupEmptyTable(t *testing.T) {
+	s := newTestServer(t, Config{})
+	if _, err := s.FindPeers(context.Background(), ID{1}); err == nil {
+		t.Errorf("expected FindPeers to fail with an empty routing table")
+	}
+}
@@ -0,0 +1,103 @@
+// Package dht implements a node of the mainline DHT (BEP 5), the Kademlia
+// network that BitTorrent clients use to find peers without a tracker.
+package dht
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/netip"
+)
+
+// IDLength is the length of node IDs and info hashes in bytes.
+const IDLength = 20
+
+// An ID identifies a node, or the torrent whose peers are stored at the
+// nodes with the closest IDs.
+type ID [IDLength]byte
+
+// RandomID returns a random node ID.
+func RandomID() ID {
+	var id ID
+	rand.Read(id[:])
+	return id
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// commonPrefix returns the number of leading bits that a and b share.
+func commonPrefix(a, b ID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return IDLength * 8
+}
+
+// closer reports whether a is closer to target than b by the XOR metric.
+func closer(target, a, b ID) bool {
+	for i := range target {
+		if da, db := a[i]^target[i], b[i]^target[i]; da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+// compareDistance orders a and b by their distance to target, for sorting.
+func compareDistance(target, a, b ID) int {
+	switch {
+	case closer(target, a, b):
+		return -1
+	case closer(target, b, a):
+		return 1
+	}
+	return 0
+}
+
+// A Node is a DHT node and the UDP address it is reachable at.
+type Node struct {
+	ID   ID
+	Addr netip.AddrPort
+}
+
+// parseCompactNodes parses nodes in compact form: each is a node ID followed
+// by an IPv4 or IPv6 address of ipLength bytes and a big-endian port.
+func parseCompactNodes(data []byte, ipLength int) ([]Node, error) {
+	size := IDLength + ipLength + 2
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("expected compact nodes to be a multiple of %d bytes, but got %d", size, len(data))
+	}
+
+	nodes := make([]Node, 0, len(data)/size)
+	for i := 0; i < len(data); i += size {
+		var node Node
+		copy(node.ID[:], data[i:i+IDLength])
+		node.Addr = parseCompactPeer(data[i+IDLength : i+size])
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// appendCompactNode appends a node in compact form.
+func appendCompactNode(b []byte, node Node) []byte {
+	return appendCompactPeer(append(b, node.ID[:]...), node.Addr)
+}
+
+// parseCompactPeer parses a 6 or 18 byte compact address.
+func parseCompactPeer(data []byte) netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(data[:len(data)-2])
+	return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(data[len(data)-2:]))
+}
+
+// appendCompactPeer appends an address in compact form: 6 bytes for IPv4, or
+// 18 for IPv6.
+func appendCompactPeer(b []byte, addr netip.AddrPort) []byte {
+	b = append(b, addr.Addr().Unmap().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
@@ -0,0 +1,62 @@
+package dht
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		a, b ID
+		want int
+	}{
+		{a: ID{}, b: ID{}, want: 160},
+		{a: ID{0x80}, b: ID{}, want: 0},
+		{a: ID{0x01}, b: ID{}, want: 7},
+		{a: ID{0xff, 0x10}, b: ID{0xff, 0x1f}, want: 12},
+	}
+	for _, tt := range tests {
+		if got := commonPrefix(tt.a, tt.b); got != tt.want {
+			t.Errorf("commonPrefix(%x, %x) = %d, want %d", tt.a[:2], tt.b[:2], got, tt.want)
+		}
+	}
+}
+
+func TestCloser(t *testing.T) {
+	target := ID{0x10}
+	if !closer(target, ID{0x11}, ID{0x20}) || closer(target, ID{0x20}, ID{0x11}) {
+		t.Errorf("expected 0x11 to be closer to 0x10 than 0x20")
+	}
+	if closer(target, ID{0x11}, ID{0x11}) {
+		t.Errorf("expected a node not to be closer than itself")
+	}
+}
+
+func TestCompactNodes(t *testing.T) {
+	nodes := []Node{
+		{ID: ID{1}, Addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+		{ID: ID{2}, Addr: netip.MustParseAddrPort("10.0.0.2:1")},
+	}
+	var data []byte
+	for _, node := range nodes {
+		data = appendCompactNode(data, node)
+	}
+	if len(data) != 52 {
+		t.Fatalf("expected 26 bytes per node, got %d bytes", len(data))
+	}
+	got, err := parseCompactNodes(data, 4)
+	if err != nil || !reflect.DeepEqual(got, nodes) {
+		t.Errorf("parseCompactNodes() = %v, %v, want %v", got, err, nodes)
+	}
+
+	node6 := Node{ID: ID{3}, Addr: netip.MustParseAddrPort("[2001:db8::1]:6881")}
+	got, err = parseCompactNodes(appendCompactNode(nil, node6), 16)
+	if err != nil || !reflect.DeepEqual(got, []Node{node6}) {
+		t.Errorf("parseCompactNodes() = %v, %v, want %v", got, err, []Node{node6})
+	}
+
+	if _, err := parseCompactNodes(data[:30], 4); err == nil {
+		t.Errorf("expected error for truncated compact nodes")
+	}
+}
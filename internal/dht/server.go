@@ -0,0 +1,398 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// DefaultBootstrapNodes are well-known routers that new nodes join through.
+var DefaultBootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"router.utorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+}
+
+// DefaultQueryTimeout is how long a query waits for a response by default.
+const DefaultQueryTimeout = 5 * time.Second
+
+// Config configures a Server.
+type Config struct {
+	// ID is the node ID. If it is zero, the ID saved in StateFile is used,
+	// or else a random one.
+	ID ID
+	// Addr is the UDP address to listen on. It defaults to ":0".
+	Addr string
+	// BootstrapNodes are the "host:port" addresses that Bootstrap starts
+	// from.
+	BootstrapNodes []string
+	// StateFile, if set, is where the routing table is loaded from by
+	// NewServer and saved to by Close.
+	StateFile string
+	// QueryTimeout defaults to DefaultQueryTimeout.
+	QueryTimeout time.Duration
+}
+
+// A Server is a DHT node: it answers queries from other nodes, and queries
+// them to find nodes and peers.
+type Server struct {
+	id     ID
+	config Config
+	conn   *net.UDPConn
+	table  *table
+	tokens *tokenSecrets
+	peers  *peerStore
+
+	mu      sync.Mutex
+	pending map[string]*pendingQuery
+	nextTID uint16
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type pendingQuery struct {
+	addr     netip.AddrPort
+	response chan *message
+}
+
+// NewServer listens on config.Addr and starts answering queries.
+func NewServer(config Config) (*Server, error) {
+	if config.Addr == "" {
+		config.Addr = ":0"
+	}
+	if config.QueryTimeout == 0 {
+		config.QueryTimeout = DefaultQueryTimeout
+	}
+
+	var saved *state
+	if config.StateFile != "" {
+		var err error
+		if saved, err = loadState(config.StateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	id := config.ID
+	if id == (ID{}) {
+		if saved != nil {
+			id = saved.ID
+		} else {
+			id = RandomID()
+		}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	s := &Server{
+		id:      id,
+		config:  config,
+		conn:    conn,
+		table:   newTable(id),
+		tokens:  newTokenSecrets(),
+		peers:   newPeerStore(),
+		pending: make(map[string]*pendingQuery),
+		done:    make(chan struct{}),
+	}
+	if saved != nil && saved.ID == id {
+		// Nodes are only close to the ID they were found for.
+		nodes, _ := saved.nodes()
+		for _, node := range nodes {
+			s.table.add(node)
+		}
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+// ID returns the node ID.
+func (s *Server) ID() ID {
+	return s.id
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() netip.AddrPort {
+	return s.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// Nodes returns the good nodes in the routing table.
+func (s *Server) Nodes() []Node {
+	return s.table.nodes()
+}
+
+// AddNode adds a node to the routing table without contacting it, and
+// reports whether there was room for it.
+func (s *Server) AddNode(node Node) bool {
+	return s.table.add(node)
+}
+
+// Close stops the server and saves the routing table to the state file.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+		if s.config.StateFile != "" {
+			if saveErr := s.saveState(s.config.StateFile); saveErr != nil {
+				err = saveErr
+			}
+		}
+	})
+	return err
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := s.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+				continue
+			}
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+		var msg message
+		if err := bencode.Unmarshal(buf[:n], &msg); err != nil {
+			continue
+		}
+		switch msg.Y {
+		case "q":
+			s.handleQuery(from, &msg)
+		case "r", "e":
+			s.handleResponse(from, &msg)
+		}
+	}
+}
+
+func (s *Server) handleResponse(from netip.AddrPort, msg *message) {
+	s.mu.Lock()
+	query, ok := s.pending[msg.T]
+	if ok && query.addr == from {
+		delete(s.pending, msg.T)
+	}
+	s.mu.Unlock()
+
+	// Responses must come from the node that was queried.
+	if ok && query.addr == from {
+		query.response <- msg
+	}
+}
+
+func (s *Server) handleQuery(from netip.AddrPort, msg *message) {
+	if msg.A == nil {
+		s.sendError(from, msg.T, ErrorProtocol, "missing arguments")
+		return
+	}
+	s.table.add(Node{ID: msg.A.ID, Addr: from})
+
+	r := &response{ID: s.id}
+	switch msg.Q {
+	case "ping":
+	case "find_node":
+		if msg.A.Target == nil {
+			s.sendError(from, msg.T, ErrorProtocol, "missing target")
+			return
+		}
+		s.appendNodes(r, *msg.A.Target, from, msg.A.Want)
+	case "get_peers":
+		if msg.A.InfoHash == nil {
+			s.sendError(from, msg.T, ErrorProtocol, "missing info_hash")
+			return
+		}
+		r.Token = s.tokens.token(from.Addr())
+		if peers := s.peers.get(*msg.A.InfoHash); len(peers) > 0 {
+			for _, peer := range peers {
+				r.Values = append(r.Values, appendCompactPeer(nil, peer))
+			}
+		} else {
+			s.appendNodes(r, *msg.A.InfoHash, from, msg.A.Want)
+		}
+	case "announce_peer":
+		if msg.A.InfoHash == nil {
+			s.sendError(from, msg.T, ErrorProtocol, "missing info_hash")
+			return
+		}
+		if !s.tokens.valid(from.Addr(), msg.A.Token) {
+			s.sendError(from, msg.T, ErrorProtocol, "bad token")
+			return
+		}
+		port := from.Port()
+		if !msg.A.ImpliedPort {
+			if msg.A.Port <= 0 || msg.A.Port > 65535 {
+				s.sendError(from, msg.T, ErrorProtocol, "invalid port")
+				return
+			}
+			port = uint16(msg.A.Port)
+		}
+		s.peers.add(*msg.A.InfoHash, netip.AddrPortFrom(from.Addr(), port))
+	default:
+		s.sendError(from, msg.T, ErrorMethodUnknown, "method unknown")
+		return
+	}
+	s.send(from, &message{T: msg.T, Y: "r", R: r})
+}
+
+// appendNodes adds the nodes closest to target to r, of the querying node's
+// address family unless it asked for others with "want" (BEP 32).
+func (s *Server) appendNodes(r *response, target ID, from netip.AddrPort, want []string) {
+	want4, want6 := slices.Contains(want, "n4"), slices.Contains(want, "n6")
+	if !want4 && !want6 {
+		want4, want6 = from.Addr().Is4(), from.Addr().Is6()
+	}
+
+	var nodes4, nodes6 int
+	for _, node := range s.table.closest(target, len(s.table.nodes())) {
+		switch {
+		case node.Addr.Addr().Is4() && want4 && nodes4 < BucketSize:
+			r.Nodes = appendCompactNode(r.Nodes, node)
+			nodes4++
+		case node.Addr.Addr().Is6() && want6 && nodes6 < BucketSize:
+			r.Nodes6 = appendCompactNode(r.Nodes6, node)
+			nodes6++
+		}
+	}
+}
+
+func (s *Server) sendError(to netip.AddrPort, tid string, code int, text string) {
+	s.send(to, &message{T: tid, Y: "e", E: &Error{Code: code, Message: text}})
+}
+
+func (s *Server) send(to netip.AddrPort, msg *message) error {
+	data, err := bencode.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if _, err := s.conn.WriteToUDPAddrPort(data, to); err != nil {
+		return fmt.Errorf("failed to send message to %s: %w", to, err)
+	}
+	return nil
+}
+
+// query sends a query to addr and waits for its response. The routing table
+// learns whether the node answered.
+func (s *Server) query(ctx context.Context, addr netip.AddrPort, method string, args *arguments) (*response, error) {
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+	args.ID = s.id
+
+	s.mu.Lock()
+	s.nextTID++
+	tid := string(binary.BigEndian.AppendUint16(nil, s.nextTID))
+	query := &pendingQuery{addr: addr, response: make(chan *message, 1)}
+	s.pending[tid] = query
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, tid)
+		s.mu.Unlock()
+	}()
+
+	if err := s.send(addr, &message{T: tid, Y: "q", Q: method, A: args}); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(s.config.QueryTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-query.response:
+		if msg.Y == "e" {
+			if msg.E == nil {
+				return nil, fmt.Errorf("%s query to %s failed without an error", method, addr)
+			}
+			return nil, msg.E
+		}
+		if msg.R == nil {
+			return nil, fmt.Errorf("%s query to %s: missing response values", method, addr)
+		}
+		s.table.add(Node{ID: msg.R.ID, Addr: addr})
+		return msg.R, nil
+	case <-timer.C:
+		s.table.failed(addr)
+		return nil, fmt.Errorf("%s query to %s timed out", method, addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, errors.New("server closed")
+	}
+}
+
+// Ping asks the node at addr for its ID.
+func (s *Server) Ping(ctx context.Context, addr netip.AddrPort) (ID, error) {
+	r, err := s.query(ctx, addr, "ping", &arguments{})
+	if err != nil {
+		return ID{}, err
+	}
+	return r.ID, nil
+}
+
+// FindNode asks the node at addr for the nodes it knows closest to target.
+func (s *Server) FindNode(ctx context.Context, addr netip.AddrPort, target ID) ([]Node, error) {
+	r, err := s.query(ctx, addr, "find_node", &arguments{Target: &target})
+	if err != nil {
+		return nil, err
+	}
+	return r.nodes()
+}
+
+// GetPeersResponse is the answer to a get_peers query.
+type GetPeersResponse struct {
+	ID    ID
+	Peers []netip.AddrPort // Peers of the torrent, if the node knows any.
+	Nodes []Node           // Nodes closer to the info hash otherwise.
+	Token []byte           // Token for announcing to the node.
+}
+
+// GetPeers asks the node at addr for peers of the torrent with infoHash.
+func (s *Server) GetPeers(ctx context.Context, addr netip.AddrPort, infoHash ID) (*GetPeersResponse, error) {
+	r, err := s.query(ctx, addr, "get_peers", &arguments{InfoHash: &infoHash})
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := r.nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &GetPeersResponse{ID: r.ID, Nodes: nodes, Token: r.Token}
+	for _, value := range r.Values {
+		if len(value) != 6 && len(value) != 18 {
+			return nil, fmt.Errorf("expected compact peer to be 6 or 18 bytes, but got %d", len(value))
+		}
+		resp.Peers = append(resp.Peers, parseCompactPeer(value))
+	}
+	return resp, nil
+}
+
+// AnnouncePeer tells the node at addr that we are a peer of the torrent with
+// infoHash on port, using the token of its get_peers response. If port is
+// 0, the node uses the port the query came from.
+func (s *Server) AnnouncePeer(ctx context.Context, addr netip.AddrPort, infoHash ID, port int, token []byte) error {
+	args := &arguments{InfoHash: &infoHash, Port: port, Token: token}
+	if port == 0 {
+		// The port is required even though the node ignores it.
+		args.Port = int(s.Addr().Port())
+		args.ImpliedPort = true
+	}
+	_, err := s.query(ctx, addr, "announce_peer", args)
+	return err
+}
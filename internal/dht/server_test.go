@@ -0,0 +1,114 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a server on loopback that is closed with the test.
+func newTestServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	if config.Addr == "" {
+		config.Addr = "127.0.0.1:0"
+	}
+	if config.QueryTimeout == 0 {
+		config.QueryTimeout = time.Second
+	}
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestServerQueries(t *testing.T) {
+	ctx := context.Background()
+	a := newTestServer(t, Config{ID: ID{0x10}})
+	b := newTestServer(t, Config{ID: ID{0x20}})
+	known := Node{ID: ID{0x21}, Addr: netip.MustParseAddrPort("127.0.0.1:9")}
+	b.AddNode(known)
+
+	id, err := a.Ping(ctx, b.Addr())
+	if err != nil || id != b.ID() {
+		t.Fatalf("Ping() = %v, %v, want %v", id, err, b.ID())
+	}
+	// Both learn about each other.
+	if got := a.Nodes(); !reflect.DeepEqual(got, []Node{{ID: b.ID(), Addr: b.Addr()}}) {
+		t.Errorf("a.Nodes() = %v, want b", got)
+	}
+
+	nodes, err := a.FindNode(ctx, b.Addr(), ID{0x21})
+	if err != nil {
+		t.Fatalf("FindNode failed: %v", err)
+	}
+	if want := []Node{known, {ID: a.ID(), Addr: a.Addr()}}; !reflect.DeepEqual(nodes, want) {
+		t.Errorf("FindNode() = %v, want %v", nodes, want)
+	}
+
+	infoHash := ID{0x22}
+	resp, err := a.GetPeers(ctx, b.Addr(), infoHash)
+	if err != nil {
+		t.Fatalf("GetPeers failed: %v", err)
+	}
+	if len(resp.Peers) != 0 || len(resp.Nodes) == 0 || len(resp.Token) == 0 {
+		t.Errorf("GetPeers() = %+v, want nodes and a token but no peers", resp)
+	}
+
+	if err := a.AnnouncePeer(ctx, b.Addr(), infoHash, 6881, resp.Token); err != nil {
+		t.Fatalf("AnnouncePeer failed: %v", err)
+	}
+	if err := a.AnnouncePeer(ctx, b.Addr(), infoHash, 0, resp.Token); err != nil {
+		t.Fatalf("AnnouncePeer with implied port failed: %v", err)
+	}
+
+	resp, err = a.GetPeers(ctx, b.Addr(), infoHash)
+	if err != nil {
+		t.Fatalf("GetPeers failed: %v", err)
+	}
+	want := map[netip.AddrPort]bool{
+		netip.AddrPortFrom(a.Addr().Addr(), 6881): true,
+		a.Addr(): true,
+	}
+	got := make(map[netip.AddrPort]bool)
+	for _, peer := range resp.Peers {
+		got[peer] = true
+	}
+	if !reflect.DeepEqual(got, want) || len(resp.Nodes) != 0 {
+		t.Errorf("GetPeers() = %+v, want peers %v and no nodes", resp, want)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	ctx := context.Background()
+	a := newTestServer(t, Config{})
+	b := newTestServer(t, Config{})
+
+	var dhtErr *Error
+	err := a.AnnouncePeer(ctx, b.Addr(), ID{1}, 6881, []byte("bad token"))
+	if !errors.As(err, &dhtErr) || dhtErr.Code != ErrorProtocol {
+		t.Errorf("AnnouncePeer() with a bad token error = %v, want protocol error", err)
+	}
+
+	_, err = a.query(ctx, b.Addr(), "vote", &arguments{})
+	if !errors.As(err, &dhtErr) || dhtErr.Code != ErrorMethodUnknown {
+		t.Errorf("unknown query error = %v, want method unknown error", err)
+	}
+
+	_, err = a.query(ctx, b.Addr(), "find_node", &arguments{})
+	if !errors.As(err, &dhtErr) || dhtErr.Code != ErrorProtocol {
+		t.Errorf("find_node without a target error = %v, want protocol error", err)
+	}
+
+	// Nothing listens on b's address once it is closed.
+	addr := b.Addr()
+	b.Close()
+	a.config.QueryTimeout = 50 * time.Millisecond
+	if _, err := a.Ping(ctx, addr); err == nil {
+		t.Errorf("expected Ping to a closed server to fail")
+	}
+}
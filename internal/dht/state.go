@@ -0,0 +1,59 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/statefile"
+)
+
+// state is the part of a node that is saved across runs: its ID and the
+// nodes in its routing table, in compact form.
+type state struct {
+	ID     ID     `bencode:"id"`
+	Nodes  []byte `bencode:"nodes,omitempty"`
+	Nodes6 []byte `bencode:"nodes6,omitempty"`
+}
+
+func (st *state) nodes() ([]Node, error) {
+	r := response{Nodes: st.Nodes, Nodes6: st.Nodes6}
+	return r.nodes()
+}
+
+// loadState reads the routing table saved by an earlier run. It gives a nil
+// state if there was no earlier run, or if its state file is corrupt: the
+// file is only a cache, so the node then starts afresh.
+func loadState(path string) (*state, error) {
+	var st state
+	ok, err := statefile.Load(path, &st)
+	if errors.Is(err, statefile.ErrCorrupt) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load DHT state: %w", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	if _, err := st.nodes(); err != nil {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+// saveState writes the node ID and routing table to path, so that the next
+// run rejoins the DHT as the same node.
+func (s *Server) saveState(path string) error {
+	st := state{ID: s.id}
+	for _, node := range s.table.nodes() {
+		if node.Addr.Addr().Is4() {
+			st.Nodes = appendCompactNode(st.Nodes, node)
+		} else {
+			st.Nodes6 = appendCompactNode(st.Nodes6, node)
+		}
+	}
+	if err := statefile.Save(path, st); err != nil {
+		return fmt.Errorf("failed to save DHT state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package dht
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dht.dat")
+	nodes := []Node{
+		{ID: ID{0x80}, Addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+		{ID: ID{0x40}, Addr: netip.MustParseAddrPort("[2001:db8::1]:6881")},
+	}
+
+	s := newTestServer(t, Config{StateFile: path})
+	for _, node := range nodes {
+		s.AddNode(node)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	restored := newTestServer(t, Config{StateFile: path})
+	if restored.ID() != s.ID() {
+		t.Errorf("ID() = %v, want the saved ID %v", restored.ID(), s.ID())
+	}
+	got := restored.Nodes()
+	slices.SortFunc(got, func(a, b Node) int { return bytes.Compare(b.ID[:], a.ID[:]) })
+	if !reflect.DeepEqual(got, nodes) {
+		t.Errorf("Nodes() = %v, want %v", got, nodes)
+	}
+
+	// Nodes saved for another ID are not close to a new one.
+	other := newTestServer(t, Config{ID: ID{1}, StateFile: path})
+	if got := other.Nodes(); len(got) != 0 {
+		t.Errorf("Nodes() with a different ID = %v, want none", got)
+	}
+}
+
+func TestStateInvalid(t *testing.T) {
+	tests := map[string]string{
+		"bad nodes": "d2:id20:aaaaaaaaaaaaaaaaaaaa5:nodes3:abce",
+		"truncated": "d2:id20:aaaaaaaaaa",
+		"garbage":   "garbage",
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dht.dat")
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatalf("failed to write state: %v", err)
+			}
+
+			// A corrupt state file is ignored, and the node starts afresh.
+			s := newTestServer(t, Config{StateFile: path})
+			if id := s.ID(); id == (ID{}) || string(id[:]) == "aaaaaaaaaaaaaaaaaaaa" {
+				t.Errorf("ID() = %v, want a new random ID", s.ID())
+			}
+			if got := s.Nodes(); len(got) != 0 {
+				t.Errorf("Nodes() = %v, want none", got)
+			}
+		})
+	}
+
+	// Errors other than a missing or corrupt file still fail.
+	if _, err := NewServer(Config{Addr: "127.0.0.1:0", StateFile: t.TempDir()}); err == nil {
+		t.Errorf("expected NewServer to fail when the state file is a directory")
+	}
+}
@@ -0,0 +1,155 @@
+package dht
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenRotation is how often the token secret changes. Tokens made with
+	// the previous secret are still accepted, so a token is valid for up to
+	// twice as long.
+	tokenRotation = 5 * time.Minute
+
+	// peerExpiry is how long an announced peer is stored.
+	peerExpiry = 30 * time.Minute
+
+	maxStoredTorrents = 1000
+	maxStoredPeers    = 1000
+	// maxValues limits the peers in a get_peers response, which must fit in
+	// a UDP packet.
+	maxValues = 50
+)
+
+// tokenSecrets makes the tokens that get_peers responses carry and that
+// announce_peer queries must return, which prove that the announcing node
+// owns its IP address.
+type tokenSecrets struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	current  [16]byte
+	previous [16]byte
+	rotated  time.Time
+}
+
+func newTokenSecrets() *tokenSecrets {
+	s := &tokenSecrets{now: time.Now}
+	rand.Read(s.current[:])
+	rand.Read(s.previous[:])
+	s.rotated = s.now()
+	return s
+}
+
+// token returns the token for addr.
+func (s *tokenSecrets) token(addr netip.Addr) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate()
+	return makeToken(s.current, addr)
+}
+
+// valid reports whether token was given to addr recently.
+func (s *tokenSecrets) valid(addr netip.Addr, token []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate()
+	return hmac.Equal(token, makeToken(s.current, addr)) || hmac.Equal(token, makeToken(s.previous, addr))
+}
+
+func (s *tokenSecrets) rotate() {
+	now := s.now()
+	elapsed := now.Sub(s.rotated)
+	if elapsed < tokenRotation {
+		return
+	}
+	if elapsed < 2*tokenRotation {
+		s.previous = s.current
+	} else {
+		// Tokens made with the current secret are too old as well.
+		rand.Read(s.previous[:])
+	}
+	rand.Read(s.current[:])
+	s.rotated = now
+}
+
+func makeToken(secret [16]byte, addr netip.Addr) []byte {
+	mac := hmac.New(sha1.New, secret[:])
+	mac.Write(addr.Unmap().AsSlice())
+	return mac.Sum(nil)[:8]
+}
+
+// peerStore holds the peers announced to us, by info hash.
+type peerStore struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	torrents map[ID]map[netip.AddrPort]time.Time
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{now: time.Now, torrents: make(map[ID]map[netip.AddrPort]time.Time)}
+}
+
+// add stores peer for infoHash, unless the store is full.
+func (s *peerStore) add(infoHash ID, peer netip.AddrPort) {
+	peer = netip.AddrPortFrom(peer.Addr().Unmap(), peer.Port())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := s.torrents[infoHash]
+	if peers == nil {
+		if len(s.torrents) >= maxStoredTorrents {
+			s.expire()
+			if len(s.torrents) >= maxStoredTorrents {
+				return
+			}
+		}
+		peers = make(map[netip.AddrPort]time.Time)
+		s.torrents[infoHash] = peers
+	}
+	if _, ok := peers[peer]; !ok && len(peers) >= maxStoredPeers {
+		return
+	}
+	peers[peer] = s.now()
+}
+
+// get returns up to maxValues unexpired peers of infoHash.
+func (s *peerStore) get(infoHash ID) []netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var peers []netip.AddrPort
+	for peer, announced := range s.torrents[infoHash] {
+		if s.now().Sub(announced) >= peerExpiry {
+			delete(s.torrents[infoHash], peer)
+			continue
+		}
+		if len(peers) < maxValues {
+			peers = append(peers, peer)
+		}
+	}
+	if len(s.torrents[infoHash]) == 0 {
+		delete(s.torrents, infoHash)
+	}
+	return peers
+}
+
+// expire removes the expired peers of every torrent.
+func (s *peerStore) expire() {
+	for infoHash, peers := range s.torrents {
+		for peer, announced := range peers {
+			if s.now().Sub(announced) >= peerExpiry {
+				delete(peers, peer)
+			}
+		}
+		if len(peers) == 0 {
+			delete(s.torrents, infoHash)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestTokens(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	secrets := newTokenSecrets()
+	secrets.now = func() time.Time { return now }
+	secrets.rotated = now
+
+	addr := netip.MustParseAddr("10.0.0.1")
+	token := secrets.token(addr)
+	if !secrets.valid(addr, token) {
+		t.Errorf("expected a fresh token to be valid")
+	}
+	if secrets.valid(netip.MustParseAddr("10.0.0.2"), token) {
+		t.Errorf("expected a token to be valid only for its address")
+	}
+
+	now = now.Add(tokenRotation)
+	if !secrets.valid(addr, token) {
+		t.Errorf("expected a token to be valid after one rotation")
+	}
+	now = now.Add(tokenRotation)
+	if secrets.valid(addr, token) {
+		t.Errorf("expected a token to be invalid after two rotations")
+	}
+
+	token = secrets.token(addr)
+	now = now.Add(2 * tokenRotation)
+	if secrets.valid(addr, token) {
+		t.Errorf("expected a token to be invalid after a long pause")
+	}
+}
+
+func TestPeerStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := newPeerStore()
+	store.now = func() time.Time { return now }
+
+	a := netip.MustParseAddrPort("10.0.0.1:6881")
+	b := netip.MustParseAddrPort("[::ffff:10.0.0.2]:6881")
+	store.add(ID{1}, a)
+	now = now.Add(peerExpiry / 2)
+	store.add(ID{1}, b)
+
+	if got := store.get(ID{1}); len(got) != 2 {
+		t.Errorf("get() = %v, want 2 peers", got)
+	}
+	if got := store.get(ID{2}); got != nil {
+		t.Errorf("get() for an unknown torrent = %v, want nil", got)
+	}
+
+	now = now.Add(peerExpiry / 2)
+	want := netip.MustParseAddrPort("10.0.0.2:6881")
+	if got := store.get(ID{1}); len(got) != 1 || got[0] != want {
+		t.Errorf("get() = %v, want [%v]", got, want)
+	}
+
+	for i := range maxValues + 10 {
+		store.add(ID{3}, netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 1, 0, byte(i)}), 1))
+	}
+	if got := store.get(ID{3}); len(got) != maxValues {
+		t.Errorf("expected get() to return %d peers, got %d", maxValues, len(got))
+	}
+}
@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// BucketSize is the number of nodes a bucket holds, and the number of
+	// closest nodes that lookups converge on (k in Kademlia).
+	BucketSize = 8
+
+	// maxFailures is the number of queries in a row that a node may fail to
+	// answer before it is considered bad and may be replaced.
+	maxFailures = 2
+)
+
+type tableEntry struct {
+	Node
+	lastSeen time.Time
+	failures int
+}
+
+// table is the routing table. Nodes are kept in buckets by the number of
+// leading bits their ID shares with ours, so that it knows many nodes close
+// to us and a few far away.
+type table struct {
+	self ID
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets [IDLength * 8][]*tableEntry
+}
+
+func newTable(self ID) *table {
+	return &table{self: self, now: time.Now}
+}
+
+// add records that node was seen and reports whether it is in the table. A
+// node that does not fit is dropped, unless its bucket has a bad node that
+// it can replace.
+func (t *table) add(node Node) bool {
+	if node.ID == t.self || !node.Addr.Addr().IsValid() || node.Addr.Port() == 0 {
+		return false
+	}
+	node.Addr = netip.AddrPortFrom(node.Addr.Addr().Unmap(), node.Addr.Port())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := &t.buckets[commonPrefix(t.self, node.ID)]
+	for _, entry := range *bucket {
+		if entry.ID == node.ID {
+			entry.Addr = node.Addr
+			entry.lastSeen = t.now()
+			entry.failures = 0
+			return true
+		}
+	}
+
+	entry := &tableEntry{Node: node, lastSeen: t.now()}
+	if len(*bucket) < BucketSize {
+		*bucket = append(*bucket, entry)
+		return true
+	}
+
+	worst := -1
+	for i, e := range *bucket {
+		if e.failures >= maxFailures && (worst < 0 || e.failures > (*bucket)[worst].failures) {
+			worst = i
+		}
+	}
+	if worst < 0 {
+		return false
+	}
+	(*bucket)[worst] = entry
+	return true
+}
+
+// failed records that the node at addr did not answer a query.
+func (t *table) failed(addr netip.AddrPort) {
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, bucket := range t.buckets {
+		for _, entry := range bucket {
+			if entry.Addr == addr {
+				entry.failures++
+			}
+		}
+	}
+}
+
+// closest returns up to n good nodes ordered by their distance to target.
+func (t *table) closest(target ID, n int) []Node {
+	nodes := t.nodes()
+	slices.SortFunc(nodes, func(a, b Node) int { return compareDistance(target, a.ID, b.ID) })
+	return nodes[:min(n, len(nodes))]
+}
+
+// nodes returns the good nodes in the table.
+func (t *table) nodes() []Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var nodes []Node
+	for _, bucket := range t.buckets {
+		for _, entry := range bucket {
+			if entry.failures < maxFailures {
+				nodes = append(nodes, entry.Node)
+			}
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,86 @@
+package dht
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// idWithPrefix returns an ID that shares exactly bits leading bits with the
+// zero ID, made unique by n.
+func idWithPrefix(bits int, n byte) ID {
+	var id ID
+	id[bits/8] = 0x80 >> (bits % 8)
+	id[IDLength-1] |= n
+	return id
+}
+
+func testNode(id ID, n byte) Node {
+	return Node{ID: id, Addr: netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, n}), 6881)}
+}
+
+func TestTableAdd(t *testing.T) {
+	tbl := newTable(ID{})
+
+	if tbl.add(testNode(ID{}, 1)) {
+		t.Errorf("expected our own ID to be rejected")
+	}
+	if tbl.add(Node{ID: ID{1}}) {
+		t.Errorf("expected a node without an address to be rejected")
+	}
+
+	for i := range BucketSize {
+		if !tbl.add(testNode(idWithPrefix(0, byte(i+1)), byte(i+1))) {
+			t.Fatalf("expected node %d to fit in the bucket", i)
+		}
+	}
+	extra := testNode(idWithPrefix(0, 100), 100)
+	if tbl.add(extra) {
+		t.Errorf("expected a node not to fit in a full bucket of good nodes")
+	}
+	if !tbl.add(testNode(idWithPrefix(1, 1), 200)) {
+		t.Errorf("expected a node in another bucket to fit")
+	}
+
+	// A node that failed twice is bad and can be replaced.
+	bad := testNode(idWithPrefix(0, 3), 3)
+	tbl.failed(bad.Addr)
+	if tbl.add(extra) {
+		t.Errorf("expected a node with one failure not to be replaced")
+	}
+	tbl.failed(bad.Addr)
+	if !tbl.add(extra) {
+		t.Errorf("expected a bad node to be replaced")
+	}
+	for _, node := range tbl.nodes() {
+		if node.ID == bad.ID {
+			t.Errorf("expected %v to be gone from the table", bad.ID)
+		}
+	}
+
+	// Seeing a node again clears its failures and updates its address.
+	moved := testNode(idWithPrefix(0, 4), 44)
+	tbl.failed(testNode(idWithPrefix(0, 4), 4).Addr)
+	tbl.failed(testNode(idWithPrefix(0, 4), 4).Addr)
+	tbl.add(moved)
+	if got := tbl.closest(moved.ID, 1); !reflect.DeepEqual(got, []Node{moved}) {
+		t.Errorf("closest() = %v, want %v", got, []Node{moved})
+	}
+}
+
+func TestTableClosest(t *testing.T) {
+	tbl := newTable(ID{})
+	a := testNode(ID{0x01}, 1)
+	b := testNode(ID{0x02}, 2)
+	c := testNode(ID{0x80}, 3)
+	for _, node := range []Node{c, b, a} {
+		tbl.add(node)
+	}
+
+	if got, want := tbl.closest(ID{0x03}, 2), []Node{b, a}; !reflect.DeepEqual(got, want) {
+		t.Errorf("closest() = %v, want %v", got, want)
+	}
+	if got, want := tbl.closest(ID{0x81}, 10), []Node{c, a, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("closest() = %v, want %v", got, want)
+	}
+}
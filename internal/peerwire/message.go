@@ -0,0 +1,295 @@
+// Package peerwire encodes and decodes the messages of the BitTorrent peer
+// wire protocol (BEP 3), along with those of the Fast Extension (BEP 6), the
+// DHT port message (BEP 5) and the extension protocol (BEP 10).
+package peerwire
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MessageType is the ID byte that follows the length prefix of a message.
+type MessageType byte
+
+const (
+	TypeChoke         MessageType = 0
+	TypeUnchoke       MessageType = 1
+	TypeInterested    MessageType = 2
+	TypeNotInterested MessageType = 3
+	TypeHave          MessageType = 4
+	TypeBitfield      MessageType = 5
+	TypeRequest       MessageType = 6
+	TypePiece         MessageType = 7
+	TypeCancel        MessageType = 8
+	TypePort          MessageType = 9
+
+	TypeSuggestPiece  MessageType = 0x0D
+	TypeHaveAll       MessageType = 0x0E
+	TypeHaveNone      MessageType = 0x0F
+	TypeRejectRequest MessageType = 0x10
+	TypeAllowedFast   MessageType = 0x11
+
+	TypeExtended MessageType = 20
+)
+
+// A Message is a peer wire message other than a keep-alive.
+type Message interface {
+	// Type returns the message's ID.
+	Type() MessageType
+	// AppendPayload appends the payload, which follows the ID.
+	AppendPayload(b []byte) []byte
+}
+
+type (
+	Choke         struct{}
+	Unchoke       struct{}
+	Interested    struct{}
+	NotInterested struct{}
+	HaveAll       struct{}
+	HaveNone      struct{}
+)
+
+func (Choke) Type() MessageType         { return TypeChoke }
+func (Unchoke) Type() MessageType       { return TypeUnchoke }
+func (Interested) Type() MessageType    { return TypeInterested }
+func (NotInterested) Type() MessageType { return TypeNotInterested }
+func (HaveAll) Type() MessageType       { return TypeHaveAll }
+func (HaveNone) Type() MessageType      { return TypeHaveNone }
+
+func (Choke) AppendPayload(b []byte) []byte         { return b }
+func (Unchoke) AppendPayload(b []byte) []byte       { return b }
+func (Interested) AppendPayload(b []byte) []byte    { return b }
+func (NotInterested) AppendPayload(b []byte) []byte { return b }
+func (HaveAll) AppendPayload(b []byte) []byte       { return b }
+func (HaveNone) AppendPayload(b []byte) []byte      { return b }
+
+// Have announces that the sender has a piece.
+type Have struct {
+	Index uint32
+}
+
+func (Have) Type() MessageType { return TypeHave }
+
+func (m Have) AppendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, m.Index)
+}
+
+// Bitfield holds a bit for each piece, set if the sender has the piece. The
+// high bit of the first byte is piece 0.
+type Bitfield []byte
+
+func (Bitfield) Type() MessageType { return TypeBitfield }
+
+func (m Bitfield) AppendPayload(b []byte) []byte {
+	return append(b, m...)
+}
+
+// Has reports whether the bit of a piece is set.
+func (m Bitfield) Has(index int) bool {
+	if index < 0 || index >= len(m)*8 {
+		return false
+	}
+	return m[index/8]&(0x80>>(index%8)) != 0
+}
+
+// Request asks for a block of a piece.
+type Request struct {
+	Index, Begin, Length uint32
+}
+
+func (Request) Type() MessageType { return TypeRequest }
+
+func (m Request) AppendPayload(b []byte) []byte {
+	return appendBlockRef(b, m.Index, m.Begin, m.Length)
+}
+
+// Cancel withdraws a request.
+type Cancel struct {
+	Index, Begin, Length uint32
+}
+
+func (Cancel) Type() MessageType { return TypeCancel }
+
+func (m Cancel) AppendPayload(b []byte) []byte {
+	return appendBlockRef(b, m.Index, m.Begin, m.Length)
+}
+
+// RejectRequest tells the peer that a request will not be answered.
+type RejectRequest struct {
+	Index, Begin, Length uint32
+}
+
+func (RejectRequest) Type() MessageType { return TypeRejectRequest }
+
+func (m RejectRequest) AppendPayload(b []byte) []byte {
+	return appendBlockRef(b, m.Index, m.Begin, m.Length)
+}
+
+// Piece carries a block of a piece.
+type Piece struct {
+	Index, Begin uint32
+	Block        []byte
+}
+
+func (Piece) Type() MessageType { return TypePiece }
+
+func (m Piece) AppendPayload(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, m.Index)
+	b = binary.BigEndian.AppendUint32(b, m.Begin)
+	return append(b, m.Block...)
+}
+
+// Port is the UDP port of the sender's DHT node.
+type Port struct {
+	Port uint16
+}
+
+func (Port) Type() MessageType { return TypePort }
+
+func (m Port) AppendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint16(b, m.Port)
+}
+
+// SuggestPiece suggests that the receiver download a piece.
+type SuggestPiece struct {
+	Index uint32
+}
+
+func (SuggestPiece) Type() MessageType { return TypeSuggestPiece }
+
+func (m SuggestPiece) AppendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, m.Index)
+}
+
+// AllowedFast tells the receiver that it may request a piece even while
+// choked.
+type AllowedFast struct {
+	Index uint32
+}
+
+func (AllowedFast) Type() MessageType { return TypeAllowedFast }
+
+func (m AllowedFast) AppendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, m.Index)
+}
+
+// Extended is a message of the extension protocol. ID 0 is the extension
+// handshake; the others are the IDs the receiver assigned to extensions.
+type Extended struct {
+	ID      byte
+	Payload []byte
+}
+
+func (Extended) Type() MessageType { return TypeExtended }
+
+func (m Extended) AppendPayload(b []byte) []byte {
+	return append(append(b, m.ID), m.Payload...)
+}
+
+// Unknown is a message of a type this package does not know. Peers ignore
+// such messages.
+type Unknown struct {
+	ID      MessageType
+	Payload []byte
+}
+
+func (m Unknown) Type() MessageType { return m.ID }
+
+func (m Unknown) AppendPayload(b []byte) []byte {
+	return append(b, m.Payload...)
+}
+
+// AppendMessage appends a message with its length prefix. A nil message is
+// appended as a keep-alive.
+func AppendMessage(b []byte, m Message) []byte {
+	if m == nil {
+		return append(b, 0, 0, 0, 0)
+	}
+	start := len(b)
+	b = append(b, 0, 0, 0, 0, byte(m.Type()))
+	b = m.AppendPayload(b)
+	binary.BigEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+	return b
+}
+
+// Decode decodes the payload of a message of the given type. The payload is
+// not copied: Piece, Bitfield, Extended and Unknown messages share it.
+func Decode(t MessageType, payload []byte) (Message, error) {
+	switch t {
+	case TypeChoke, TypeUnchoke, TypeInterested, TypeNotInterested, TypeHaveAll, TypeHaveNone:
+		if len(payload) != 0 {
+			return nil, fmt.Errorf("expected an empty payload for message type %d, but got %d bytes", t, len(payload))
+		}
+		switch t {
+		case TypeChoke:
+			return Choke{}, nil
+		case TypeUnchoke:
+			return Unchoke{}, nil
+		case TypeInterested:
+			return Interested{}, nil
+		case TypeNotInterested:
+			return NotInterested{}, nil
+		case TypeHaveAll:
+			return HaveAll{}, nil
+		default:
+			return HaveNone{}, nil
+		}
+	case TypeHave, TypeSuggestPiece, TypeAllowedFast:
+		if len(payload) != 4 {
+			return nil, fmt.Errorf("expected a 4-byte payload for message type %d, but got %d bytes", t, len(payload))
+		}
+		index := binary.BigEndian.Uint32(payload)
+		switch t {
+		case TypeHave:
+			return Have{Index: index}, nil
+		case TypeSuggestPiece:
+			return SuggestPiece{Index: index}, nil
+		default:
+			return AllowedFast{Index: index}, nil
+		}
+	case TypeBitfield:
+		return Bitfield(payload), nil
+	case TypeRequest, TypeCancel, TypeRejectRequest:
+		if len(payload) != 12 {
+			return nil, fmt.Errorf("expected a 12-byte payload for message type %d, but got %d bytes", t, len(payload))
+		}
+		index := binary.BigEndian.Uint32(payload[0:4])
+		begin := binary.BigEndian.Uint32(payload[4:8])
+		length := binary.BigEndian.Uint32(payload[8:12])
+		switch t {
+		case TypeRequest:
+			return Request{Index: index, Begin: begin, Length: length}, nil
+		case TypeCancel:
+			return Cancel{Index: index, Begin: begin, Length: length}, nil
+		default:
+			return RejectRequest{Index: index, Begin: begin, Length: length}, nil
+		}
+	case TypePiece:
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("piece message payload too short: %d bytes", len(payload))
+		}
+		return Piece{
+			Index: binary.BigEndian.Uint32(payload[0:4]),
+			Begin: binary.BigEndian.Uint32(payload[4:8]),
+			Block: payload[8:],
+		}, nil
+	case TypePort:
+		if len(payload) != 2 {
+			return nil, fmt.Errorf("expected a 2-byte payload for port message, but got %d bytes", len(payload))
+		}
+		return Port{Port: binary.BigEndian.Uint16(payload)}, nil
+	case TypeExtended:
+		if len(payload) == 0 {
+			return nil, fmt.Errorf("extended message has no ID")
+		}
+		return Extended{ID: payload[0], Payload: payload[1:]}, nil
+	default:
+		return Unknown{ID: t, Payload: payload}, nil
+	}
+}
+
+func appendBlockRef(b []byte, index, begin, length uint32) []byte {
+	b = binary.BigEndian.AppendUint32(b, index)
+	b = binary.BigEndian.AppendUint32(b, begin)
+	return binary.BigEndian.AppendUint32(b, length)
+}
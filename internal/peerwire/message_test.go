@@ -0,0 +1,101 @@
+package peerwire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want []byte
+	}{
+		{name: "choke", msg: Choke{}, want: []byte{0, 0, 0, 1, 0}},
+		{name: "unchoke", msg: Unchoke{}, want: []byte{0, 0, 0, 1, 1}},
+		{name: "interested", msg: Interested{}, want: []byte{0, 0, 0, 1, 2}},
+		{name: "not interested", msg: NotInterested{}, want: []byte{0, 0, 0, 1, 3}},
+		{name: "have", msg: Have{Index: 258}, want: []byte{0, 0, 0, 5, 4, 0, 0, 1, 2}},
+		{name: "bitfield", msg: Bitfield{0xa0, 0x01}, want: []byte{0, 0, 0, 3, 5, 0xa0, 0x01}},
+		{
+			name: "request",
+			msg:  Request{Index: 1, Begin: 16384, Length: 16384},
+			want: []byte{0, 0, 0, 13, 6, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0},
+		},
+		{
+			name: "piece",
+			msg:  Piece{Index: 1, Begin: 2, Block: []byte("abc")},
+			want: []byte{0, 0, 0, 12, 7, 0, 0, 0, 1, 0, 0, 0, 2, 'a', 'b', 'c'},
+		},
+		{
+			name: "cancel",
+			msg:  Cancel{Index: 1, Begin: 2, Length: 3},
+			want: []byte{0, 0, 0, 13, 8, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3},
+		},
+		{name: "port", msg: Port{Port: 6881}, want: []byte{0, 0, 0, 3, 9, 0x1a, 0xe1}},
+		{name: "suggest piece", msg: SuggestPiece{Index: 7}, want: []byte{0, 0, 0, 5, 0x0D, 0, 0, 0, 7}},
+		{name: "have all", msg: HaveAll{}, want: []byte{0, 0, 0, 1, 0x0E}},
+		{name: "have none", msg: HaveNone{}, want: []byte{0, 0, 0, 1, 0x0F}},
+		{
+			name: "reject request",
+			msg:  RejectRequest{Index: 1, Begin: 2, Length: 3},
+			want: []byte{0, 0, 0, 13, 0x10, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3},
+		},
+		{name: "allowed fast", msg: AllowedFast{Index: 8}, want: []byte{0, 0, 0, 5, 0x11, 0, 0, 0, 8}},
+		{name: "extended", msg: Extended{ID: 1, Payload: []byte("de")}, want: []byte{0, 0, 0, 4, 20, 1, 'd', 'e'}},
+		{name: "unknown", msg: Unknown{ID: 42, Payload: []byte{1}}, want: []byte{0, 0, 0, 2, 42, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AppendMessage(nil, tt.msg)
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("AppendMessage() = %v, want %v", got, tt.want)
+			}
+			decoded, err := Decode(MessageType(got[4]), got[5:])
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.msg) {
+				t.Errorf("Decode() = %#v, want %#v", decoded, tt.msg)
+			}
+		})
+	}
+
+	if got := AppendMessage([]byte{9}, nil); !bytes.Equal(got, []byte{9, 0, 0, 0, 0}) {
+		t.Errorf("AppendMessage(nil) = %v, want a keep-alive", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		t       MessageType
+		payload []byte
+	}{
+		{name: "choke with payload", t: TypeChoke, payload: []byte{1}},
+		{name: "short have", t: TypeHave, payload: []byte{0, 0, 1}},
+		{name: "long allowed fast", t: TypeAllowedFast, payload: []byte{0, 0, 0, 1, 2}},
+		{name: "short request", t: TypeRequest, payload: make([]byte, 11)},
+		{name: "short cancel", t: TypeCancel, payload: make([]byte, 8)},
+		{name: "short piece", t: TypePiece, payload: make([]byte, 7)},
+		{name: "short port", t: TypePort, payload: []byte{1}},
+		{name: "empty extended", t: TypeExtended},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Decode(tt.t, tt.payload); err == nil {
+				t.Errorf("Decode() = %#v, want error", got)
+			}
+		})
+	}
+}
+
+func TestBitfieldHas(t *testing.T) {
+	bitfield := Bitfield{0x80, 0x01}
+	for index, want := range map[int]bool{0: true, 1: false, 7: false, 15: true, 16: false, -1: false} {
+		if got := bitfield.Has(index); got != want {
+			t.Errorf("Has(%d) = %v, want %v", index, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package peerwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessageSize is the largest message a Reader accepts by default,
+// counting the ID and payload. It leaves room for blocks larger than the
+// usual 16 KiB and for the bitfields of torrents with millions of pieces.
+const DefaultMaxMessageSize = 1 << 20
+
+// MessageTooLargeError is returned for a message whose length prefix exceeds
+// the maximum. The stream cannot be resynchronized after it.
+type MessageTooLargeError struct {
+	Length, Max uint32
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message length %d exceeds the maximum of %d", e.Length, e.Max)
+}
+
+// A Reader reads messages from a connection. It does not buffer, so the
+// connection can be read directly between messages.
+type Reader struct {
+	r io.Reader
+	// MaxMessageSize defaults to DefaultMaxMessageSize.
+	MaxMessageSize uint32
+	// KeepAlives counts the keep-alives that were skipped.
+	KeepAlives int
+}
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadRaw reads the next message without decoding its payload. Keep-alives
+// are skipped.
+func (r *Reader) ReadRaw() (MessageType, []byte, error) {
+	max := r.MaxMessageSize
+	if max == 0 {
+		max = DefaultMaxMessageSize
+	}
+
+	var lengthBuf [4]byte
+	for {
+		if _, err := io.ReadFull(r.r, lengthBuf[:]); err != nil {
+			return 0, nil, fmt.Errorf("failed to read message length: %w", err)
+		}
+		length := binary.BigEndian.Uint32(lengthBuf[:])
+		if length == 0 {
+			r.KeepAlives++
+			continue
+		}
+		if length > max {
+			return 0, nil, &MessageTooLargeError{Length: length, Max: max}
+		}
+
+		message := make([]byte, length)
+		if _, err := io.ReadFull(r.r, message); err != nil {
+			return 0, nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		var payload []byte
+		if length > 1 {
+			payload = message[1:]
+		}
+		return MessageType(message[0]), payload, nil
+	}
+}
+
+// ReadMessage reads and decodes the next message. Keep-alives are skipped.
+func (r *Reader) ReadMessage() (Message, error) {
+	t, payload, err := r.ReadRaw()
+	if err != nil {
+		return nil, err
+	}
+	return Decode(t, payload)
+}
@@ -0,0 +1,77 @@
+package peerwire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	var stream []byte
+	stream = AppendMessage(stream, nil)
+	stream = AppendMessage(stream, Have{Index: 3})
+	stream = AppendMessage(stream, nil)
+	stream = AppendMessage(stream, nil)
+	stream = AppendMessage(stream, Piece{Index: 1, Begin: 0, Block: []byte("block")})
+	r := NewReader(bytes.NewReader(stream))
+
+	for _, want := range []Message{Have{Index: 3}, Piece{Index: 1, Block: []byte("block")}} {
+		got, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadMessage() = %#v, want %#v", got, want)
+		}
+	}
+	if r.KeepAlives != 3 {
+		t.Errorf("KeepAlives = %d, want 3", r.KeepAlives)
+	}
+	if _, err := r.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadMessage() at the end error = %v, want EOF", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  []byte
+		max     uint32
+		wantErr error
+	}{
+		{
+			name:    "too large",
+			stream:  AppendMessage(nil, Bitfield(make([]byte, 100))),
+			max:     100,
+			wantErr: &MessageTooLargeError{Length: 101, Max: 100},
+		},
+		{
+			name:    "default maximum",
+			stream:  []byte{0, 0x10, 0, 1, 5},
+			wantErr: &MessageTooLargeError{Length: DefaultMaxMessageSize + 1, Max: DefaultMaxMessageSize},
+		},
+		{name: "truncated length", stream: []byte{0, 0}, wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated message", stream: []byte{0, 0, 0, 5, 4, 0}, wantErr: io.ErrUnexpectedEOF},
+		{name: "invalid payload", stream: []byte{0, 0, 0, 2, 4, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(bytes.NewReader(tt.stream))
+			r.MaxMessageSize = tt.max
+			_, err := r.ReadMessage()
+			if err == nil {
+				t.Fatalf("ReadMessage() expected error")
+			}
+			var tooLarge *MessageTooLargeError
+			if want, ok := tt.wantErr.(*MessageTooLargeError); ok {
+				if !errors.As(err, &tooLarge) || *tooLarge != *want {
+					t.Errorf("ReadMessage() error = %v, want %v", err, want)
+				}
+			} else if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadMessage() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package peerwire
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// defaultWriterBufferSize fits a 16 KiB block along with the small messages
+// around it.
+const defaultWriterBufferSize = 32 * 1024
+
+// A Writer batches messages into a buffer, so that the small messages of a
+// burst, such as a run of requests, go out in one write. Nothing is sent
+// until Flush, or until the buffer fills up. It is safe for concurrent use;
+// messages are never interleaved.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+// NewWriter returns a Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, buf: make([]byte, 0, defaultWriterBufferSize)}
+}
+
+// WriteMessage buffers a message. A nil message is a keep-alive. Once the
+// buffer holds enough to fill it, the buffer is written out.
+func (w *Writer) WriteMessage(m Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.buf = AppendMessage(w.buf, m)
+	if len(w.buf) >= defaultWriterBufferSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered messages.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.flush()
+}
+
+// Buffered returns the number of bytes waiting to be flushed.
+func (w *Writer) Buffered() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.buf)
+}
+
+// flush writes out the buffer. An error sticks, since the peer may have
+// received part of a message. w.mu must be held.
+func (w *Writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if _, err := w.w.Write(w.buf); err != nil {
+		w.err = fmt.Errorf("failed to write message: %w", err)
+		return w.err
+	}
+	if cap(w.buf) > 2*defaultWriterBufferSize {
+		// Let go of the space a large message took.
+		w.buf = make([]byte, 0, defaultWriterBufferSize)
+	} else {
+		w.buf = w.buf[:0]
+	}
+	return nil
+}
+
+// WriteMessages writes messages to w in a single call, bypassing any
+// buffering: goroutines sharing a connection that allows concurrent writes
+// do not interleave them.
+func WriteMessages(w io.Writer, messages ...Message) error {
+	var b []byte
+	for _, m := range messages {
+		b = AppendMessage(b, m)
+	}
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package peerwire
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recordingWriter records each write separately.
+type recordingWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+	err    error
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.writes = append(w.writes, bytes.Clone(p))
+	return len(p), nil
+}
+
+func TestWriterBatches(t *testing.T) {
+	conn := &recordingWriter{}
+	w := NewWriter(conn)
+
+	var want []byte
+	for i := range uint32(10) {
+		msg := Request{Index: 0, Begin: i * 16384, Length: 16384}
+		want = AppendMessage(want, msg)
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatalf("WriteMessage() error = %v", err)
+		}
+	}
+	want = AppendMessage(want, nil)
+	w.WriteMessage(nil)
+	if len(conn.writes) != 0 {
+		t.Fatalf("Writer wrote before Flush: %d writes", len(conn.writes))
+	}
+	if w.Buffered() != len(want) {
+		t.Errorf("Buffered() = %d, want %d", w.Buffered(), len(want))
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(conn.writes) != 1 || !bytes.Equal(conn.writes[0], want) {
+		t.Errorf("Flush() wrote %d times, want the batch in one write", len(conn.writes))
+	}
+
+	// A full buffer is written out without waiting for Flush.
+	block := make([]byte, defaultWriterBufferSize)
+	if err := w.WriteMessage(Piece{Block: block}); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if len(conn.writes) != 2 || w.Buffered() != 0 {
+		t.Errorf("writes = %d, buffered = %d after a large message, want 2, 0", len(conn.writes), w.Buffered())
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	errBroken := errors.New("broken pipe")
+	conn := &recordingWriter{err: errBroken}
+	w := NewWriter(conn)
+	w.WriteMessage(Interested{})
+	if err := w.Flush(); !errors.Is(err, errBroken) {
+		t.Fatalf("Flush() error = %v, want %v", err, errBroken)
+	}
+
+	// The error sticks, since part of a message may have been sent.
+	conn.err = nil
+	if err := w.WriteMessage(Unchoke{}); !errors.Is(err, errBroken) {
+		t.Errorf("WriteMessage() after a failed write error = %v, want %v", err, errBroken)
+	}
+}
+
+func TestWriterConcurrent(t *testing.T) {
+	conn := &recordingWriter{}
+	w := NewWriter(conn)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				w.WriteMessage(Piece{Index: uint32(i), Block: make([]byte, 1000)})
+			}
+		}()
+	}
+	wg.Wait()
+	w.Flush()
+
+	// Every message must come out whole.
+	r := NewReader(bytes.NewReader(bytes.Join(conn.writes, nil)))
+	for range 800 {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if piece, ok := msg.(Piece); !ok || len(piece.Block) != 1000 {
+			t.Fatalf("ReadMessage() = %T, want a 1000-byte piece", msg)
+		}
+	}
+}
+
+func TestWriteMessages(t *testing.T) {
+	conn := &recordingWriter{}
+	if err := WriteMessages(conn, Interested{}, Request{Length: 1}); err != nil {
+		t.Fatalf("WriteMessages() error = %v", err)
+	}
+	want := AppendMessage(AppendMessage(nil, Interested{}), Request{Length: 1})
+	if len(conn.writes) != 1 || !bytes.Equal(conn.writes[0], want) {
+		t.Errorf("WriteMessages() wrote %v, want %v in one write", conn.writes, want)
+	}
+}
@@ -0,0 +1,53 @@
+// Package statefile keeps bencoded state in a file across runs.
+package statefile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// ErrCorrupt is returned by Load, wrapped, when the file cannot be decoded.
+var ErrCorrupt = errors.New("corrupt state file")
+
+// Load decodes the file at path into v. It reports false, with no error,
+// if the file does not exist.
+func Load(path string, v any) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := bencode.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return true, nil
+}
+
+// Save encodes v into the file at path. The new contents are written to a
+// temporary file in the same directory, which then replaces the old one, so
+// that a crash leaves either the old state or the new one.
+func Save(path string, v any) error {
+	data, err := bencode.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
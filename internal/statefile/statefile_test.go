@@ -0,0 +1,50 @@
+package statefile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testState struct {
+	Name  string `bencode:"name"`
+	Count int    `bencode:"count"`
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.dat")
+
+	var got testState
+	if ok, err := Load(path, &got); ok || err != nil {
+		t.Fatalf("Load() of a missing file = %v, %v, want false, nil", ok, err)
+	}
+
+	want := testState{Name: "swarm", Count: 3}
+	if err := Save(path, want); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if ok, err := Load(path, &got); !ok || err != nil {
+		t.Fatalf("Load() = %v, %v, want true, nil", ok, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() decoded %+v, want %+v", got, want)
+	}
+
+	// Only the state file is left behind.
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Save() left %d files, want 1", len(entries))
+	}
+
+	if err := os.WriteFile(path, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, &got); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Load() error = %v, want ErrCorrupt for a corrupt file", err)
+	}
+	if err := Save(filepath.Join(dir, "missing", "state.dat"), want); err == nil {
+		t.Errorf("Save() expected error for a missing directory")
+	}
+}
@@ -0,0 +1,245 @@
+package torrent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAnnounceInterval is used when a tracker does not say how often
+	// to announce.
+	DefaultAnnounceInterval = 30 * time.Minute
+
+	// announceRetryInterval is the first wait after a failed announce; it
+	// doubles with each failure in a row, up to the announce interval.
+	announceRetryInterval = 15 * time.Second
+
+	// stoppedTimeout bounds the stopped announce that Run sends when it is
+	// cancelled, which cannot use the cancelled context.
+	stoppedTimeout = 5 * time.Second
+)
+
+// TransferStats are the counters an announce reports.
+type TransferStats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+}
+
+// An AnnounceResult is the outcome of an announce made by an Announcer.
+// Warnings from the tracker are in Response.Warning.
+type AnnounceResult struct {
+	Event    AnnounceEvent
+	Response *AnnounceResponse // Nil if Err is set.
+	Err      error
+}
+
+// An Announcer keeps us announced to a tracker for the lifetime of a
+// download: it sends the started, completed and stopped events with the
+// current transfer counters, re-announces as often as the tracker asks, and
+// echoes the tracker's ID.
+type Announcer struct {
+	Tracker  Tracker
+	InfoHash [20]byte
+	PeerID   [20]byte
+	Port     uint16
+
+	// Stats returns the current counters. Zero counters are sent if it is
+	// nil.
+	Stats func() TransferStats
+	// OnResult, if set, is called with the outcome of every announce.
+	OnResult func(AnnounceResult)
+
+	key       uint32
+	completed chan struct{}
+	now       func() time.Time
+
+	mu           sync.Mutex
+	trackerID    string
+	interval     time.Duration
+	minInterval  time.Duration
+	lastAnnounce time.Time
+	started      bool
+}
+
+// NewAnnouncer returns an announcer for the torrent with infoHash.
+func NewAnnouncer(tracker Tracker, infoHash, peerID [20]byte, port uint16) *Announcer {
+	var key [4]byte
+	rand.Read(key[:])
+	return &Announcer{
+		Tracker:   tracker,
+		InfoHash:  infoHash,
+		PeerID:    peerID,
+		Port:      port,
+		key:       binary.BigEndian.Uint32(key[:]),
+		completed: make(chan struct{}, 1),
+		now:       time.Now,
+	}
+}
+
+// Announce sends one announce with event and the current counters. Stopped
+// and completed announces are only sent after a started one.
+func (a *Announcer) Announce(ctx context.Context, event AnnounceEvent) (*AnnounceResponse, error) {
+	a.mu.Lock()
+	if (event == EventStopped || event == EventCompleted) && !a.started {
+		a.mu.Unlock()
+		return nil, fmt.Errorf("cannot announce %s before started", event)
+	}
+	req := &AnnounceRequest{
+		InfoHash:  a.InfoHash,
+		PeerID:    a.PeerID,
+		Port:      a.Port,
+		Event:     event,
+		Key:       a.key,
+		TrackerID: a.trackerID,
+	}
+	a.mu.Unlock()
+
+	if a.Stats != nil {
+		stats := a.Stats()
+		req.Uploaded, req.Downloaded, req.Left = stats.Uploaded, stats.Downloaded, stats.Left
+	}
+
+	resp, err := a.Tracker.Announce(ctx, req)
+	if a.OnResult != nil {
+		a.OnResult(AnnounceResult{Event: event, Response: resp, Err: err})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if resp.TrackerID != "" {
+		a.trackerID = resp.TrackerID
+	}
+	a.interval, a.minInterval = resp.Interval, resp.MinInterval
+	a.lastAnnounce = a.now()
+	switch event {
+	case EventStarted:
+		a.started = true
+	case EventStopped:
+		a.started = false
+	}
+	return resp, nil
+}
+
+// Completed tells a running Run that the download finished, so that it
+// announces the completed event right away.
+func (a *Announcer) Completed() {
+	select {
+	case a.completed <- struct{}{}:
+	default:
+	}
+}
+
+// nextAnnounce returns how long to wait before the next regular announce:
+// the tracker's interval, but never less than its minimum interval.
+func (a *Announcer) nextAnnounce() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	interval := a.interval
+	if interval <= 0 {
+		interval = DefaultAnnounceInterval
+	}
+	return max(interval, a.minInterval)
+}
+
+// retryWait returns how long to wait after the given number of failed
+// announces in a row: announceRetryInterval, doubled for each earlier
+// failure, but never more than the regular wait.
+func (a *Announcer) retryWait(failures int) time.Duration {
+	limit := a.nextAnnounce()
+	wait := announceRetryInterval
+	for ; failures > 0 && wait < limit; failures-- {
+		wait *= 2
+	}
+	return min(wait, limit)
+}
+
+// Run announces started, unless an earlier Announce did, then re-announces
+// on the tracker's interval until ctx is done, when it announces stopped,
+// preceded by completed if Completed was called and that was not yet sent.
+// Failed announces are retried with exponential backoff and reported
+// through OnResult, so Run only returns when ctx is done, with the error of
+// the stopped announce if it failed.
+func (a *Announcer) Run(ctx context.Context) error {
+	failures := 0
+	completed := a.completed
+	wantCompleted := false
+
+	var wait time.Duration
+	if a.isStarted() {
+		wait = a.nextAnnounce()
+	}
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		case <-completed:
+			// The next announce, right away, is completed.
+			completed = nil
+			wantCompleted = true
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			break
+		}
+
+		event := EventNone
+		switch {
+		case !a.isStarted():
+			event = EventStarted
+		case wantCompleted:
+			event = EventCompleted
+		}
+
+		if _, err := a.Announce(ctx, event); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			wait = a.retryWait(failures)
+			if wait < a.nextAnnounce() {
+				failures++
+			}
+		} else {
+			wait = a.nextAnnounce()
+			if event == EventCompleted {
+				wantCompleted = false
+			} else if wantCompleted {
+				// Completed came in before started was announced.
+				wait = 0
+			}
+			failures = 0
+		}
+	}
+
+	if !a.isStarted() {
+		return nil
+	}
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stoppedTimeout)
+	defer cancel()
+	select {
+	case <-completed:
+		wantCompleted = true
+	default:
+	}
+	if wantCompleted {
+		// A download that finished just before it was cancelled still
+		// reports completed ahead of stopped.
+		a.Announce(stopCtx, EventCompleted)
+	}
+	_, err := a.Announce(stopCtx, EventStopped)
+	return err
+}
+
+func (a *Announcer) isStarted() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.started
+}
@@ -0,0 +1,217 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTracker records announces and answers each with response, or err.
+type fakeTracker struct {
+	response AnnounceResponse
+	err      error
+
+	mu       sync.Mutex
+	requests []AnnounceRequest
+}
+
+func (f *fakeTracker) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, *req)
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := f.response
+	return &resp, nil
+}
+
+func (f *fakeTracker) events() []AnnounceEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []AnnounceEvent
+	for _, req := range f.requests {
+		events = append(events, req.Event)
+	}
+	return events
+}
+
+func TestAnnouncer(t *testing.T) {
+	tracker := &fakeTracker{response: AnnounceResponse{Interval: time.Hour, TrackerID: "id-1", Warning: "be nice"}}
+	announcer := NewAnnouncer(tracker, [20]byte{1}, [20]byte{2}, 6881)
+	stats := TransferStats{Left: 100}
+	announcer.Stats = func() TransferStats { return stats }
+	var results []AnnounceResult
+	announcer.OnResult = func(result AnnounceResult) { results = append(results, result) }
+	ctx := context.Background()
+
+	if _, err := announcer.Announce(ctx, EventStopped); err == nil {
+		t.Errorf("expected stopped to fail before started")
+	}
+	if _, err := announcer.Announce(ctx, EventStarted); err != nil {
+		t.Fatalf("Announce(started) failed: %v", err)
+	}
+	stats = TransferStats{Uploaded: 10, Downloaded: 100}
+	if _, err := announcer.Announce(ctx, EventCompleted); err != nil {
+		t.Fatalf("Announce(completed) failed: %v", err)
+	}
+	if _, err := announcer.Announce(ctx, EventStopped); err != nil {
+		t.Fatalf("Announce(stopped) failed: %v", err)
+	}
+
+	reqs := tracker.requests
+	if len(reqs) != 3 {
+		t.Fatalf("expected 3 announces, got %d", len(reqs))
+	}
+	if reqs[0].Event != EventStarted || reqs[0].Left != 100 || reqs[0].TrackerID != "" {
+		t.Errorf("started announce = %+v", reqs[0])
+	}
+	if reqs[1].Event != EventCompleted || reqs[1].Downloaded != 100 || reqs[1].Uploaded != 10 || reqs[1].Left != 0 {
+		t.Errorf("completed announce = %+v", reqs[1])
+	}
+	if reqs[1].TrackerID != "id-1" || reqs[1].Key != reqs[0].Key || reqs[1].Port != 6881 {
+		t.Errorf("expected later announces to echo the tracker ID and keep the key, got %+v", reqs[1])
+	}
+	if len(results) != 3 || results[0].Response.Warning != "be nice" {
+		t.Errorf("expected a result with the warning for each announce, got %+v", results)
+	}
+
+	tracker.err = &TrackerError{Reason: "banned"}
+	if _, err := announcer.Announce(ctx, EventStarted); !errors.As(err, new(*TrackerError)) {
+		t.Errorf("Announce() error = %v, want a *TrackerError", err)
+	}
+	if last := results[len(results)-1]; last.Err == nil || last.Response != nil {
+		t.Errorf("expected the failed announce to be reported, got %+v", last)
+	}
+}
+
+func TestAnnouncerRun(t *testing.T) {
+	tracker := &fakeTracker{response: AnnounceResponse{Interval: time.Millisecond, MinInterval: 30 * time.Millisecond}}
+	announcer := NewAnnouncer(tracker, [20]byte{1}, [20]byte{2}, 6881)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- announcer.Run(ctx) }()
+
+	// The minimum interval wins over the shorter interval.
+	time.Sleep(50 * time.Millisecond)
+	announcer.Completed()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// Started, regular announces no more often than the minimum interval
+	// allows, then completed right away and stopped.
+	events := tracker.events()
+	n := len(events)
+	if n < 4 || events[0] != EventStarted || events[n-2] != EventCompleted || events[n-1] != EventStopped {
+		t.Fatalf("announced %q, want started, regular announces, completed and stopped", events)
+	}
+	if regular := n - 3; regular > 2 {
+		t.Errorf("expected at most 2 regular announces in 50ms with a 30ms minimum interval, got %d", regular)
+	}
+}
+
+func TestAnnouncerRunFailing(t *testing.T) {
+	tracker := &fakeTracker{err: errors.New("unreachable")}
+	announcer := NewAnnouncer(tracker, [20]byte{1}, [20]byte{2}, 6881)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := announcer.Run(ctx); err != nil {
+		t.Errorf("Run() error = %v, want nil when never started", err)
+	}
+	if events := tracker.events(); len(events) != 1 || events[0] != EventStarted {
+		t.Errorf("announced %q, want a single started before backing off", events)
+	}
+}
+
+func TestAnnouncerRetryWait(t *testing.T) {
+	announcer := NewAnnouncer(&fakeTracker{}, [20]byte{1}, [20]byte{2}, 6881)
+
+	// Many failures in a row back off up to the announce interval and stay
+	// there, rather than overflowing back to no wait at all.
+	last := time.Duration(0)
+	for failures := 0; failures < 200; failures++ {
+		wait := announcer.retryWait(failures)
+		if wait < last || wait > DefaultAnnounceInterval {
+			t.Fatalf("retryWait(%d) = %v after %v, want a wait that grows up to %v", failures, wait, last, DefaultAnnounceInterval)
+		}
+		last = wait
+	}
+	if last != DefaultAnnounceInterval {
+		t.Errorf("retryWait(199) = %v, want %v", last, DefaultAnnounceInterval)
+	}
+	if wait := announcer.retryWait(0); wait != announceRetryInterval {
+		t.Errorf("retryWait(0) = %v, want %v", wait, announceRetryInterval)
+	}
+}
+
+func TestAnnouncerRunAfterStarted(t *testing.T) {
+	tracker := &fakeTracker{response: AnnounceResponse{Interval: 30 * time.Millisecond}}
+	announcer := NewAnnouncer(tracker, [20]byte{1}, [20]byte{2}, 6881)
+	if _, err := announcer.Announce(context.Background(), EventStarted); err != nil {
+		t.Fatalf("Announce(started) failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- announcer.Run(ctx) }()
+
+	// Run waits out the interval before its first, regular, announce.
+	time.Sleep(10 * time.Millisecond)
+	if events := tracker.events(); len(events) != 1 {
+		t.Errorf("announced %q right after Run started, want only the earlier started", events)
+	}
+	time.Sleep(40 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	events := tracker.events()
+	if n := len(events); n < 3 || events[1] != EventNone || events[n-1] != EventStopped {
+		t.Errorf("announced %q, want started, a regular announce and stopped", events)
+	}
+}
+
+func TestAnnouncerRunCompletedFirst(t *testing.T) {
+	tracker := &fakeTracker{response: AnnounceResponse{Interval: time.Hour}}
+	announcer := NewAnnouncer(tracker, [20]byte{1}, [20]byte{2}, 6881)
+	announcer.Completed()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- announcer.Run(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if events := tracker.events(); len(events) != 3 || events[0] != EventStarted || events[1] != EventCompleted {
+		t.Errorf("announced %q, want started, completed right away and stopped", events)
+	}
+}
+
+func TestAnnouncerRunCompletedOnCancel(t *testing.T) {
+	tracker := &fakeTracker{response: AnnounceResponse{Interval: time.Hour}}
+	announcer := NewAnnouncer(tracker, [20]byte{1}, [20]byte{2}, 6881)
+	if _, err := announcer.Announce(context.Background(), EventStarted); err != nil {
+		t.Fatalf("Announce(started) failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- announcer.Run(ctx) }()
+	announcer.Completed()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if events := tracker.events(); len(events) != 3 || events[1] != EventCompleted || events[2] != EventStopped {
+		t.Errorf("announced %q, want started, completed and stopped", events)
+	}
+}
@@ -0,0 +1,53 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// PeerAddr is the address of a peer, and its peer ID if the source of the
+// address sent it. Its String method formats the address for dialing, with
+// IPv6 addresses in brackets.
+type PeerAddr struct {
+	netip.AddrPort
+	PeerID [20]byte // Zero if unknown.
+}
+
+// HasPeerID reports whether the peer ID is known.
+func (p PeerAddr) HasPeerID() bool {
+	return p.PeerID != [20]byte{}
+}
+
+// parseCompactPeers parses peers in compact form: each is an IPv4 or IPv6
+// address of ipLength bytes followed by a big-endian port.
+func parseCompactPeers(data []byte, ipLength int) ([]netip.AddrPort, error) {
+	size := ipLength + 2
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("expected compact peers to be a multiple of %d bytes, but got %d", size, len(data))
+	}
+
+	peers := make([]netip.AddrPort, 0, len(data)/size)
+	for i := 0; i < len(data); i += size {
+		addr, _ := netip.AddrFromSlice(data[i : i+ipLength])
+		port := binary.BigEndian.Uint16(data[i+ipLength : i+size])
+		peers = append(peers, netip.AddrPortFrom(addr, port))
+	}
+	return peers, nil
+}
+
+// peerAddrs returns addrs as peer addresses without peer IDs.
+func peerAddrs(addrs []netip.AddrPort) []PeerAddr {
+	peers := make([]PeerAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		peers = append(peers, PeerAddr{AddrPort: addr})
+	}
+	return peers
+}
+
+// appendCompactPeer appends a peer in compact form: 6 bytes for an IPv4
+// address, or 18 for an IPv6 one.
+func appendCompactPeer(b []byte, peer netip.AddrPort) []byte {
+	b = append(b, peer.Addr().Unmap().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, peer.Port())
+}
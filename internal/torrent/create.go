@@ -0,0 +1,236 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// Piece lengths chosen by Build when BuildOptions.PieceLength is zero.
+const (
+	minAutoPieceLength = 16 << 10
+	maxAutoPieceLength = 16 << 20
+	targetPieces       = 1500
+)
+
+// BuildOptions configures the torrent that Build creates. All fields are
+// optional.
+type BuildOptions struct {
+	PieceLength  int        // Piece length in bytes, a power of two; zero picks one from the size of the data.
+	Trackers     [][]string // Tiers of tracker URLs; the first URL becomes the announce URL.
+	WebSeeds     []string   // Web seed URLs (BEP 19).
+	Comment      string     // Free-form comment.
+	Private      bool       // Restrict peers to those from the trackers (BEP 27).
+	Source       string     // Tag that gives the torrent a distinct info hash per site.
+	CreatedBy    string     // Name and version of the creating program.
+	CreationDate time.Time  // Creation time; zero leaves it out.
+	Workers      int        // Number of goroutines hashing pieces; zero uses one per CPU.
+}
+
+// Build hashes the file or directory at path and returns the encoded v1
+// metainfo of a torrent for it. The files of a directory are added in
+// lexical order, skipping anything that is not a regular file.
+func Build(path string, opts BuildOptions) ([]byte, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %v", err)
+	}
+
+	metadata, err := buildMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Length == 0 {
+		return nil, fmt.Errorf("no data to hash in %s", path)
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = autoPieceLength(metadata.Length)
+	}
+	if pieceLength <= 0 || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length %d is not a power of two", pieceLength)
+	}
+	metadata.PieceLength = pieceLength
+
+	root := path
+	if metadata.IsMultiFile() {
+		root = filepath.Dir(path)
+	}
+	storage, err := OpenFiles(metadata, root)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	pieces, err := hashPieces(storage, metadata.Length, pieceLength, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	info := infoDict{
+		Name:        metadata.Name,
+		PieceLength: pieceLength,
+		Pieces:      pieces,
+		Source:      opts.Source,
+	}
+	if opts.Private {
+		info.Private = 1
+	}
+	if metadata.IsMultiFile() {
+		for _, f := range metadata.Files {
+			info.Files = append(info.Files, fileDict{Length: f.Length, Path: f.Path[1:]})
+		}
+	} else {
+		info.Length = &metadata.Length
+	}
+
+	rawInfo, err := bencode.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode info dictionary: %v", err)
+	}
+
+	meta := metainfo{
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+		URLList:   opts.WebSeeds,
+		Info:      rawInfo,
+	}
+	if tiers := announceTiers(opts.Trackers); len(tiers) > 0 {
+		meta.Announce = tiers[0][0]
+		if len(tiers) > 1 || len(tiers[0]) > 1 {
+			meta.AnnounceList = tiers
+		}
+	}
+	if !opts.CreationDate.IsZero() {
+		meta.CreationDate = opts.CreationDate.Unix()
+	}
+
+	data, err := bencode.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode torrent: %v", err)
+	}
+	return data, nil
+}
+
+// buildMetadata lists the files of the torrent for path, without any
+// piece information.
+func buildMetadata(path string) (*Metadata, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+
+	name := filepath.Base(path)
+	if !stat.IsDir() {
+		return &Metadata{
+			Name:   name,
+			Length: stat.Size(),
+			Files:  []FileEntry{{Path: []string{name}, Length: stat.Size()}},
+		}, nil
+	}
+
+	metadata := &Metadata{Name: name}
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+
+		components := strings.Split(filepath.ToSlash(rel), "/")
+		for _, component := range components {
+			if err := checkPathComponent(component); err != nil {
+				return fmt.Errorf("cannot add %s: %v", file, err)
+			}
+		}
+
+		metadata.Files = append(metadata.Files, FileEntry{
+			Path:   append([]string{name}, components...),
+			Length: fileInfo.Size(),
+			Offset: metadata.Length,
+		})
+		metadata.Length += fileInfo.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in %s: %v", path, err)
+	}
+	if len(metadata.Files) == 0 {
+		return nil, fmt.Errorf("no files in %s", path)
+	}
+	return metadata, nil
+}
+
+// autoPieceLength picks the smallest power-of-two piece length that keeps
+// the number of pieces near targetPieces.
+func autoPieceLength(length int64) int {
+	pieceLength := minAutoPieceLength
+	for pieceLength < maxAutoPieceLength && length/int64(pieceLength) > targetPieces {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces returns the concatenated SHA-1 hashes of the pieces of the
+// data in storage, hashing pieces on several goroutines at once.
+func hashPieces(storage *FileStorage, length int64, pieceLength int, workers int) ([]byte, error) {
+	count := int((length + int64(pieceLength) - 1) / int64(pieceLength))
+	pieces := make([]byte, count*sha1.Size)
+	errs := make([]error, count)
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pieceLength)
+			for i := range indices {
+				offset := int64(i) * int64(pieceLength)
+				piece := buf[:min(int64(pieceLength), length-offset)]
+				if _, err := storage.ReadAt(piece, offset); err != nil {
+					errs[i] = fmt.Errorf("failed to read piece %d: %v", i, err)
+					continue
+				}
+				hash := sha1.Sum(piece)
+				copy(pieces[i*sha1.Size:], hash[:])
+			}
+		}()
+	}
+
+	for i := range count {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pieces, nil
+}
@@ -0,0 +1,183 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testData returns n bytes of deterministic, non-repeating data.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	return data
+}
+
+// pieceHashes returns the hex SHA-1 hashes of the pieces of data.
+func pieceHashes(data []byte, pieceLength int) []string {
+	var hashes []string
+	for i := 0; i < len(data); i += pieceLength {
+		hashes = append(hashes, fmt.Sprintf("%x", sha1.Sum(data[i:min(i+pieceLength, len(data))])))
+	}
+	return hashes
+}
+
+func TestBuildSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.bin")
+	data := testData(100000)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	encoded, err := Build(path, BuildOptions{
+		PieceLength:  32768,
+		Trackers:     [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80"}},
+		WebSeeds:     []string{"http://seed/artifact.bin"},
+		Comment:      "nightly",
+		Private:      true,
+		Source:       "builds",
+		CreatedBy:    "test",
+		CreationDate: created,
+	})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	info, err := Info(encoded)
+	if err != nil {
+		t.Fatalf("failed to parse built torrent: %v", err)
+	}
+
+	if info.Name != "artifact.bin" || info.Length != 100000 || info.IsMultiFile() {
+		t.Errorf("expected single file artifact.bin of 100000 bytes, got %s of %d bytes", info.Name, info.Length)
+	}
+	if want := pieceHashes(data, 32768); !reflect.DeepEqual(info.PieceHashes, want) {
+		t.Errorf("expected piece hashes %v, got %v", want, info.PieceHashes)
+	}
+	if info.Announce != "http://a/announce" {
+		t.Errorf("expected Announce http://a/announce, got %s", info.Announce)
+	}
+	if want := [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80"}}; !reflect.DeepEqual(info.AnnounceList, want) {
+		t.Errorf("expected AnnounceList %v, got %v", want, info.AnnounceList)
+	}
+	if !info.Private || info.Source != "builds" || info.Comment != "nightly" || info.CreatedBy != "test" {
+		t.Errorf("unexpected private %v, source %q, comment %q or created by %q", info.Private, info.Source, info.Comment, info.CreatedBy)
+	}
+	if !reflect.DeepEqual(info.URLList, []string{"http://seed/artifact.bin"}) {
+		t.Errorf("expected URLList [http://seed/artifact.bin], got %v", info.URLList)
+	}
+	if !info.CreationDate.Equal(created) {
+		t.Errorf("expected CreationDate %v, got %v", created, info.CreationDate)
+	}
+}
+
+func TestBuildDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "release")
+	files := map[string][]byte{
+		"a.txt":       testData(10),
+		"empty":       {},
+		"sub/b.bin":   testData(40000),
+		"sub/c/d.bin": testData(5000),
+	}
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+
+	encoded, err := Build(dir, BuildOptions{PieceLength: 16384, Workers: 3})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	info, err := Info(encoded)
+	if err != nil {
+		t.Fatalf("failed to parse built torrent: %v", err)
+	}
+
+	want := []FileEntry{
+		{Path: []string{"release", "a.txt"}, Length: 10, Offset: 0},
+		{Path: []string{"release", "empty"}, Length: 0, Offset: 10},
+		{Path: []string{"release", "sub", "b.bin"}, Length: 40000, Offset: 10},
+		{Path: []string{"release", "sub", "c", "d.bin"}, Length: 5000, Offset: 40010},
+	}
+	if !reflect.DeepEqual(info.Files, want) {
+		t.Errorf("expected files %+v, got %+v", want, info.Files)
+	}
+	if info.Announce != "" || info.AnnounceList != nil || info.Private {
+		t.Errorf("expected no trackers and a public torrent, got %+v", info)
+	}
+
+	var data []byte
+	for _, name := range []string{"a.txt", "empty", "sub/b.bin", "sub/c/d.bin"} {
+		data = append(data, files[name]...)
+	}
+	if want := pieceHashes(data, 16384); !reflect.DeepEqual(info.PieceHashes, want) {
+		t.Errorf("expected piece hashes %v, got %v", want, info.PieceHashes)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, testData(10), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	emptyDir := filepath.Join(dir, "empty-dir")
+	if err := os.Mkdir(emptyDir, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		opts BuildOptions
+	}{
+		{name: "missing path", path: filepath.Join(dir, "missing")},
+		{name: "empty file", path: empty},
+		{name: "empty directory", path: emptyDir},
+		{name: "piece length not a power of two", path: file, opts: BuildOptions{PieceLength: 20000}},
+		{name: "negative piece length", path: file, opts: BuildOptions{PieceLength: -16384}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Build(tt.path, tt.opts); err == nil {
+				t.Errorf("expected Build(%s) to fail", tt.path)
+			}
+		})
+	}
+}
+
+func TestAutoPieceLength(t *testing.T) {
+	tests := []struct {
+		length int64
+		want   int
+	}{
+		{length: 1, want: 16 << 10},
+		{length: 100 << 20, want: 128 << 10},
+		{length: 4 << 30, want: 4 << 20},
+		{length: 1 << 40, want: 16 << 20},
+	}
+
+	for _, tt := range tests {
+		if got := autoPieceLength(tt.length); got != tt.want {
+			t.Errorf("autoPieceLength(%d) = %d, want %d", tt.length, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sync"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+// MessageTypeExtended carries the messages of the extension protocol
+// (BEP 10). The first byte of its payload is the extended message ID.
+const MessageTypeExtended = peerwire.TypeExtended
+
+const (
+	// extensionByte and extensionBit mark support for the extension protocol
+	// in the reserved bytes of the handshake.
+	extensionByte = 5
+	extensionBit  = 0x10
+
+	// extendedHandshakeID is the extended message ID of the extension
+	// handshake.
+	extendedHandshakeID = 0
+)
+
+// ExtendedHandshake is the payload of the extension handshake. Every field
+// is optional.
+type ExtendedHandshake struct {
+	// M maps the names of the extensions the sender supports to the
+	// extended message IDs it wants to receive their messages with. An ID of
+	// zero disables an extension.
+	M            map[string]int `bencode:"m"`
+	V            string         `bencode:"v,omitempty"`             // Client name and version.
+	Reqq         int            `bencode:"reqq,omitempty"`          // Number of outstanding requests the sender allows.
+	YourIP       []byte         `bencode:"yourip,omitempty"`        // The receiver's IP address as the sender sees it, in 4 or 16 bytes.
+	P            int            `bencode:"p,omitempty"`             // The sender's listening port.
+	MetadataSize int            `bencode:"metadata_size,omitempty"` // Size of the info dictionary in bytes (BEP 9).
+}
+
+// YourAddr returns YourIP as an address, if it is a valid one.
+func (h *ExtendedHandshake) YourAddr() (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(h.YourIP)
+	return addr.Unmap(), ok
+}
+
+// ExtensionHandler handles the messages of one extension.
+type ExtensionHandler interface {
+	// HandleExtended is called with the payload of each message the peer
+	// sends to the extension.
+	HandleExtended(ext *Extensions, payload []byte) error
+}
+
+// ExtendedHandshakeHandler is implemented by extension handlers that want
+// to see the peer's extension handshake.
+type ExtendedHandshakeHandler interface {
+	HandleExtendedHandshake(ext *Extensions, handshake *ExtendedHandshake) error
+}
+
+// Extensions is the extension protocol state of one connection: a registry
+// of extension handlers by name, and the peer's extension handshake. Our
+// message ID for an extension is its position in the registry, plus one.
+//
+// Extensions are registered before the connection is used; after that, Send
+// may be called while another goroutine calls Handle.
+type Extensions struct {
+	// Local is sent as our extension handshake, with M filled in from the
+	// registered extensions.
+	Local ExtendedHandshake
+
+	conn     io.Writer
+	names    []string
+	handlers map[string]ExtensionHandler
+
+	mu   sync.Mutex
+	peer *ExtendedHandshake
+}
+
+// NewExtensions returns an empty registry for a connection that extended
+// messages are written to.
+func NewExtensions(conn io.Writer) *Extensions {
+	return &Extensions{conn: conn, handlers: make(map[string]ExtensionHandler)}
+}
+
+// Register adds the handler for the extension with the given name. It must
+// be called before SendHandshake.
+func (e *Extensions) Register(name string, handler ExtensionHandler) error {
+	if _, ok := e.handlers[name]; ok {
+		return fmt.Errorf("extension %q is already registered", name)
+	}
+	if len(e.names) == 255 {
+		return fmt.Errorf("too many extensions")
+	}
+	e.names = append(e.names, name)
+	e.handlers[name] = handler
+	return nil
+}
+
+// SendHandshake sends our extension handshake.
+func (e *Extensions) SendHandshake() error {
+	handshake := e.Local
+	handshake.M = make(map[string]int, len(e.names))
+	for i, name := range e.names {
+		handshake.M[name] = i + 1
+	}
+
+	payload, err := bencode.Marshal(handshake)
+	if err != nil {
+		return fmt.Errorf("failed to encode extension handshake: %w", err)
+	}
+	if err := writeExtended(e.conn, extendedHandshakeID, payload); err != nil {
+		return fmt.Errorf("failed to send extension handshake: %w", err)
+	}
+	return nil
+}
+
+// Peer returns the peer's extension handshake, or nil if it has not
+// arrived.
+func (e *Extensions) Peer() *ExtendedHandshake {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.peer
+}
+
+// PeerSupports reports whether the peer's extension handshake enables the
+// named extension.
+func (e *Extensions) PeerSupports(name string) bool {
+	_, ok := e.peerID(name)
+	return ok
+}
+
+func (e *Extensions) peerID(name string) (byte, bool) {
+	peer := e.Peer()
+	if peer == nil {
+		return 0, false
+	}
+	id := peer.M[name]
+	return byte(id), id > 0 && id <= 255
+}
+
+// Send writes a message for the named extension, using the ID the peer
+// asked for in its extension handshake.
+func (e *Extensions) Send(name string, payload []byte) error {
+	id, ok := e.peerID(name)
+	if !ok {
+		return fmt.Errorf("peer does not support %s", name)
+	}
+	return writeExtended(e.conn, id, payload)
+}
+
+// Handle dispatches the payload of an extended message: the extension
+// handshake is recorded and passed to the handlers that want it, and other
+// messages go to the handler registered under our ID for them. Messages for
+// unknown IDs are ignored.
+func (e *Extensions) Handle(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("extended message has no ID")
+	}
+	id, payload := int(payload[0]), payload[1:]
+
+	if id == extendedHandshakeID {
+		var handshake ExtendedHandshake
+		if err := bencode.Unmarshal(payload, &handshake); err != nil {
+			return fmt.Errorf("invalid extension handshake: %w", err)
+		}
+		e.mu.Lock()
+		e.peer = &handshake
+		e.mu.Unlock()
+
+		for _, name := range e.names {
+			if h, ok := e.handlers[name].(ExtendedHandshakeHandler); ok {
+				if err := h.HandleExtendedHandshake(e, &handshake); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	if id > len(e.names) {
+		return nil
+	}
+	name := e.names[id-1]
+	if err := e.handlers[name].HandleExtended(e, payload); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// writeExtended writes an extended message with the given ID.
+func writeExtended(conn io.Writer, id byte, payload []byte) error {
+	return peerwire.WriteMessages(conn, peerwire.Extended{ID: id, Payload: payload})
+}
@@ -0,0 +1,143 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// recordingHandler records the messages and handshakes it is given.
+type recordingHandler struct {
+	payloads   [][]byte
+	handshakes []*ExtendedHandshake
+	err        error
+}
+
+func (h *recordingHandler) HandleExtended(ext *Extensions, payload []byte) error {
+	h.payloads = append(h.payloads, payload)
+	return h.err
+}
+
+func (h *recordingHandler) HandleExtendedHandshake(ext *Extensions, handshake *ExtendedHandshake) error {
+	h.handshakes = append(h.handshakes, handshake)
+	return nil
+}
+
+// plainHandler does not implement ExtendedHandshakeHandler.
+type plainHandler struct{ calls int }
+
+func (h *plainHandler) HandleExtended(ext *Extensions, payload []byte) error {
+	h.calls++
+	return nil
+}
+
+func TestExtensionsSendHandshake(t *testing.T) {
+	var conn bytes.Buffer
+	ext := NewExtensions(&conn)
+	ext.Local = ExtendedHandshake{V: "mybittorrent", Reqq: 250, P: 6881, YourIP: []byte{10, 0, 0, 1}, MetadataSize: 1234}
+	if err := ext.Register("ut_metadata", &recordingHandler{}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := ext.Register("ut_pex", &plainHandler{}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := ext.Register("ut_pex", &plainHandler{}); err == nil {
+		t.Errorf("expected registering ut_pex twice to fail")
+	}
+
+	if err := ext.SendHandshake(); err != nil {
+		t.Fatalf("SendHandshake failed: %v", err)
+	}
+
+	msg, err := readMessage(&conn)
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	if msg.Type != MessageTypeExtended || msg.Payload[0] != extendedHandshakeID {
+		t.Fatalf("expected an extension handshake, got type %d", msg.Type)
+	}
+
+	var got ExtendedHandshake
+	if err := bencode.Unmarshal(msg.Payload[1:], &got); err != nil {
+		t.Fatalf("failed to decode extension handshake: %v", err)
+	}
+	want := ext.Local
+	want.M = map[string]int{"ut_metadata": 1, "ut_pex": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extension handshake = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtensionsHandle(t *testing.T) {
+	var conn bytes.Buffer
+	ext := NewExtensions(&conn)
+	metadata := &recordingHandler{}
+	pex := &plainHandler{}
+	ext.Register("ut_metadata", metadata)
+	ext.Register("ut_pex", pex)
+
+	if ext.Peer() != nil || ext.PeerSupports("ut_metadata") {
+		t.Errorf("expected no peer handshake before one arrives")
+	}
+	if err := ext.Send("ut_metadata", []byte("x")); err == nil {
+		t.Errorf("expected Send to fail before the peer's handshake")
+	}
+
+	handshake, _ := bencode.Marshal(ExtendedHandshake{
+		M:      map[string]int{"ut_metadata": 7, "ut_pex": 0, "lt_donthave": 3},
+		V:      "other 1.0",
+		Reqq:   100,
+		YourIP: netip.MustParseAddr("::ffff:192.0.2.1").AsSlice(),
+	})
+	if err := ext.Handle(append([]byte{extendedHandshakeID}, handshake...)); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+
+	peer := ext.Peer()
+	if peer == nil || peer.V != "other 1.0" || peer.Reqq != 100 {
+		t.Fatalf("unexpected peer handshake %+v", peer)
+	}
+	if addr, ok := peer.YourAddr(); !ok || addr != netip.MustParseAddr("192.0.2.1") {
+		t.Errorf("YourAddr() = %v, %v, want 192.0.2.1, true", addr, ok)
+	}
+	if len(metadata.handshakes) != 1 || metadata.handshakes[0] != peer {
+		t.Errorf("expected the handshake to be passed to ut_metadata")
+	}
+	if !ext.PeerSupports("ut_metadata") || ext.PeerSupports("ut_pex") || ext.PeerSupports("unknown") {
+		t.Errorf("unexpected PeerSupports results for %v", peer.M)
+	}
+
+	// Messages are dispatched by our IDs: 1 for ut_metadata, 2 for ut_pex.
+	for _, payload := range [][]byte{{1, 'a'}, {2, 'b'}, {9, 'c'}} {
+		if err := ext.Handle(payload); err != nil {
+			t.Fatalf("Handle(%v) failed: %v", payload, err)
+		}
+	}
+	if len(metadata.payloads) != 1 || string(metadata.payloads[0]) != "a" || pex.calls != 1 {
+		t.Errorf("unexpected dispatch: ut_metadata got %q, ut_pex %d calls", metadata.payloads, pex.calls)
+	}
+
+	metadata.err = errors.New("boom")
+	if err := ext.Handle([]byte{1}); err == nil || !errors.Is(err, metadata.err) {
+		t.Errorf("Handle() error = %v, want wrapped handler error", err)
+	}
+	if err := ext.Handle(nil); err == nil {
+		t.Errorf("expected error for an extended message without an ID")
+	}
+
+	// Send uses the ID the peer asked for.
+	conn.Reset()
+	if err := ext.Send("ut_metadata", []byte("hi")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if want := []byte{0, 0, 0, 4, byte(MessageTypeExtended), 7, 'h', 'i'}; !bytes.Equal(conn.Bytes(), want) {
+		t.Errorf("Send wrote %v, want %v", conn.Bytes(), want)
+	}
+	if err := ext.Send("ut_pex", nil); err == nil {
+		t.Errorf("expected Send to fail for an extension the peer disabled")
+	}
+}
@@ -0,0 +1,134 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+// Fast Extension messages (BEP 6).
+const (
+	MessageTypeSuggestPiece  = peerwire.TypeSuggestPiece
+	MessageTypeHaveAll       = peerwire.TypeHaveAll
+	MessageTypeHaveNone      = peerwire.TypeHaveNone
+	MessageTypeRejectRequest = peerwire.TypeRejectRequest
+	MessageTypeAllowedFast   = peerwire.TypeAllowedFast
+)
+
+const (
+	// fastByte and fastBit mark support for the Fast Extension in the
+	// reserved bytes of the handshake.
+	fastByte = 7
+	fastBit  = 0x04
+
+	// AllowedFastCount is the usual size of an allowed fast set.
+	AllowedFastCount = 10
+)
+
+// RequestRejectedError is returned when a peer rejects a request for a
+// block.
+type RequestRejectedError struct {
+	Index, Begin, Length uint32
+}
+
+func (e *RequestRejectedError) Error() string {
+	return fmt.Sprintf("peer rejected request for %d bytes at offset %d of piece %d", e.Length, e.Begin, e.Index)
+}
+
+// SupportsFast reports whether the peer supports the Fast Extension (BEP 6).
+func (h *PeerHandshake) SupportsFast() bool {
+	return h.Reserved[fastByte]&fastBit != 0
+}
+
+// SendHaveAll tells the peer that we have every piece, in place of a
+// bitfield.
+func SendHaveAll(conn io.Writer) error {
+	return peerwire.WriteMessages(conn, peerwire.HaveAll{})
+}
+
+// SendHaveNone tells the peer that we have no pieces, in place of a
+// bitfield.
+func SendHaveNone(conn io.Writer) error {
+	return peerwire.WriteMessages(conn, peerwire.HaveNone{})
+}
+
+// SendSuggestPiece suggests that the peer download a piece.
+func SendSuggestPiece(conn io.Writer, index uint32) error {
+	return peerwire.WriteMessages(conn, peerwire.SuggestPiece{Index: index})
+}
+
+// SendAllowedFast tells the peer that it may request a piece even while
+// choked.
+func SendAllowedFast(conn io.Writer, index uint32) error {
+	return peerwire.WriteMessages(conn, peerwire.AllowedFast{Index: index})
+}
+
+// SendRejectRequest tells the peer that a request of its will not be
+// answered.
+func SendRejectRequest(conn io.Writer, index, begin, length uint32) error {
+	return peerwire.WriteMessages(conn, peerwire.RejectRequest{Index: index, Begin: begin, Length: length})
+}
+
+// parseIndex parses the payload of a have, suggest piece or allowed fast
+// message.
+func parseIndex(msg *Message) (uint32, error) {
+	decoded, err := peerwire.Decode(msg.Type, msg.Payload)
+	if err != nil {
+		return 0, err
+	}
+	switch m := decoded.(type) {
+	case peerwire.Have:
+		return m.Index, nil
+	case peerwire.SuggestPiece:
+		return m.Index, nil
+	case peerwire.AllowedFast:
+		return m.Index, nil
+	}
+	return 0, fmt.Errorf("message type %d has no piece index", msg.Type)
+}
+
+// parseRejectRequest parses the payload of a reject request message.
+func parseRejectRequest(msg *Message) (*RequestRejectedError, error) {
+	decoded, err := peerwire.Decode(msg.Type, msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := decoded.(peerwire.RejectRequest)
+	if !ok {
+		return nil, fmt.Errorf("expected a reject request, but got message type %d", msg.Type)
+	}
+	return &RequestRejectedError{Index: m.Index, Begin: m.Begin, Length: m.Length}, nil
+}
+
+// AllowedFastSet returns the k pieces that a peer at addr may request while
+// choked, computed as BEP 6 describes from the peer's IPv4 address and the
+// info hash. IPv6 peers, and torrents with fewer than k pieces, get no set.
+func AllowedFastSet(addr netip.Addr, infoHash [20]byte, numPieces, k int) []uint32 {
+	addr = addr.Unmap()
+	if !addr.Is4() || numPieces < k {
+		return nil
+	}
+
+	ip := addr.As4()
+	x := make([]byte, 0, 24)
+	x = append(x, ip[0], ip[1], ip[2], 0)
+	x = append(x, infoHash[:]...)
+
+	set := make([]uint32, 0, k)
+	for len(set) < k {
+		hash := sha1.Sum(x)
+		x = hash[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			index := binary.BigEndian.Uint32(x[i*4:]) % uint32(numPieces)
+			if !slices.Contains(set, index) {
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
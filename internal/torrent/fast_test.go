@@ -0,0 +1,221 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/testutil"
+)
+
+func TestAllowedFastSet(t *testing.T) {
+	var infoHash [20]byte
+	for i := range infoHash {
+		infoHash[i] = 0xaa
+	}
+	addr := netip.MustParseAddr("80.4.4.200")
+
+	// The example from BEP 6.
+	tests := []struct {
+		k    int
+		want []uint32
+	}{
+		{k: 7, want: []uint32{1059, 431, 808, 1217, 287, 376, 1188}},
+		{k: 9, want: []uint32{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}},
+	}
+	for _, tt := range tests {
+		if got := AllowedFastSet(addr, infoHash, 1313, tt.k); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AllowedFastSet(k=%d) = %v, want %v", tt.k, got, tt.want)
+		}
+	}
+
+	// Only the first three bytes of the address count.
+	if got, want := AllowedFastSet(netip.MustParseAddr("::ffff:80.4.4.1"), infoHash, 1313, 7), tests[0].want; !reflect.DeepEqual(got, want) {
+		t.Errorf("AllowedFastSet() for an address in the same /24 = %v, want %v", got, want)
+	}
+	if got := AllowedFastSet(netip.MustParseAddr("2001:db8::1"), infoHash, 1313, 7); got != nil {
+		t.Errorf("AllowedFastSet() for an IPv6 address = %v, want nil", got)
+	}
+	if got := AllowedFastSet(addr, infoHash, 5, 7); got != nil {
+		t.Errorf("AllowedFastSet() with fewer pieces than k = %v, want nil", got)
+	}
+}
+
+func TestFastMessages(t *testing.T) {
+	var conn bytes.Buffer
+	SendHaveAll(&conn)
+	SendHaveNone(&conn)
+	SendSuggestPiece(&conn, 7)
+	SendAllowedFast(&conn, 8)
+	SendRejectRequest(&conn, 1, 16384, 100)
+
+	want := []byte{
+		0, 0, 0, 1, 0x0E,
+		0, 0, 0, 1, 0x0F,
+		0, 0, 0, 5, 0x0D, 0, 0, 0, 7,
+		0, 0, 0, 5, 0x11, 0, 0, 0, 8,
+		0, 0, 0, 13, 0x10, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0, 100,
+	}
+	if !bytes.Equal(conn.Bytes(), want) {
+		t.Fatalf("written messages = %v, want %v", conn.Bytes(), want)
+	}
+
+	for range 2 {
+		readMessage(&conn)
+	}
+	for _, wantIndex := range []uint32{7, 8} {
+		msg, _ := readMessage(&conn)
+		if index, err := parseIndex(msg); err != nil || index != wantIndex {
+			t.Errorf("parseIndex() = %d, %v, want %d", index, err, wantIndex)
+		}
+	}
+	msg, _ := readMessage(&conn)
+	rejected, err := parseRejectRequest(msg)
+	if err != nil || *rejected != (RequestRejectedError{Index: 1, Begin: 16384, Length: 100}) {
+		t.Errorf("parseRejectRequest() = %+v, %v", rejected, err)
+	}
+
+	if _, err := parseIndex(&Message{Type: MessageTypeAllowedFast, Payload: []byte{1}}); err == nil {
+		t.Errorf("expected error for a short allowed fast payload")
+	}
+}
+
+func TestHandshakePeerFast(t *testing.T) {
+	metadata := &Metadata{InfoHash: [20]byte{1}}
+	mockConn := testutil.NewMockTCPConn()
+	response := make([]byte, HandshakeLength)
+	response[0] = byte(len(ProtocolString))
+	copy(response[1:20], ProtocolString)
+	response[20+7] = 0x04
+	copy(response[28:48], metadata.InfoHash[:])
+	mockConn.SetReadData(response)
+
+	peer, err := HandshakePeer(mockConn, metadata)
+	if err != nil {
+		t.Fatalf("HandshakePeer failed: %v", err)
+	}
+	if !peer.SupportsFast() || peer.SupportsExtensions() {
+		t.Errorf("expected only the fast bit, got reserved bytes %x", peer.Reserved)
+	}
+	if written := mockConn.GetWrittenData(); written[20+7]&0x04 == 0 {
+		t.Errorf("expected the handshake to advertise the Fast Extension, got reserved bytes %x", written[20:28])
+	}
+}
+
+func TestDownloadPieceFast(t *testing.T) {
+	metadata := &Metadata{
+		PieceLength: 16384,
+		Length:      16384 + 1,
+		PieceHashes: []string{"", pieceHash([]byte{0x2a})},
+	}
+	piece := []byte{
+		0, 0, 0, 10, 0x07, // piece
+		0, 0, 0, 1, // piece index (1)
+		0, 0, 0, 0, // block offset
+		0x2a, // data
+	}
+
+	tests := []struct {
+		name        string
+		withoutFast bool // The handshake did not negotiate the Fast Extension.
+		messages    [][]byte
+		wantErr     error
+		wantData    []byte
+	}{
+		{
+			name: "have all then unchoke",
+			messages: [][]byte{
+				{0, 0, 0, 1, 0x0E},             // have all
+				{0, 0, 0, 5, 0x0D, 0, 0, 0, 0}, // suggest piece 0
+				{0, 0, 0, 1, 0x01},             // unchoke
+				piece,
+			},
+			wantData: []byte{0x2a},
+		},
+		{
+			name: "allowed fast while choked",
+			messages: [][]byte{
+				{0, 0, 0, 1, 0x0E},             // have all
+				{0, 0, 0, 5, 0x11, 0, 0, 0, 0}, // allowed fast 0
+				{0, 0, 0, 5, 0x11, 0, 0, 0, 1}, // allowed fast 1
+				{0, 0, 0, 1, 0x00},             // choke
+				piece,
+			},
+			wantData: []byte{0x2a},
+		},
+		{
+			name: "have none then have",
+			messages: [][]byte{
+				{0, 0, 0, 1, 0x0F},             // have none
+				{0, 0, 0, 1, 0x01},             // unchoke
+				{0, 0, 0, 5, 0x04, 0, 0, 0, 1}, // have 1
+				piece,
+			},
+			wantData: []byte{0x2a},
+		},
+		{
+			name: "have none then allowed fast and have",
+			messages: [][]byte{
+				{0, 0, 0, 1, 0x0F},             // have none
+				{0, 0, 0, 5, 0x11, 0, 0, 0, 1}, // allowed fast 1
+				{0, 0, 0, 5, 0x04, 0, 0, 0, 1}, // have 1
+				piece,
+			},
+			wantData: []byte{0x2a},
+		},
+		{
+			name: "have none",
+			messages: [][]byte{
+				{0, 0, 0, 1, 0x0F}, // have none
+				{0, 0, 0, 1, 0x01}, // unchoke
+			},
+			wantErr: errors.New("failed to read message: failed to read message length: EOF"),
+		},
+		{
+			name:        "not negotiated",
+			withoutFast: true,
+			messages: [][]byte{
+				{0, 0, 0, 1, 0x0E}, // have all
+			},
+			wantErr: errors.New("peer sent Fast Extension message type 14 without negotiating it"),
+		},
+		{
+			name: "rejected request",
+			messages: [][]byte{
+				{0, 0, 0, 1, 0x0E}, // have all
+				{0, 0, 0, 1, 0x01}, // unchoke
+				{0, 0, 0, 13, 0x10, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1}, // reject
+			},
+			wantErr: &RequestRejectedError{Index: 1, Begin: 0, Length: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockConn := testutil.NewMockTCPConn()
+			mockConn.SetReadData(bytes.Join(tt.messages, nil))
+			var output bytes.Buffer
+
+			err := downloadPiece(mockConn, &output, metadata, 1, !tt.withoutFast, nil, 0)
+			if tt.wantErr != nil {
+				var rejected *RequestRejectedError
+				if want, ok := tt.wantErr.(*RequestRejectedError); ok {
+					if !errors.As(err, &rejected) || *rejected != *want {
+						t.Errorf("DownloadPiece() error = %v, want %v", err, want)
+					}
+				} else if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("DownloadPiece() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DownloadPiece() error = %v", err)
+			}
+			if !bytes.Equal(output.Bytes(), tt.wantData) {
+				t.Errorf("DownloadPiece() wrote %v, want %v", output.Bytes(), tt.wantData)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package torrent
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
+	"io"
 )
 
 // TCPConn represents a TCP connection interface for dependency injection.
@@ -22,50 +24,78 @@ const (
 	HandshakeLength = 68 // 1 + 19 + 8 + 20 + 20 bytes
 )
 
+// PeerHandshake is what a peer sent in its handshake.
+type PeerHandshake struct {
+	Reserved [8]byte  // Bits marking the protocol extensions the peer supports.
+	PeerID   [20]byte // The peer's ID.
+}
+
+// SupportsExtensions reports whether the peer supports the extension
+// protocol (BEP 10).
+func (h *PeerHandshake) SupportsExtensions() bool {
+	return h.Reserved[extensionByte]&extensionBit != 0
+}
+
 // Handshake performs the BitTorrent handshake protocol with a peer.
 // It writes the handshake message and reads the peer's response.
 // Returns the peer ID in hexadecimal format and any error encountered.
 func Handshake(tcpConn TCPConn, metadata *Metadata) (string, error) {
+	peer, err := HandshakePeer(tcpConn, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	// Return the peer ID in hexadecimal format
+	return hex.EncodeToString(peer.PeerID[:]), nil
+}
+
+// HandshakePeer is like Handshake, but returns everything the peer sent.
+// The handshake advertises support for the extension protocol and the Fast
+// Extension, so peers that support them too may follow up with an extension
+// handshake and fast messages.
+func HandshakePeer(conn io.ReadWriter, metadata *Metadata) (*PeerHandshake, error) {
+	var reserved [8]byte
+	reserved[extensionByte] |= extensionBit
+	reserved[fastByte] |= fastBit
+
 	// Create the handshake message
 	handshake := make([]byte, HandshakeLength)
 	handshake[0] = byte(len(ProtocolString))      // Protocol string length
 	copy(handshake[1:20], []byte(ProtocolString)) // Protocol string
-	// Reserved bytes (8 zeros) are already zeroed
-	copy(handshake[28:48], metadata.InfoHash[:]) // Info hash
+	copy(handshake[20:28], reserved[:])           // Reserved bytes
+	copy(handshake[28:48], metadata.InfoHash[:])  // Info hash
 	// Generate a random peer ID (20 bytes)
-	peerID := make([]byte, 20)
-	for i := range peerID {
-		peerID[i] = byte(i + 1) // Simple deterministic peer ID for testing
+	for i := range 20 {
+		handshake[48+i] = byte(i + 1) // Simple deterministic peer ID for testing
 	}
-	copy(handshake[48:], peerID)
 
 	// Write the handshake message
-	if _, err := tcpConn.Write(handshake); err != nil {
-		return "", err
+	if _, err := conn.Write(handshake); err != nil {
+		return nil, err
 	}
 
 	// Read the peer's response
 	response := make([]byte, HandshakeLength)
-	if _, err := tcpConn.Read(response); err != nil {
-		return "", err
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
 	}
 
 	// Validate the response
 	if response[0] != byte(len(ProtocolString)) {
-		return "", fmt.Errorf("invalid protocol string length: expected %d, got %d", len(ProtocolString), response[0])
+		return nil, fmt.Errorf("invalid protocol string length: expected %d, got %d", len(ProtocolString), response[0])
 	}
 
 	if string(response[1:20]) != ProtocolString {
-		return "", fmt.Errorf("invalid protocol string: expected %q, got %q", ProtocolString, string(response[1:20]))
+		return nil, fmt.Errorf("invalid protocol string: expected %q, got %q", ProtocolString, string(response[1:20]))
 	}
 
 	// Verify the info hash matches
-	for i := 0; i < 20; i++ {
-		if response[28+i] != metadata.InfoHash[i] {
-			return "", fmt.Errorf("info hash mismatch")
-		}
+	if !bytes.Equal(response[28:48], metadata.InfoHash[:]) {
+		return nil, fmt.Errorf("info hash mismatch")
 	}
 
-	// Return the peer ID in hexadecimal format
-	return hex.EncodeToString(response[48:]), nil
+	peer := &PeerHandshake{}
+	copy(peer.Reserved[:], response[20:28])
+	copy(peer.PeerID[:], response[48:])
+	return peer, nil
 }
@@ -68,3 +68,34 @@ func TestHandshake(t *testing.T) {
 		}
 	}
 }
+
+func TestHandshakePeerExtensions(t *testing.T) {
+	metadata := &Metadata{InfoHash: [20]byte{1, 2, 3}}
+
+	for _, extensions := range []bool{true, false} {
+		mockConn := testutil.NewMockTCPConn()
+		response := make([]byte, HandshakeLength)
+		response[0] = byte(len(ProtocolString))
+		copy(response[1:20], ProtocolString)
+		if extensions {
+			response[20+5] = 0x10
+		}
+		copy(response[28:48], metadata.InfoHash[:])
+		copy(response[48:], "-XX0001-abcdefghijkl")
+		mockConn.SetReadData(response)
+
+		peer, err := HandshakePeer(mockConn, metadata)
+		if err != nil {
+			t.Fatalf("HandshakePeer failed: %v", err)
+		}
+		if written := mockConn.GetWrittenData(); written[20+5]&0x10 == 0 {
+			t.Errorf("expected the handshake to advertise the extension protocol, got reserved bytes %x", written[20:28])
+		}
+		if peer.SupportsExtensions() != extensions {
+			t.Errorf("SupportsExtensions() = %v, want %v", peer.SupportsExtensions(), extensions)
+		}
+		if string(peer.PeerID[:]) != "-XX0001-abcdefghijkl" {
+			t.Errorf("expected peer ID -XX0001-abcdefghijkl, got %q", peer.PeerID[:])
+		}
+	}
+}
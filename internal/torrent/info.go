@@ -2,74 +2,284 @@ package torrent
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
 )
 
 // Metadata represents the metadata extracted from a torrent file.
 type Metadata struct {
-	Name        string   // The name of the file or folder.
-	Length      int      // The length of the file in bytes (for single files).
-	PieceLength int      // The length of each piece in bytes.
-	PieceHashes []string // The hash of each piece, typically in 20-byte SHA-1 hash strings.
-	Announce    string   // The URL of the tracker for the torrent.
-	InfoHash    [20]byte // hash of the info
+	Name        string      // The name of the file or folder.
+	Length      int64       // The total length of all files in bytes.
+	Files       []FileEntry // The files, in the order their data appears in the torrent.
+	PieceLength int         // The length of each piece in bytes.
+	PieceHashes []string    // The hash of each piece, typically in 20-byte SHA-1 hash strings.
+	Announce    string      // The URL of the tracker for the torrent, empty for trackerless torrents.
+	InfoHash    [20]byte    // SHA-1 hash of the info, or the truncated v2 hash for v2-only torrents.
+	MetaVersion int         // 2 for v2 and hybrid torrents (BEP 52), 1 otherwise.
+	InfoHashV2  [32]byte    // SHA-256 hash of the info, zero for v1-only torrents.
+
+	// Optional fields, left empty when the torrent does not set them.
+	AnnounceList [][]string // Tiers of tracker URLs (BEP 12).
+	Private      bool       // Whether peers may only come from the trackers (BEP 27).
+	URLList      []string   // Web seed URLs (BEP 19).
+	HTTPSeeds    []string   // Hoffman-style HTTP seed URLs (BEP 17).
+	Comment      string     // Free-form comment from the author.
+	CreatedBy    string     // Name and version of the program that created the torrent.
+	CreationDate time.Time  // When the torrent was created.
+	Encoding     string     // Character encoding of the strings in the info dictionary.
+	Source       string     // Tag that gives the torrent a distinct info hash per site.
+}
+
+// FileEntry describes one file of a torrent.
+type FileEntry struct {
+	// Path is the file's location relative to the download directory. For a
+	// single-file torrent it is just the torrent's name; for a multi-file
+	// torrent it starts with the name of the torrent's folder.
+	Path   []string
+	Length int64 // The length of the file in bytes.
+	Offset int64 // The position of the file's first byte within the torrent's data.
+
+	Padding    bool     // Whether the file only aligns the next file to a piece boundary (BEP 47).
+	PiecesRoot [32]byte // Root of the file's SHA-256 merkle tree, zero for v1-only and empty files.
+	PieceLayer []string // Hex SHA-256 hashes of the file's pieces, when the torrent has them.
+}
+
+// IsMultiFile reports whether the torrent describes a folder of files rather
+// than a single file.
+func (m *Metadata) IsMultiFile() bool {
+	return len(m.Files) != 1 || len(m.Files[0].Path) != 1
+}
+
+// metainfo is the top-level dictionary of a torrent file.
+type metainfo struct {
+	Announce     string             `bencode:"announce,omitempty"`
+	AnnounceList [][]string         `bencode:"announce-list,omitempty"`
+	Comment      string             `bencode:"comment,omitempty"`
+	CreatedBy    string             `bencode:"created by,omitempty"`
+	CreationDate int64              `bencode:"creation date,omitempty"`
+	Encoding     string             `bencode:"encoding,omitempty"`
+	HTTPSeeds    stringList         `bencode:"httpseeds,omitempty"`
+	URLList      stringList         `bencode:"url-list,omitempty"`
+	PieceLayers  map[string][]byte  `bencode:"piece layers,omitempty"`
+	Info         bencode.RawMessage `bencode:"info"`
+}
+
+// stringList is a list of strings that may also be given as a single string,
+// as url-list often is.
+type stringList []string
+
+func (l *stringList) UnmarshalBencode(data []byte) error {
+	var s string
+	if err := bencode.Unmarshal(data, &s); err == nil {
+		if s != "" {
+			*l = stringList{s}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := bencode.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// infoDict is the info dictionary of a torrent file. Single-file v1
+// torrents set Length, and multi-file ones set Files instead. v2 torrents set
+// MetaVersion and FileTree, and hybrid torrents set both.
+type infoDict struct {
+	Name        string             `bencode:"name"`
+	Length      *int64             `bencode:"length,omitempty"`
+	Files       []fileDict         `bencode:"files,omitempty"`
+	PieceLength int                `bencode:"piece length"`
+	Pieces      []byte             `bencode:"pieces"`
+	Private     int64              `bencode:"private,omitempty"`
+	Source      string             `bencode:"source,omitempty"`
+	MetaVersion int64              `bencode:"meta version,omitempty"`
+	FileTree    bencode.RawMessage `bencode:"file tree,omitempty"`
+}
+
+// fileDict is an entry in the files list of a multi-file torrent.
+type fileDict struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+	Attr   string   `bencode:"attr,omitempty"`
 }
 
 // Info parses the given list of bytes and returns a Metadata object.
+//
+// The info hash is computed over the info dictionary exactly as it appears in
+// data, so torrents that are not in canonical form still hash correctly.
 func Info(data []byte) (*Metadata, error) {
-	// Implementation of parsing logic should be added here.
-	// This is a placeholder example, and it's assumed that the `data` contains
-	// properly formatted torrent metadata information.
-
-	decoded, _, err := bencode.Decode(data)
-	if err != nil {
+	var root metainfo
+	if err := bencode.Unmarshal(data, &root); err != nil {
 		return nil, fmt.Errorf("failed to parse torrent file: %v", err)
 	}
 
-	root, ok := decoded.(map[string]any)
-	if !ok {
-		return nil, fmt.Errorf("expected decoded value to be a dictionary, but got %T", decoded)
+	if len(root.Info) == 0 {
+		return nil, fmt.Errorf("missing info dictionary")
 	}
 
-	info, ok := root["info"].(map[string]any)
-	if !ok {
-		return nil, fmt.Errorf("expected info field to be a dictionary, but got %T", root["info"])
+	var info infoDict
+	if err := bencode.Unmarshal(root.Info, &info); err != nil {
+		return nil, fmt.Errorf("invalid info dictionary: %v", err)
 	}
 
-	// Get piece length and pieces
-	pieceLength, ok := info["piece length"].(int)
-	if !ok {
-		return nil, fmt.Errorf("expected piece length to be an integer, but got %T", info["piece length"])
+	if len(info.Pieces)%20 != 0 {
+		return nil, fmt.Errorf("expected pieces length to be a multiple of 20, but got %d", len(info.Pieces))
 	}
 
-	pieces, ok := info["pieces"].([]byte)
-	if !ok {
-		return nil, fmt.Errorf("expected pieces to be a byte string, but got %T", info["pieces"])
+	metadata := &Metadata{
+		Name:         info.Name,
+		PieceLength:  info.PieceLength,
+		Announce:     root.Announce,
+		MetaVersion:  1,
+		AnnounceList: announceTiers(root.AnnounceList),
+		Private:      info.Private == 1,
+		URLList:      root.URLList,
+		HTTPSeeds:    root.HTTPSeeds,
+		Comment:      root.Comment,
+		CreatedBy:    root.CreatedBy,
+		Encoding:     root.Encoding,
+		Source:       info.Source,
+	}
+	if root.CreationDate != 0 {
+		metadata.CreationDate = time.Unix(root.CreationDate, 0).UTC()
 	}
 
-	// Split pieces into 20-byte hashes
-	pieceHashes := make([]string, 0, len(pieces)/20)
-	for i := 0; i < len(pieces); i += 20 {
-		pieceHashes = append(pieceHashes, fmt.Sprintf("%x", pieces[i:i+20]))
+	hasV2 := info.FileTree != nil
+	hasV1 := !hasV2 || info.Pieces != nil
+	switch {
+	case info.MetaVersion > 2:
+		return nil, fmt.Errorf("unsupported meta version %d", info.MetaVersion)
+	case hasV2 && info.MetaVersion != 2:
+		return nil, fmt.Errorf("file tree requires meta version 2, but got %d", info.MetaVersion)
+	case !hasV2 && info.MetaVersion == 2:
+		return nil, fmt.Errorf("missing file tree for meta version 2")
 	}
 
-	encodedInfo, err := bencode.Encode(info)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode info: %v", err)
-	}
-	hash := sha1.Sum(encodedInfo)
-
-	return &Metadata{
-		Name:        string(info["name"].([]byte)),
-		Length:      info["length"].(int),
-		PieceLength: pieceLength,
-		PieceHashes: pieceHashes,
-		Announce:    string(root["announce"].([]byte)),
-		InfoHash:    hash,
-	}, nil
+	if hasV1 {
+		if info.PieceLength <= 0 {
+			return nil, fmt.Errorf("invalid piece length %d", info.PieceLength)
+		}
+		files, length, err := info.fileEntries()
+		if err != nil {
+			return nil, err
+		}
+		pieceLength := int64(info.PieceLength)
+		if want := (length + pieceLength - 1) / pieceLength; int64(len(info.Pieces)/20) != want {
+			return nil, fmt.Errorf("expected %d piece hashes for %d bytes, but got %d", want, length, len(info.Pieces)/20)
+		}
+		metadata.Files, metadata.Length = files, length
+		metadata.InfoHash = sha1.Sum(root.Info)
+
+		// Split pieces into 20-byte hashes
+		metadata.PieceHashes = make([]string, 0, len(info.Pieces)/20)
+		for i := 0; i < len(info.Pieces); i += 20 {
+			metadata.PieceHashes = append(metadata.PieceHashes, fmt.Sprintf("%x", info.Pieces[i:i+20]))
+		}
+	}
+
+	if hasV2 {
+		files, length, err := info.v2FileEntries(root.PieceLayers)
+		if err != nil {
+			return nil, err
+		}
+		metadata.MetaVersion = 2
+		metadata.InfoHashV2 = sha256.Sum256(root.Info)
+
+		if hasV1 {
+			if err := checkHybrid(metadata.Files, files); err != nil {
+				return nil, err
+			}
+		} else {
+			metadata.Files, metadata.Length = files, length
+			metadata.InfoHash = metadata.TruncatedInfoHashV2()
+		}
+	}
+	return metadata, nil
+}
+
+// announceTiers drops empty URLs and tiers from an announce-list.
+func announceTiers(list [][]string) [][]string {
+	var tiers [][]string
+	for _, tier := range list {
+		var urls []string
+		for _, url := range tier {
+			if url != "" {
+				urls = append(urls, url)
+			}
+		}
+		if len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
+	}
+	return tiers
+}
+
+// fileEntries lays out the files of the torrent one after another and
+// returns them with their total length.
+func (info *infoDict) fileEntries() ([]FileEntry, int64, error) {
+	if err := checkPathComponent(info.Name); err != nil {
+		return nil, 0, fmt.Errorf("invalid name: %v", err)
+	}
+
+	if info.Files == nil {
+		if info.Length == nil {
+			return nil, 0, fmt.Errorf("missing length")
+		}
+		length := *info.Length
+		if length < 0 {
+			return nil, 0, fmt.Errorf("invalid length %d", length)
+		}
+		return []FileEntry{{Path: []string{info.Name}, Length: length}}, length, nil
+	}
+
+	files := make([]FileEntry, 0, len(info.Files))
+	var offset int64
+	for i, f := range info.Files {
+		if f.Length < 0 {
+			return nil, 0, fmt.Errorf("invalid length %d for file %d", f.Length, i)
+		}
+		if len(f.Path) == 0 {
+			return nil, 0, fmt.Errorf("empty path for file %d", i)
+		}
+		for _, component := range f.Path {
+			if err := checkPathComponent(component); err != nil {
+				return nil, 0, fmt.Errorf("invalid path for file %d: %v", i, err)
+			}
+		}
+
+		files = append(files, FileEntry{
+			Path:    append([]string{info.Name}, f.Path...),
+			Length:  f.Length,
+			Offset:  offset,
+			Padding: strings.Contains(f.Attr, "p"),
+		})
+		offset += f.Length
+	}
+	return files, offset, nil
+}
+
+// checkPathComponent rejects path components that could escape the download
+// directory when joined into a path.
+func checkPathComponent(component string) error {
+	switch {
+	case component == "", component == ".", component == "..":
+		return fmt.Errorf("unsafe path component %q", component)
+	case strings.ContainsAny(component, "/\\\x00"):
+		return fmt.Errorf("path component %q contains a separator", component)
+	case filepath.IsAbs(component) || filepath.VolumeName(component) != "":
+		return fmt.Errorf("absolute path component %q", component)
+	}
+	return nil
 }
 
 // ReadFromFile reads and parses a torrent file, returning its metadata.
@@ -1,9 +1,15 @@
 package torrent
 
 import (
+	"crypto/sha1"
 	"encoding/hex"
 	"os"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/testutil"
 )
 
 func TestInfo(t *testing.T) {
@@ -48,3 +54,188 @@ func TestInfo(t *testing.T) {
 		t.Errorf("expected f00d937a0213df1982bc8d097227ad9e909acc17, got %s", info.PieceHashes[2])
 	}
 }
+
+func TestInfoHashUsesOriginalBytes(t *testing.T) {
+	// The info dictionary has unsorted keys and an unknown value type, neither
+	// of which survives decoding and re-encoding.
+	rawInfo := "d6:pieces20:aaaaaaaaaaaaaaaaaaaa4:name1:x12:piece lengthi1e6:lengthi1e5:extrali1eee"
+	data := []byte("d8:announce3:url4:info" + rawInfo + "e")
+
+	info, err := Info(data)
+	if err != nil {
+		t.Fatalf("failed to parse torrent file: %v", err)
+	}
+
+	want := sha1.Sum([]byte(rawInfo))
+	if info.InfoHash != want {
+		t.Errorf("expected info hash %x, got %x", want, info.InfoHash)
+	}
+}
+
+func TestInfoInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing info":      "d8:announce3:urle",
+		"info not a dict":   "d4:infoli1eee",
+		"bad piece length":  "d4:infod12:piece length1:xee",
+		"truncated pieces":  "d4:infod6:pieces3:abcee",
+		"zero piece length": "d4:infod6:lengthi10e4:name1:a12:piece lengthi0e6:pieces20:hhhhhhhhhhhhhhhhhhhhee",
+		"too few pieces":    "d4:infod6:lengthi20e4:name1:a12:piece lengthi10e6:pieces20:hhhhhhhhhhhhhhhhhhhhee",
+		"too many pieces":   "d4:infod6:lengthi10e4:name1:a12:piece lengthi10e6:pieces40:hhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhee",
+		"missing length":    "d4:infod4:name1:a12:piece lengthi10e6:pieces20:hhhhhhhhhhhhhhhhhhhhee",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Info([]byte(input)); err == nil {
+				t.Errorf("expected error for %q", input)
+			}
+		})
+	}
+}
+
+func TestInfoMultiFile(t *testing.T) {
+	data, err := bencode.Marshal(map[string]any{
+		"announce": "http://tracker/announce",
+		"info": map[string]any{
+			"name":         "folder",
+			"piece length": 16,
+			"pieces":       make([]byte, 40),
+			"files": []any{
+				map[string]any{"length": 10, "path": []string{"a.txt"}},
+				map[string]any{"length": 0, "path": []string{"empty"}},
+				map[string]any{"length": 20, "path": []string{"sub", "b.txt"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode torrent: %v", err)
+	}
+
+	info, err := Info(data)
+	if err != nil {
+		t.Fatalf("failed to parse torrent file: %v", err)
+	}
+
+	if !info.IsMultiFile() {
+		t.Errorf("expected a multi-file torrent")
+	}
+	if info.Length != 30 {
+		t.Errorf("expected Length to be 30, but got %d", info.Length)
+	}
+
+	want := []FileEntry{
+		{Path: []string{"folder", "a.txt"}, Length: 10, Offset: 0},
+		{Path: []string{"folder", "empty"}, Length: 0, Offset: 10},
+		{Path: []string{"folder", "sub", "b.txt"}, Length: 20, Offset: 10},
+	}
+	if !reflect.DeepEqual(info.Files, want) {
+		t.Errorf("expected files %+v, got %+v", want, info.Files)
+	}
+}
+
+func TestInfoSingleFileEntry(t *testing.T) {
+	content, err := os.ReadFile("../../sample.torrent")
+	if err != nil {
+		t.Fatalf("failed to read sample.torrent: %v", err)
+	}
+
+	info, err := Info(content)
+	if err != nil {
+		t.Fatalf("failed to parse torrent file: %v", err)
+	}
+
+	if info.IsMultiFile() {
+		t.Errorf("expected a single-file torrent")
+	}
+	want := []FileEntry{{Path: []string{"sample.txt"}, Length: 92063}}
+	if !reflect.DeepEqual(info.Files, want) {
+		t.Errorf("expected files %+v, got %+v", want, info.Files)
+	}
+}
+
+func TestInfoUnsafePaths(t *testing.T) {
+	tests := map[string]map[string]any{
+		"dot dot in path":   {"name": "x", "files": []any{map[string]any{"length": 1, "path": []string{"..", "etc"}}}},
+		"absolute path":     {"name": "x", "files": []any{map[string]any{"length": 1, "path": []string{"/etc/passwd"}}}},
+		"separator in path": {"name": "x", "files": []any{map[string]any{"length": 1, "path": []string{"a/../../b"}}}},
+		"empty path":        {"name": "x", "files": []any{map[string]any{"length": 1, "path": []string{}}}},
+		"dot dot name":      {"name": "..", "length": 1},
+		"negative length":   {"name": "x", "length": -1},
+	}
+
+	for name, info := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := bencode.Marshal(map[string]any{"info": info})
+			if err != nil {
+				t.Fatalf("failed to encode torrent: %v", err)
+			}
+			if _, err := Info(data); err == nil {
+				t.Errorf("expected error for %v", info)
+			}
+		})
+	}
+}
+
+func TestInfoOptionalFields(t *testing.T) {
+	data, err := bencode.Marshal(map[string]any{
+		"announce":      "http://a/announce",
+		"announce-list": []any{[]string{"http://a/announce", "udp://b:80"}, []string{}, []string{"http://c/announce"}},
+		"comment":       "a comment",
+		"created by":    "mybittorrent",
+		"creation date": 1700000000,
+		"encoding":      "UTF-8",
+		"url-list":      "http://seed/file",
+		"httpseeds":     []string{"http://h1/seed", "http://h2/seed"},
+		"info": map[string]any{
+			"name":         "x",
+			"length":       1,
+			"piece length": 1,
+			"pieces":       make([]byte, 20),
+			"private":      1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode torrent: %v", err)
+	}
+
+	info, err := Info(data)
+	if err != nil {
+		t.Fatalf("failed to parse torrent file: %v", err)
+	}
+
+	wantTiers := [][]string{{"http://a/announce", "udp://b:80"}, {"http://c/announce"}}
+	if !reflect.DeepEqual(info.AnnounceList, wantTiers) {
+		t.Errorf("expected AnnounceList %v, got %v", wantTiers, info.AnnounceList)
+	}
+	if !info.Private {
+		t.Errorf("expected Private to be true")
+	}
+	if !reflect.DeepEqual(info.URLList, []string{"http://seed/file"}) {
+		t.Errorf("expected URLList [http://seed/file], got %v", info.URLList)
+	}
+	if !reflect.DeepEqual(info.HTTPSeeds, []string{"http://h1/seed", "http://h2/seed"}) {
+		t.Errorf("expected HTTPSeeds [http://h1/seed http://h2/seed], got %v", info.HTTPSeeds)
+	}
+	if info.Comment != "a comment" || info.CreatedBy != "mybittorrent" || info.Encoding != "UTF-8" {
+		t.Errorf("unexpected comment %q, created by %q or encoding %q", info.Comment, info.CreatedBy, info.Encoding)
+	}
+	if !info.CreationDate.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("expected CreationDate %v, got %v", time.Unix(1700000000, 0), info.CreationDate)
+	}
+}
+
+func TestInfoTrackerless(t *testing.T) {
+	data := []byte("d4:infod6:lengthi1e4:name1:x12:piece lengthi1e6:pieces20:aaaaaaaaaaaaaaaaaaaaee")
+
+	info, err := Info(data)
+	if err != nil {
+		t.Fatalf("failed to parse torrent file: %v", err)
+	}
+	if info.Announce != "" || info.AnnounceList != nil || info.Private || !info.CreationDate.IsZero() {
+		t.Errorf("expected optional fields to be empty, got %+v", info)
+	}
+
+	if _, err := Peers(&testutil.MockHTTPClient{}, info); err == nil {
+		t.Errorf("expected Peers to fail without a tracker URL")
+	}
+}
@@ -0,0 +1,177 @@
+package torrent
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Magnet is a parsed magnet link (BEP 9). It identifies a torrent by its
+// info hash, leaving the info dictionary to be fetched from peers.
+type Magnet struct {
+	InfoHash   [20]byte // The v1 info hash, or the truncated v2 hash when the link only has a v2 hash.
+	InfoHashV2 [32]byte // The v2 info hash (BEP 52), zero when the link does not have one.
+	HasV1      bool     // Whether the link has a v1 info hash.
+	Name       string   // Display name suggested for the torrent.
+	Trackers   []string // Tracker URLs.
+	WebSeeds   []string // Web seed URLs (BEP 19).
+	Peers      []string // Addresses of peers to contact, in the format "host:port".
+	SelectOnly []int    // Indices of the files to download, all of them when empty.
+}
+
+// ParseMagnet parses a magnet link such as
+// "magnet:?xt=urn:btih:<info-hash>&dn=<name>&tr=<tracker-url>".
+//
+// The info hash is given as xt=urn:btih: in hex or base32, or as a v2 hash
+// with xt=urn:btmh: in multihash format; hybrid torrents may give both.
+func ParseMagnet(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("expected a magnet link, but got scheme %q", u.Scheme)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
+	}
+
+	magnet := &Magnet{
+		Name:     query.Get("dn"),
+		Trackers: query["tr"],
+		WebSeeds: query["ws"],
+	}
+
+	var hasV2 bool
+	for _, xt := range query["xt"] {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			if magnet.InfoHash, err = parseBTIH(strings.TrimPrefix(xt, "urn:btih:")); err != nil {
+				return nil, err
+			}
+			magnet.HasV1 = true
+		case strings.HasPrefix(xt, "urn:btmh:"):
+			if magnet.InfoHashV2, err = parseBTMH(strings.TrimPrefix(xt, "urn:btmh:")); err != nil {
+				return nil, err
+			}
+			hasV2 = true
+		}
+	}
+	if !magnet.HasV1 && !hasV2 {
+		return nil, fmt.Errorf("magnet link has no BitTorrent info hash")
+	}
+	if !magnet.HasV1 {
+		copy(magnet.InfoHash[:], magnet.InfoHashV2[:])
+	}
+
+	for _, peer := range query["x.pe"] {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return nil, fmt.Errorf("invalid peer address %q: %v", peer, err)
+		}
+		magnet.Peers = append(magnet.Peers, peer)
+	}
+
+	if so := query.Get("so"); so != "" {
+		if magnet.SelectOnly, err = parseSelectOnly(so); err != nil {
+			return nil, err
+		}
+	}
+
+	return magnet, nil
+}
+
+// parseBTIH parses a v1 info hash in hex or base32.
+func parseBTIH(s string) ([20]byte, error) {
+	var hash [20]byte
+	var decoded []byte
+	var err error
+	switch len(s) {
+	case 40:
+		decoded, err = hex.DecodeString(s)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("expected a 40-character hex or 32-character base32 info hash, but got %d characters", len(s))
+	}
+	if err != nil {
+		return hash, fmt.Errorf("invalid info hash %q: %v", s, err)
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// parseBTMH parses a v2 info hash in hex multihash format, which must be a
+// SHA-256 hash.
+func parseBTMH(s string) ([32]byte, error) {
+	var hash [32]byte
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return hash, fmt.Errorf("invalid v2 info hash %q: %v", s, err)
+	}
+	// 0x12 is the multihash code for SHA-256 and 0x20 its length.
+	if len(decoded) != 34 || decoded[0] != 0x12 || decoded[1] != 0x20 {
+		return hash, fmt.Errorf("expected a SHA-256 multihash, but got %q", s)
+	}
+	copy(hash[:], decoded[2:])
+	return hash, nil
+}
+
+// maxSelectOnly bounds the number of file indices a magnet link may select.
+const maxSelectOnly = 1 << 20
+
+// parseSelectOnly parses a list of file indices and ranges such as "0,2,4-6".
+func parseSelectOnly(s string) ([]int, error) {
+	var indices []int
+	for _, item := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid file index %q", item)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid file range %q", item)
+			}
+		}
+		if len(indices)+end-start >= maxSelectOnly {
+			return nil, fmt.Errorf("too many file indices in %q", s)
+		}
+		for i := start; i <= end; i++ {
+			indices = append(indices, i)
+		}
+	}
+	slices.Sort(indices)
+	return slices.Compact(indices), nil
+}
+
+// Metadata returns the metadata known from the magnet link alone, which is
+// enough to find peers but has no files or pieces.
+func (m *Magnet) Metadata() *Metadata {
+	metadata := &Metadata{
+		Name:        m.Name,
+		InfoHash:    m.InfoHash,
+		InfoHashV2:  m.InfoHashV2,
+		MetaVersion: 1,
+		URLList:     m.WebSeeds,
+	}
+	if !m.HasV1 || m.InfoHashV2 != [32]byte{} {
+		metadata.MetaVersion = 2
+	}
+	if len(m.Trackers) > 0 {
+		metadata.Announce = m.Trackers[0]
+	}
+	if len(m.Trackers) > 1 {
+		for _, tracker := range m.Trackers {
+			metadata.AnnounceList = append(metadata.AnnounceList, []string{tracker})
+		}
+	}
+	return metadata
+}
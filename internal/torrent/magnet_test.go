@@ -0,0 +1,114 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	v1, _ := hex.DecodeString("d69f91e6b2ae4c542468d1073a71d4ea13879a7f")
+	v2, _ := hex.DecodeString("caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e")
+	var infoHash [20]byte
+	var infoHashV2 [32]byte
+	var truncated [20]byte
+	copy(infoHash[:], v1)
+	copy(infoHashV2[:], v2)
+	copy(truncated[:], v2)
+
+	tests := []struct {
+		name string
+		uri  string
+		want Magnet
+	}{
+		{
+			name: "hex info hash with name and tracker",
+			uri:  "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&dn=sample.txt&tr=http%3A%2F%2Ftracker%2Fannounce",
+			want: Magnet{InfoHash: infoHash, HasV1: true, Name: "sample.txt", Trackers: []string{"http://tracker/announce"}},
+		},
+		{
+			name: "base32 info hash",
+			uri:  "magnet:?xt=urn:btih:22PZDZVSVZGFIJDI2EDTU4OU5IJYPGT7",
+			want: Magnet{InfoHash: infoHash, HasV1: true},
+		},
+		{
+			name: "lower case base32 info hash",
+			uri:  "magnet:?xt=urn:btih:22pzdzvsvzgfijdi2edtu4ou5ijypgt7",
+			want: Magnet{InfoHash: infoHash, HasV1: true},
+		},
+		{
+			name: "v2 info hash",
+			uri:  "magnet:?xt=urn:btmh:1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e",
+			want: Magnet{InfoHash: truncated, InfoHashV2: infoHashV2},
+		},
+		{
+			name: "hybrid with peers, web seeds and selected files",
+			uri: "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f" +
+				"&xt=urn:btmh:1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e" +
+				"&tr=udp://a:80&tr=http://b/announce&ws=http://seed/&x.pe=10.0.0.1:6881&x.pe=[::1]:6882&so=4-6,0,2,5",
+			want: Magnet{
+				InfoHash:   infoHash,
+				InfoHashV2: infoHashV2,
+				HasV1:      true,
+				Trackers:   []string{"udp://a:80", "http://b/announce"},
+				WebSeeds:   []string{"http://seed/"},
+				Peers:      []string{"10.0.0.1:6881", "[::1]:6882"},
+				SelectOnly: []int{0, 2, 4, 5, 6},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMagnet(tt.uri)
+			if err != nil {
+				t.Fatalf("ParseMagnet() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseMagnet() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMagnetInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not a magnet link":   "http://example.com/?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+		"missing info hash":   "magnet:?dn=x",
+		"other urn":           "magnet:?xt=urn:sha1:d69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+		"short info hash":     "magnet:?xt=urn:btih:d69f91",
+		"bad hex":             "magnet:?xt=urn:btih:z69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+		"bad base32":          "magnet:?xt=urn:btih:1111111111111111111111111111111!",
+		"not sha-256":         "magnet:?xt=urn:btmh:1114d69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+		"bad peer":            "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&x.pe=nohost",
+		"bad file range":      "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&so=5-2",
+		"huge file range":     "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&so=0-99999999999",
+		"negative file index": "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&so=-1",
+	}
+
+	for name, uri := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseMagnet(uri); err == nil {
+				t.Errorf("expected error for %q", uri)
+			}
+		})
+	}
+}
+
+func TestMagnetMetadata(t *testing.T) {
+	magnet, err := ParseMagnet("magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&dn=sample.txt&tr=http://a/announce&tr=http://b/announce")
+	if err != nil {
+		t.Fatalf("ParseMagnet() error = %v", err)
+	}
+
+	metadata := magnet.Metadata()
+	if metadata.InfoHash != magnet.InfoHash || metadata.Name != "sample.txt" || metadata.IsV2() {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if metadata.Announce != "http://a/announce" {
+		t.Errorf("expected Announce http://a/announce, got %s", metadata.Announce)
+	}
+	if want := [][]string{{"http://a/announce"}, {"http://b/announce"}}; !reflect.DeepEqual(metadata.AnnounceList, want) {
+		t.Errorf("expected AnnounceList %v, got %v", want, metadata.AnnounceList)
+	}
+}
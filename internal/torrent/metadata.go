@@ -0,0 +1,179 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+const (
+	// metadataPieceSize is the size of each piece of the info dictionary
+	// but the last.
+	metadataPieceSize = 16 << 10
+
+	// maxMetadataSize bounds the size of an info dictionary fetched from a
+	// peer.
+	maxMetadataSize = 64 << 20
+)
+
+// ut_metadata message types.
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+// metadataMessage is the bencoded header of a ut_metadata message. Data
+// messages are followed by the piece of the info dictionary.
+type metadataMessage struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// FetchMetadata downloads the info dictionary of the magnet link's torrent
+// from a peer using the ut_metadata extension (BEP 9), and returns the
+// torrent's metadata with the magnet link's trackers and web seeds.
+//
+// It performs the handshake itself, so conn must be freshly connected. The
+// info dictionary is verified against the magnet link's info hashes. It
+// reads until the peer has sent all of it, so callers should set a deadline
+// on conn in case the peer never does.
+func FetchMetadata(conn io.ReadWriter, magnet *Magnet) (*Metadata, error) {
+	peer, err := HandshakePeer(conn, magnet.Metadata())
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	if !peer.SupportsExtensions() {
+		return nil, fmt.Errorf("peer does not support the extension protocol")
+	}
+
+	fetcher := &metadataFetcher{}
+	ext := NewExtensions(conn)
+	if err := ext.Register("ut_metadata", fetcher); err != nil {
+		return nil, err
+	}
+	if err := ext.SendHandshake(); err != nil {
+		return nil, err
+	}
+
+	for !fetcher.done() {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		if msg.Type != MessageTypeExtended {
+			continue
+		}
+		if err := ext.Handle(msg.Payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := magnet.verify(fetcher.info); err != nil {
+		return nil, err
+	}
+	return magnet.torrent(fetcher.info)
+}
+
+// metadataFetcher is the ut_metadata extension handler that downloads the
+// info dictionary, one piece at a time.
+type metadataFetcher struct {
+	size int
+	info []byte
+}
+
+func (f *metadataFetcher) done() bool {
+	return f.info != nil && len(f.info) == f.size
+}
+
+// HandleExtendedHandshake learns the size of the info dictionary and
+// requests its first piece.
+func (f *metadataFetcher) HandleExtendedHandshake(ext *Extensions, handshake *ExtendedHandshake) error {
+	if !ext.PeerSupports("ut_metadata") {
+		return fmt.Errorf("peer does not support ut_metadata")
+	}
+	if handshake.MetadataSize <= 0 || handshake.MetadataSize > maxMetadataSize {
+		return fmt.Errorf("invalid metadata size %d", handshake.MetadataSize)
+	}
+	f.size = handshake.MetadataSize
+	f.info = make([]byte, 0, f.size)
+	return f.request(ext, 0, metadataRequest)
+}
+
+// HandleExtended appends each piece of the info dictionary and requests the
+// next. Requests for our metadata are rejected, since we do not have it.
+func (f *metadataFetcher) HandleExtended(ext *Extensions, payload []byte) error {
+	_, n, err := bencode.Decode(payload)
+	if err != nil {
+		return fmt.Errorf("invalid metadata message: %w", err)
+	}
+	var msg metadataMessage
+	if err := bencode.Unmarshal(payload[:n], &msg); err != nil {
+		return fmt.Errorf("invalid metadata message: %w", err)
+	}
+	data := payload[n:]
+
+	piece := len(f.info) / metadataPieceSize
+	switch {
+	case msg.MsgType == metadataRequest:
+		return f.request(ext, msg.Piece, metadataReject)
+	case msg.MsgType == metadataReject:
+		return fmt.Errorf("peer rejected request for metadata piece %d", msg.Piece)
+	case msg.MsgType != metadataData:
+		return nil
+	case f.info == nil:
+		return fmt.Errorf("unexpected metadata piece before the extension handshake")
+	case msg.Piece != piece:
+		return fmt.Errorf("expected metadata piece %d, but got %d", piece, msg.Piece)
+	}
+
+	if want := min(metadataPieceSize, f.size-len(f.info)); len(data) != want {
+		return fmt.Errorf("expected metadata piece %d to be %d bytes, but got %d", piece, want, len(data))
+	}
+	f.info = append(f.info, data...)
+
+	if f.done() {
+		return nil
+	}
+	return f.request(ext, piece+1, metadataRequest)
+}
+
+// request sends a ut_metadata message without data about a piece.
+func (f *metadataFetcher) request(ext *Extensions, piece, msgType int) error {
+	payload, err := bencode.Marshal(metadataMessage{MsgType: msgType, Piece: piece})
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata message: %w", err)
+	}
+	return ext.Send("ut_metadata", payload)
+}
+
+// verify checks an info dictionary against the magnet link's info hashes.
+func (m *Magnet) verify(info []byte) error {
+	if m.HasV1 && sha1.Sum(info) != m.InfoHash {
+		return fmt.Errorf("metadata does not match the info hash %x", m.InfoHash)
+	}
+	if m.InfoHashV2 != [32]byte{} && sha256.Sum256(info) != m.InfoHashV2 {
+		return fmt.Errorf("metadata does not match the v2 info hash %x", m.InfoHashV2)
+	}
+	return nil
+}
+
+// torrent returns the metadata of the torrent with the given info dictionary
+// and the magnet link's trackers and web seeds.
+func (m *Magnet) torrent(info []byte) (*Metadata, error) {
+	known := m.Metadata()
+	data, err := bencode.Marshal(metainfo{
+		Announce:     known.Announce,
+		AnnounceList: known.AnnounceList,
+		URLList:      known.URLList,
+		Info:         info,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode torrent: %w", err)
+	}
+	return Info(data)
+}
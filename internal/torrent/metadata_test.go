@@ -0,0 +1,145 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+// fakeMetadataPeer serves info over conn the way a peer supporting
+// ut_metadata does. It rejects requests for pieces in reject.
+func fakeMetadataPeer(t *testing.T, conn net.Conn, info []byte, extensions bool, reject int) {
+	defer conn.Close()
+
+	handshake := make([]byte, HandshakeLength)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+	if handshake[20+extensionByte]&extensionBit == 0 {
+		t.Errorf("expected handshake to advertise the extension protocol")
+	}
+	if !extensions {
+		handshake[20+extensionByte] = 0
+	}
+	if _, err := conn.Write(handshake); err != nil {
+		return
+	}
+
+	msg, err := readMessage(conn)
+	if err != nil {
+		return
+	}
+	var theirs ExtendedHandshake
+	if msg.Type != MessageTypeExtended || bencode.Unmarshal(msg.Payload[1:], &theirs) != nil {
+		t.Errorf("expected an extension handshake, got message type %d", msg.Type)
+		return
+	}
+
+	// Messages that the client should skip.
+	peerwire.WriteMessages(conn, peerwire.Bitfield{0xff})
+	writeExtended(conn, 9, []byte("unknown"))
+
+	payload, _ := bencode.Marshal(ExtendedHandshake{M: map[string]int{"ut_metadata": 3}, MetadataSize: len(info)})
+	writeExtended(conn, extendedHandshakeID, payload)
+
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		if msg.Type != MessageTypeExtended || msg.Payload[0] != 3 {
+			t.Errorf("expected a ut_metadata message, got message type %d", msg.Type)
+			return
+		}
+		var request metadataMessage
+		if err := bencode.Unmarshal(msg.Payload[1:], &request); err != nil || request.MsgType != metadataRequest {
+			t.Errorf("expected a metadata request, got %q", msg.Payload[1:])
+			return
+		}
+
+		if request.Piece == reject {
+			header, _ := bencode.Marshal(metadataMessage{MsgType: metadataReject, Piece: request.Piece})
+			writeExtended(conn, byte(theirs.M["ut_metadata"]), header)
+			continue
+		}
+
+		start := request.Piece * metadataPieceSize
+		end := min(start+metadataPieceSize, len(info))
+		header, _ := bencode.Marshal(metadataMessage{MsgType: metadataData, Piece: request.Piece, TotalSize: len(info)})
+		writeExtended(conn, byte(theirs.M["ut_metadata"]), append(header, info[start:end]...))
+	}
+}
+
+// testInfoDict returns an info dictionary spanning two metadata pieces.
+func testInfoDict(t *testing.T) []byte {
+	t.Helper()
+	info, err := bencode.Marshal(map[string]any{
+		"name":         "big.bin",
+		"length":       1000 * 16384,
+		"piece length": 16384,
+		"pieces":       []byte(strings.Repeat("0123456789abcdefghij", 1000)),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode info: %v", err)
+	}
+	return info
+}
+
+func TestFetchMetadata(t *testing.T) {
+	info := testInfoDict(t)
+	infoHash := sha1.Sum(info)
+	magnet := &Magnet{InfoHash: infoHash, HasV1: true, Trackers: []string{"http://tracker/announce"}, WebSeeds: []string{"http://seed/"}}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go fakeMetadataPeer(t, server, info, true, -1)
+
+	metadata, err := FetchMetadata(client, magnet)
+	if err != nil {
+		t.Fatalf("FetchMetadata failed: %v", err)
+	}
+
+	if metadata.InfoHash != infoHash {
+		t.Errorf("expected info hash %x, got %x", infoHash, metadata.InfoHash)
+	}
+	if metadata.Name != "big.bin" || metadata.Length != 1000*16384 || len(metadata.PieceHashes) != 1000 {
+		t.Errorf("unexpected metadata: name %s, length %d, %d pieces", metadata.Name, metadata.Length, len(metadata.PieceHashes))
+	}
+	if metadata.Announce != "http://tracker/announce" || len(metadata.URLList) != 1 {
+		t.Errorf("expected the magnet link's tracker and web seed, got %s and %v", metadata.Announce, metadata.URLList)
+	}
+}
+
+func TestFetchMetadataErrors(t *testing.T) {
+	info := testInfoDict(t)
+
+	tests := []struct {
+		name       string
+		infoHash   [20]byte
+		extensions bool
+		reject     int
+		wantErr    string
+	}{
+		{name: "wrong info hash", infoHash: [20]byte{1}, extensions: true, reject: -1, wantErr: "does not match"},
+		{name: "no extension protocol", infoHash: sha1.Sum(info), extensions: false, reject: -1, wantErr: "extension protocol"},
+		{name: "rejected piece", infoHash: sha1.Sum(info), extensions: true, reject: 1, wantErr: "rejected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go fakeMetadataPeer(t, server, info, tt.extensions, tt.reject)
+
+			_, err := FetchMetadata(client, &Magnet{InfoHash: tt.infoHash, HasV1: true})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("FetchMetadata() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
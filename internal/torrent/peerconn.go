@@ -0,0 +1,52 @@
+package torrent
+
+import (
+	"io"
+)
+
+// PeerConn is a connection to a peer that has completed the handshake and,
+// if the peer supports it, the extension handshake.
+type PeerConn struct {
+	Handshake  *PeerHandshake
+	Extensions *Extensions // Nil if the peer does not support the extension protocol.
+	PEX        *PEX        // Nil if peer exchange is disabled.
+
+	// MaxRequests, if positive, caps the block requests kept in flight,
+	// which otherwise follow the reqq of the peer's extension handshake.
+	MaxRequests int
+
+	conn io.ReadWriter
+}
+
+// NewPeerConn performs the handshakes over conn. Peer exchange is offered
+// with pool, unless pool is nil or the torrent is private (BEP 27).
+func NewPeerConn(conn io.ReadWriter, metadata *Metadata, pool *PeerPool) (*PeerConn, error) {
+	handshake, err := HandshakePeer(conn, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PeerConn{Handshake: handshake, conn: conn}
+	if !handshake.SupportsExtensions() {
+		return c, nil
+	}
+
+	c.Extensions = NewExtensions(conn)
+	if pool != nil && !metadata.Private {
+		c.PEX = NewPEX(pool)
+		if err := c.Extensions.Register("ut_pex", c.PEX); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Extensions.SendHandshake(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DownloadPiece is like the DownloadPiece function, but also handles the
+// extended messages that arrive meanwhile, and the Fast Extension messages
+// if the handshake negotiated it.
+func (c *PeerConn) DownloadPiece(writer io.Writer, metadata *Metadata, pieceIndex int) error {
+	return downloadPiece(c.conn, writer, metadata, pieceIndex, c.Handshake.SupportsFast(), c.Extensions, c.MaxRequests)
+}
@@ -0,0 +1,129 @@
+package torrent
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/testutil"
+)
+
+func extendedMessage(id byte, payload []byte) []byte {
+	msg := []byte{0, 0, 0, byte(len(payload) + 2), byte(MessageTypeExtended), id}
+	return append(msg, payload...)
+}
+
+func TestPeerConnPEX(t *testing.T) {
+	metadata := &Metadata{
+		InfoHash:    [20]byte{1},
+		PieceLength: 16384,
+		Length:      16384 + 1,
+		PieceHashes: []string{"", pieceHash([]byte{0x2a})},
+	}
+	response := make([]byte, HandshakeLength)
+	response[0] = byte(len(ProtocolString))
+	copy(response[1:20], ProtocolString)
+	response[20+5] = 0x10
+	copy(response[28:48], metadata.InfoHash[:])
+
+	handshake, _ := bencode.Marshal(ExtendedHandshake{M: map[string]int{"ut_pex": 3}})
+	pex, _ := bencode.Marshal(pexMessage{Added: compactPeers("10.0.0.1:6881")})
+
+	mockConn := testutil.NewMockTCPConn()
+	mockConn.SetReadData(bytes.Join([][]byte{
+		response,
+		extendedMessage(extendedHandshakeID, handshake),
+		extendedMessage(1, pex),                           // our ID for ut_pex
+		{0, 0, 0, 1, 0x01},                                // unchoke
+		{0, 0, 0, 10, 0x07, 0, 0, 0, 1, 0, 0, 0, 0, 0x2a}, // piece
+	}, nil))
+
+	pool := NewPeerPool()
+	conn, err := NewPeerConn(mockConn, metadata, pool)
+	if err != nil {
+		t.Fatalf("NewPeerConn failed: %v", err)
+	}
+	if conn.Extensions == nil || conn.PEX == nil {
+		t.Fatalf("expected extensions and peer exchange to be enabled")
+	}
+
+	var output bytes.Buffer
+	if err := conn.DownloadPiece(&output, metadata, 1); err != nil {
+		t.Fatalf("DownloadPiece failed: %v", err)
+	}
+	if !bytes.Equal(output.Bytes(), []byte{0x2a}) {
+		t.Errorf("DownloadPiece() wrote %v, want %v", output.Bytes(), []byte{0x2a})
+	}
+	if !conn.Extensions.PeerSupports("ut_pex") {
+		t.Errorf("expected the peer's extended handshake to be handled")
+	}
+	want := []PoolPeer{{Addr: netip.MustParseAddrPort("10.0.0.1:6881"), Sources: SourcePEX}}
+	if got := pool.Peers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Peers() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPeerConnPrivate(t *testing.T) {
+	metadata := &Metadata{InfoHash: [20]byte{1}, Private: true}
+	response := make([]byte, HandshakeLength)
+	response[0] = byte(len(ProtocolString))
+	copy(response[1:20], ProtocolString)
+	response[20+5] = 0x10
+	copy(response[28:48], metadata.InfoHash[:])
+
+	mockConn := testutil.NewMockTCPConn()
+	mockConn.SetReadData(response)
+
+	conn, err := NewPeerConn(mockConn, metadata, NewPeerPool())
+	if err != nil {
+		t.Fatalf("NewPeerConn failed: %v", err)
+	}
+	if conn.Extensions == nil || conn.PEX != nil {
+		t.Errorf("expected extensions without peer exchange for a private torrent")
+	}
+
+	written := bytes.NewReader(mockConn.GetWrittenData()[HandshakeLength:])
+	msg, err := readMessage(written)
+	if err != nil || msg.Type != MessageTypeExtended {
+		t.Fatalf("expected an extended handshake, got %v, %v", msg, err)
+	}
+	var sent ExtendedHandshake
+	if err := bencode.Unmarshal(msg.Payload[1:], &sent); err != nil {
+		t.Fatalf("failed to decode extended handshake: %v", err)
+	}
+	if _, ok := sent.M["ut_pex"]; ok {
+		t.Errorf("expected ut_pex not to be offered for a private torrent, got %v", sent.M)
+	}
+}
+
+func TestPeerConnFast(t *testing.T) {
+	metadata := &Metadata{InfoHash: [20]byte{1}, PieceLength: 16384, Length: 1, PieceHashes: []string{pieceHash([]byte{0x2a})}}
+	for _, fast := range []bool{true, false} {
+		response := make([]byte, HandshakeLength)
+		response[0] = byte(len(ProtocolString))
+		copy(response[1:20], ProtocolString)
+		if fast {
+			response[20+7] = 0x04
+		}
+		copy(response[28:48], metadata.InfoHash[:])
+
+		mockConn := testutil.NewMockTCPConn()
+		mockConn.SetReadData(bytes.Join([][]byte{
+			response,
+			{0, 0, 0, 1, 0x0E}, // have all
+			{0, 0, 0, 1, 0x01}, // unchoke
+			{0, 0, 0, 10, 0x07, 0, 0, 0, 0, 0, 0, 0, 0, 0x2a}, // piece
+		}, nil))
+		conn, err := NewPeerConn(mockConn, metadata, nil)
+		if err != nil {
+			t.Fatalf("NewPeerConn failed: %v", err)
+		}
+
+		var output bytes.Buffer
+		if err := conn.DownloadPiece(&output, metadata, 0); (err == nil) != fast {
+			t.Errorf("DownloadPiece() with fast = %v error = %v", fast, err)
+		}
+	}
+}
@@ -33,7 +33,7 @@ func TestPeers(t *testing.T) {
 		Response: encodedResponse,
 	}
 
-	peers, err := Peers(mockHTTPClient, info)
+	response, err := Peers(mockHTTPClient, info)
 	if err != nil {
 		t.Fatalf("failed to get peers: %v", err)
 	}
@@ -48,7 +48,7 @@ func TestPeers(t *testing.T) {
 		t.Errorf("expected announce URL to be 'http://bittorrent-test-tracker.codecrafters.io/announce', but got '%s'", request.URL.String())
 	}
 
-	if len(peers) != 1 || peers[0] != "165.232.41.73:51540" {
+	if peers := response.Peers; len(peers) != 1 || peers[0].String() != "165.232.41.73:51540" {
 		t.Errorf("expected peers to be ['165.232.41.73:51540'], but got %v", peers)
 	}
 }
@@ -0,0 +1,222 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+const (
+	// DefaultPEXInterval is the minimum time between peer exchange messages
+	// sent to one peer.
+	DefaultPEXInterval = time.Minute
+
+	// maxPEXPeers bounds the added and the dropped peers of one message.
+	maxPEXPeers = 50
+)
+
+// pexMessage is the payload of a ut_pex message. Peers are in compact form,
+// with one byte of PeerFlags per added peer.
+type pexMessage struct {
+	Added    []byte `bencode:"added,omitempty"`
+	AddedF   []byte `bencode:"added.f,omitempty"`
+	Added6   []byte `bencode:"added6,omitempty"`
+	Added6F  []byte `bencode:"added6.f,omitempty"`
+	Dropped  []byte `bencode:"dropped,omitempty"`
+	Dropped6 []byte `bencode:"dropped6,omitempty"`
+}
+
+// PEX is the ut_pex extension handler (BEP 11) for one connection. It
+// merges the peers the remote peer adds and drops into a pool, and tells the
+// remote peer which peers we are connected to.
+type PEX struct {
+	Pool     *PeerPool
+	Interval time.Duration // Minimum time between messages; DefaultPEXInterval when zero.
+
+	now          func() time.Time
+	handshake    chan struct{} // Signalled when the peer's extension handshake arrives.
+	mu           sync.Mutex
+	advertised   map[netip.AddrPort]bool
+	lastSent     time.Time
+	lastReceived time.Time
+}
+
+// NewPEX returns a ut_pex handler that adds the peers it learns to pool.
+func NewPEX(pool *PeerPool) *PEX {
+	return &PEX{
+		Pool:       pool,
+		now:        time.Now,
+		handshake:  make(chan struct{}, 1),
+		advertised: make(map[netip.AddrPort]bool),
+	}
+}
+
+// HandleExtendedHandshake wakes Run, which can only advertise once it
+// knows that the peer supports ut_pex.
+func (x *PEX) HandleExtendedHandshake(ext *Extensions, handshake *ExtendedHandshake) error {
+	select {
+	case x.handshake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (x *PEX) interval() time.Duration {
+	if x.Interval <= 0 {
+		return DefaultPEXInterval
+	}
+	return x.Interval
+}
+
+// HandleExtended merges a peer exchange message into the pool. Messages
+// that come much sooner than the interval allows are ignored, as are peers
+// beyond the per-message limit.
+func (x *PEX) HandleExtended(ext *Extensions, payload []byte) error {
+	var msg pexMessage
+	if err := bencode.Unmarshal(payload, &msg); err != nil {
+		return fmt.Errorf("invalid peer exchange message: %w", err)
+	}
+
+	x.mu.Lock()
+	now := x.now()
+	if !x.lastReceived.IsZero() && now.Sub(x.lastReceived) < x.interval()/2 {
+		x.mu.Unlock()
+		return nil
+	}
+	x.lastReceived = now
+	x.mu.Unlock()
+
+	for _, family := range []struct {
+		added, flags, dropped []byte
+		ipLength              int
+	}{
+		{msg.Added, msg.AddedF, msg.Dropped, 4},
+		{msg.Added6, msg.Added6F, msg.Dropped6, 16},
+	} {
+		added, err := parseCompactPeers(family.added, family.ipLength)
+		if err != nil {
+			return fmt.Errorf("invalid added peers: %w", err)
+		}
+		dropped, err := parseCompactPeers(family.dropped, family.ipLength)
+		if err != nil {
+			return fmt.Errorf("invalid dropped peers: %w", err)
+		}
+
+		for i, addr := range added[:min(len(added), maxPEXPeers)] {
+			var flags PeerFlags
+			if len(family.flags) == len(added) {
+				flags = PeerFlags(family.flags[i])
+			}
+			x.Pool.Add(addr, flags, SourcePEX)
+		}
+		for _, addr := range dropped[:min(len(dropped), maxPEXPeers)] {
+			x.Pool.Drop(addr, SourcePEX)
+		}
+	}
+	return nil
+}
+
+// Advertise tells the remote peer which of connected it has not heard about
+// yet, and which peers we told it about are no longer connected. It sends
+// nothing if the remote peer does not support ut_pex, if nothing changed, or
+// if the interval has not passed since the last message, and reports
+// whether it sent a message.
+func (x *PEX) Advertise(ext *Extensions, connected []PoolPeer) (bool, error) {
+	if !ext.PeerSupports("ut_pex") {
+		return false, nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := x.now()
+	if !x.lastSent.IsZero() && now.Sub(x.lastSent) < x.interval() {
+		return false, nil
+	}
+
+	var msg pexMessage
+	var added, dropped int
+	current := make(map[netip.AddrPort]bool, len(connected))
+	for _, peer := range connected {
+		addr := netip.AddrPortFrom(peer.Addr.Addr().Unmap(), peer.Addr.Port())
+		current[addr] = true
+		if x.advertised[addr] || added == maxPEXPeers {
+			continue
+		}
+		if addr.Addr().Is4() {
+			msg.Added = appendCompactPeer(msg.Added, addr)
+			msg.AddedF = append(msg.AddedF, byte(peer.Flags))
+		} else {
+			msg.Added6 = appendCompactPeer(msg.Added6, addr)
+			msg.Added6F = append(msg.Added6F, byte(peer.Flags))
+		}
+		x.advertised[addr] = true
+		added++
+	}
+	for addr := range x.advertised {
+		if current[addr] || dropped == maxPEXPeers {
+			continue
+		}
+		if addr.Addr().Is4() {
+			msg.Dropped = appendCompactPeer(msg.Dropped, addr)
+		} else {
+			msg.Dropped6 = appendCompactPeer(msg.Dropped6, addr)
+		}
+		delete(x.advertised, addr)
+		dropped++
+	}
+	if added == 0 && dropped == 0 {
+		return false, nil
+	}
+
+	payload, err := bencode.Marshal(msg)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode peer exchange message: %w", err)
+	}
+	if err := ext.Send("ut_pex", payload); err != nil {
+		return false, err
+	}
+	x.lastSent = now
+	return true, nil
+}
+
+// Run advertises the peers returned by connected once per interval until
+// ctx is done or sending fails, and as soon as the peer's extension
+// handshake arrives if it had not when Run started. It may run alongside a
+// goroutine reading from the connection.
+func (x *PEX) Run(ctx context.Context, ext *Extensions, connected func() []PoolPeer) error {
+	// A handshake that arrived already is covered by the first message.
+	select {
+	case <-x.handshake:
+	default:
+	}
+	if _, err := x.Advertise(ext, connected()); err != nil {
+		return err
+	}
+
+	// The timer restarts after every message rather than ticking on a fixed
+	// schedule, so that a late tick never leaves the next one too early.
+	timer := time.NewTimer(x.interval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		case <-x.handshake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+
+		if _, err := x.Advertise(ext, connected()); err != nil {
+			return err
+		}
+		timer.Reset(x.interval())
+	}
+}
@@ -0,0 +1,242 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// pexTestConn returns extensions whose peer supports ut_pex with ID 5, and
+// a PEX with a controllable clock registered with them.
+func pexTestConn(t *testing.T, pool *PeerPool) (*Extensions, *PEX, *bytes.Buffer, *time.Time) {
+	t.Helper()
+	var conn bytes.Buffer
+	ext := NewExtensions(&conn)
+	pex := NewPEX(pool)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pex.now = func() time.Time { return now }
+	if err := ext.Register("ut_pex", pex); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	handshake, _ := bencode.Marshal(ExtendedHandshake{M: map[string]int{"ut_pex": 5}})
+	if err := ext.Handle(append([]byte{extendedHandshakeID}, handshake...)); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	return ext, pex, &conn, &now
+}
+
+func compactPeers(addrs ...string) []byte {
+	var b []byte
+	for _, addr := range addrs {
+		b = appendCompactPeer(b, netip.MustParseAddrPort(addr))
+	}
+	return b
+}
+
+func TestPEXHandleExtended(t *testing.T) {
+	pool := NewPeerPool()
+	pool.Add(netip.MustParseAddrPort("10.0.0.9:9"), 0, SourcePEX)
+	ext, _, _, now := pexTestConn(t, pool)
+
+	payload, _ := bencode.Marshal(pexMessage{
+		Added:    compactPeers("10.0.0.1:6881", "10.0.0.2:6882"),
+		AddedF:   []byte{byte(PeerIsSeed), byte(PeerSupportsUTP | PeerIsReachable)},
+		Added6:   compactPeers("[2001:db8::1]:6881"),
+		Dropped:  compactPeers("10.0.0.9:9"),
+		Dropped6: compactPeers("[2001:db8::9]:9"),
+	})
+	if err := ext.Handle(append([]byte{1}, payload...)); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+
+	want := []PoolPeer{
+		{Addr: netip.MustParseAddrPort("10.0.0.1:6881"), Flags: PeerIsSeed, Sources: SourcePEX},
+		{Addr: netip.MustParseAddrPort("10.0.0.2:6882"), Flags: PeerSupportsUTP | PeerIsReachable, Sources: SourcePEX},
+		{Addr: netip.MustParseAddrPort("[2001:db8::1]:6881"), Sources: SourcePEX},
+	}
+	if got := pool.Peers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Peers() = %+v, want %+v", got, want)
+	}
+
+	// A message right after the previous one is ignored.
+	payload, _ = bencode.Marshal(pexMessage{Added: compactPeers("10.0.0.3:6883")})
+	ext.Handle(append([]byte{1}, payload...))
+	if pool.Len() != 3 {
+		t.Errorf("expected a message within the interval to be ignored, got %+v", pool.Peers())
+	}
+
+	// At most 50 added peers are taken from a message.
+	*now = now.Add(DefaultPEXInterval)
+	var many []string
+	for i := range 60 {
+		many = append(many, netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 1, 0, byte(i)}), 1).String())
+	}
+	payload, _ = bencode.Marshal(pexMessage{Added: compactPeers(many...)})
+	if err := ext.Handle(append([]byte{1}, payload...)); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if pool.Len() != 53 {
+		t.Errorf("expected 50 more peers, got %d peers", pool.Len())
+	}
+
+	*now = now.Add(DefaultPEXInterval)
+	if err := ext.Handle(append([]byte{1}, []byte("d5:added5:12345e")...)); err == nil {
+		t.Errorf("expected error for truncated compact peers")
+	}
+}
+
+func TestPEXAdvertise(t *testing.T) {
+	ext, pex, conn, now := pexTestConn(t, NewPeerPool())
+	a := PoolPeer{Addr: netip.MustParseAddrPort("10.0.0.1:6881"), Flags: PeerIsSeed}
+	b := PoolPeer{Addr: netip.MustParseAddrPort("[2001:db8::1]:6881")}
+	c := PoolPeer{Addr: netip.MustParseAddrPort("10.0.0.3:6883")}
+
+	readPEX := func() pexMessage {
+		t.Helper()
+		msg, err := readMessage(conn)
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		if msg.Type != MessageTypeExtended || msg.Payload[0] != 5 {
+			t.Fatalf("expected a ut_pex message with ID 5, got type %d", msg.Type)
+		}
+		var pexMsg pexMessage
+		if err := bencode.Unmarshal(msg.Payload[1:], &pexMsg); err != nil {
+			t.Fatalf("failed to decode ut_pex message: %v", err)
+		}
+		return pexMsg
+	}
+
+	if sent, err := pex.Advertise(ext, []PoolPeer{a, b}); !sent || err != nil {
+		t.Fatalf("Advertise() = %v, %v, want true, nil", sent, err)
+	}
+	want := pexMessage{
+		Added:   compactPeers("10.0.0.1:6881"),
+		AddedF:  []byte{byte(PeerIsSeed)},
+		Added6:  compactPeers("[2001:db8::1]:6881"),
+		Added6F: []byte{0},
+	}
+	if got := readPEX(); !reflect.DeepEqual(got, want) {
+		t.Errorf("first message = %+v, want %+v", got, want)
+	}
+
+	// Too soon for another message.
+	if sent, _ := pex.Advertise(ext, []PoolPeer{a, c}); sent || conn.Len() != 0 {
+		t.Errorf("expected no message within the interval")
+	}
+
+	*now = now.Add(DefaultPEXInterval)
+	if sent, err := pex.Advertise(ext, []PoolPeer{a, c}); !sent || err != nil {
+		t.Fatalf("Advertise() = %v, %v, want true, nil", sent, err)
+	}
+	want = pexMessage{
+		Added:    compactPeers("10.0.0.3:6883"),
+		AddedF:   []byte{0},
+		Dropped6: compactPeers("[2001:db8::1]:6881"),
+	}
+	if got := readPEX(); !reflect.DeepEqual(got, want) {
+		t.Errorf("second message = %+v, want %+v", got, want)
+	}
+
+	// Nothing changed.
+	*now = now.Add(DefaultPEXInterval)
+	if sent, _ := pex.Advertise(ext, []PoolPeer{c, a}); sent {
+		t.Errorf("expected no message when nothing changed")
+	}
+
+	// At most 50 added peers per message; the rest follow later.
+	var many []PoolPeer
+	for i := range 60 {
+		many = append(many, PoolPeer{Addr: netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 1, 0, byte(i)}), 1)})
+	}
+	pex.Advertise(ext, many)
+	if got := readPEX(); len(got.Added) != 50*6 || len(got.Dropped) != 2*6 {
+		t.Errorf("expected 50 added and 2 dropped peers, got %d and %d bytes", len(got.Added), len(got.Dropped))
+	}
+	*now = now.Add(DefaultPEXInterval)
+	pex.Advertise(ext, many)
+	if got := readPEX(); len(got.Added) != 10*6 {
+		t.Errorf("expected the remaining 10 peers, got %d bytes", len(got.Added))
+	}
+}
+
+func TestPEXAdvertiseUnsupported(t *testing.T) {
+	var conn bytes.Buffer
+	ext := NewExtensions(&conn)
+	pex := NewPEX(NewPeerPool())
+	if sent, err := pex.Advertise(ext, []PoolPeer{{Addr: netip.MustParseAddrPort("10.0.0.1:1")}}); sent || err != nil || conn.Len() != 0 {
+		t.Errorf("Advertise() = %v, %v, want nothing sent to a peer without ut_pex", sent, err)
+	}
+}
+
+func TestPEXRun(t *testing.T) {
+	ext, pex, conn, _ := pexTestConn(t, NewPeerPool())
+	pex.now = time.Now
+	pex.Interval = 10 * time.Millisecond
+
+	var peers []PoolPeer
+	calls := 0
+	connected := func() []PoolPeer {
+		calls++
+		peers = append(peers, PoolPeer{Addr: netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(calls)}), 1)})
+		return slices.Clone(peers)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	if err := pex.Run(ctx, ext, connected); err != context.DeadlineExceeded {
+		t.Errorf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	messages := 0
+	for conn.Len() > 0 {
+		if _, err := readMessage(conn); err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		messages++
+	}
+	if messages < 2 || messages != calls {
+		t.Errorf("expected a message per call to connected, got %d messages and %d calls", messages, calls)
+	}
+}
+
+func TestPEXRunBeforeHandshake(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	ext := NewExtensions(local)
+	pex := NewPEX(NewPeerPool())
+	if err := ext.Register("ut_pex", pex); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connected := func() []PoolPeer { return []PoolPeer{{Addr: netip.MustParseAddrPort("10.0.0.1:1")}} }
+	go pex.Run(ctx, ext, connected)
+
+	// Run starts before it knows the peer supports ut_pex, and must not
+	// wait a whole interval once it does.
+	time.Sleep(10 * time.Millisecond)
+	handshake, _ := bencode.Marshal(ExtendedHandshake{M: map[string]int{"ut_pex": 5}})
+	if err := ext.Handle(append([]byte{extendedHandshakeID}, handshake...)); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := readMessage(remote)
+	if err != nil {
+		t.Fatalf("expected a peer exchange message after the handshake: %v", err)
+	}
+	if msg.Type != MessageTypeExtended || msg.Payload[0] != 5 {
+		t.Errorf("got message type %d, want a ut_pex message", msg.Type)
+	}
+}
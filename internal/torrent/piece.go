@@ -1,24 +1,27 @@
 package torrent
 
 import (
-	"encoding/binary"
+	"bytes"
 	"fmt"
 	"io"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
 )
 
 // MessageType represents the type of a peer message
-type MessageType byte
+type MessageType = peerwire.MessageType
 
 const (
-	MessageTypeChoke MessageType = iota
-	MessageTypeUnchoke
-	MessageTypeInterested
-	MessageTypeNotInterested
-	MessageTypeHave
-	MessageTypeBitfield
-	MessageTypeRequest
-	MessageTypePiece
-	MessageTypeCancel
+	MessageTypeChoke         = peerwire.TypeChoke
+	MessageTypeUnchoke       = peerwire.TypeUnchoke
+	MessageTypeInterested    = peerwire.TypeInterested
+	MessageTypeNotInterested = peerwire.TypeNotInterested
+	MessageTypeHave          = peerwire.TypeHave
+	MessageTypeBitfield      = peerwire.TypeBitfield
+	MessageTypeRequest       = peerwire.TypeRequest
+	MessageTypePiece         = peerwire.TypePiece
+	MessageTypeCancel        = peerwire.TypeCancel
+	MessageTypePort          = peerwire.TypePort
 )
 
 // Message represents a parsed peer message
@@ -27,151 +30,65 @@ type Message struct {
 	Payload []byte
 }
 
-// readMessage reads a complete peer message from the connection
+// readMessage reads a complete peer message from the connection, skipping
+// keep-alives.
 func readMessage(conn io.Reader) (*Message, error) {
-	// Read message length (4 bytes)
-	lengthBuf := make([]byte, 4)
-	if _, err := io.ReadFull(conn, lengthBuf); err != nil {
-		return nil, fmt.Errorf("failed to read message length: %w", err)
-	}
-	length := binary.BigEndian.Uint32(lengthBuf)
-
-	// Read message type (1 byte)
-	typeBuf := make([]byte, 1)
-	if _, err := io.ReadFull(conn, typeBuf); err != nil {
-		return nil, fmt.Errorf("failed to read message type: %w", err)
+	msgType, payload, err := peerwire.NewReader(conn).ReadRaw()
+	if err != nil {
+		return nil, err
 	}
-	msgType := MessageType(typeBuf[0])
-
-	// Read payload if any
-	var payload []byte
-	if length > 1 {
-		payload = make([]byte, length-1)
-		if _, err := io.ReadFull(conn, payload); err != nil {
-			return nil, fmt.Errorf("failed to read message payload: %w", err)
-		}
-	}
-
 	return &Message{
 		Type:    msgType,
 		Payload: payload,
 	}, nil
 }
 
-// writeMessage writes a complete peer message to the connection
-func writeMessage(conn io.Writer, msgType MessageType, payload []byte) error {
-	// Calculate total message length (1 byte for type + payload length)
-	length := uint32(1 + len(payload))
-
-	// Write length prefix (4 bytes)
-	lengthBuf := []byte{
-		byte(length >> 24),
-		byte(length >> 16),
-		byte(length >> 8),
-		byte(length),
-	}
-	if _, err := conn.Write(lengthBuf); err != nil {
-		return fmt.Errorf("failed to write message length: %w", err)
-	}
-
-	// Write message type (1 byte)
-	if _, err := conn.Write([]byte{byte(msgType)}); err != nil {
-		return fmt.Errorf("failed to write message type: %w", err)
-	}
-
-	// Write payload if any
-	if len(payload) > 0 {
-		if _, err := conn.Write(payload); err != nil {
-			return fmt.Errorf("failed to write message payload: %w", err)
-		}
-	}
-
-	return nil
-}
-
-// handlePieceMessage handles a piece message by writing its data to the writer
-func handlePieceMessage(msg *Message, writer io.Writer) error {
-	if len(msg.Payload) < 8 {
-		return fmt.Errorf("piece message payload too short: %d bytes", len(msg.Payload))
-	}
-
-	// Extract piece index and block offset
-	// pieceIndex := binary.BigEndian.Uint32(msg.Payload[0:4])
-	// blockOffset := binary.BigEndian.Uint32(msg.Payload[4:8])
-
-	// Get the actual data (everything after the first 8 bytes)
-	data := msg.Payload[8:]
-	if _, err := writer.Write(data); err != nil {
-		return fmt.Errorf("failed to write piece data: %w", err)
-	}
-
-	return nil
-}
-
-// hasPiece checks if a peer has a specific piece based on their bitfield message
-func hasPiece(bitfield []byte, pieceIndex int) bool {
-	if pieceIndex >= len(bitfield)*8 {
-		return false
-	}
-	byteIndex := pieceIndex / 8
-	bitIndex := 7 - (pieceIndex % 8)
-	return (bitfield[byteIndex] & (1 << bitIndex)) != 0
-}
-
 // handleBitfieldMessage processes a bitfield message
 func handleBitfieldMessage(msg *Message, pieceIndex int) error {
-	if !hasPiece(msg.Payload, pieceIndex) {
+	if !peerwire.Bitfield(msg.Payload).Has(pieceIndex) {
 		return fmt.Errorf("peer does not have piece %d", pieceIndex)
 	}
 	return nil
 }
 
-// sendRequest sends a request message for a specific block
-func sendRequest(conn io.Writer, pieceIndex, blockOffset, blockLength uint32) error {
-	requestPayload := make([]byte, 12)
-	binary.BigEndian.PutUint32(requestPayload[0:4], pieceIndex)
-	binary.BigEndian.PutUint32(requestPayload[4:8], blockOffset)
-	binary.BigEndian.PutUint32(requestPayload[8:12], blockLength)
-	return writeMessage(conn, MessageTypeRequest, requestPayload)
-}
-
-// handleUnchokeMessage processes an unchoke message and requests blocks
-func handleUnchokeMessage(conn io.ReadWriter, writer io.Writer, metadata *Metadata, pieceIndex int) error {
-	blockSize := uint32(16 * 1024) // 16KB blocks
+// handleUnchokeMessage processes an unchoke message and downloads the piece,
+// keeping up to the request depth of ext and maxRequests in flight. An
+// allowed fast piece is requested even while choked, and a choke does not end
+// its download: the peer rejects the requests it will not answer instead.
+// Fast Extension messages are only accepted if fast was negotiated.
+func handleUnchokeMessage(conn io.ReadWriter, writer io.Writer, metadata *Metadata, pieceIndex int, fast, allowedFast bool, ext *Extensions, maxRequests int) error {
 	pieceLength := uint32(metadata.PieceLength)
 
-	// Calculate the actual length of this piece
-	startOffset := uint32(pieceIndex) * pieceLength
-	remainingFileLength := uint32(metadata.Length) - startOffset
+	// Calculate the actual length of this piece. Offsets are 64-bit so that
+	// torrents larger than 4 GiB work.
+	startOffset := int64(pieceIndex) * int64(pieceLength)
+	remainingFileLength := metadata.Length - startOffset
 	actualPieceLength := pieceLength
-	if remainingFileLength < pieceLength {
-		actualPieceLength = remainingFileLength
+	if remainingFileLength < int64(pieceLength) {
+		actualPieceLength = uint32(remainingFileLength)
 	}
 
-	numBlocks := (actualPieceLength + blockSize - 1) / blockSize // Ceiling division
-
-	for blockIndex := uint32(0); blockIndex < numBlocks; blockIndex++ {
-		blockOffset := blockIndex * blockSize
-		remainingBytes := actualPieceLength - blockOffset
-		remainingBytes = uint32(min(int(remainingBytes), int(blockSize)))
-
-		// Send request message
-		if err := sendRequest(conn, uint32(pieceIndex), blockOffset, remainingBytes); err != nil {
+	p := newPipeline(pieceIndex, actualPieceLength)
+	requests := peerwire.NewWriter(conn)
+	for !p.done() {
+		// The depth is worked out again each time, as the peer's extension
+		// handshake may arrive during the download.
+		for _, request := range p.requests(requestDepth(ext, maxRequests)) {
+			requests.WriteMessage(request)
+		}
+		if err := requests.Flush(); err != nil {
 			return fmt.Errorf("failed to send request: %w", err)
 		}
 
-		// Read piece message
-		pieceMsg, err := readMessage(conn)
+		pieceMsg, err := readPieceMessage(conn, fast, allowedFast, ext)
 		if err != nil {
-			return fmt.Errorf("failed to read piece message: %w", err)
+			return err
 		}
-
-		if pieceMsg.Type != MessageTypePiece {
-			return fmt.Errorf("expected piece message (type 7), got type %d", pieceMsg.Type)
+		piece, err := peerwire.Decode(pieceMsg.Type, pieceMsg.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to handle piece message: %w", err)
 		}
-
-		// Handle piece message
-		if err := handlePieceMessage(pieceMsg, writer); err != nil {
+		if err := p.receive(piece.(peerwire.Piece), writer); err != nil {
 			return fmt.Errorf("failed to handle piece message: %w", err)
 		}
 	}
@@ -179,36 +96,170 @@ func handleUnchokeMessage(conn io.ReadWriter, writer io.Writer, metadata *Metada
 	return nil
 }
 
-// DownloadPiece handles downloading a specific piece from a peer using the peer protocol
+// readPieceMessage reads messages until a piece message arrives, skipping
+// those that do not affect the outstanding request. Extended messages are
+// passed to ext, if it is not nil.
+func readPieceMessage(conn io.Reader, fast, allowedFast bool, ext *Extensions) (*Message, error) {
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read piece message: %w", err)
+		}
+		if err := checkFastMessage(msg, fast); err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case MessageTypePiece:
+			return msg, nil
+		case MessageTypeRejectRequest:
+			rejected, err := parseRejectRequest(msg)
+			if err != nil {
+				return nil, err
+			}
+			return nil, rejected
+		case MessageTypeChoke:
+			if !allowedFast {
+				return nil, fmt.Errorf("peer choked us")
+			}
+		case MessageTypeExtended:
+			if err := handleExtendedMessage(msg, ext); err != nil {
+				return nil, err
+			}
+		case MessageTypeHave, MessageTypeSuggestPiece, MessageTypeAllowedFast, MessageTypeHaveAll, MessageTypeHaveNone, MessageTypeUnchoke, MessageTypePort:
+			// Not related to the outstanding request
+		default:
+			return nil, fmt.Errorf("expected piece message (type 7), got type %d", msg.Type)
+		}
+	}
+}
+
+// handleExtendedMessage passes an extended message to ext, or ignores it if
+// ext is nil.
+func handleExtendedMessage(msg *Message, ext *Extensions) error {
+	if ext == nil {
+		return nil
+	}
+	return ext.Handle(msg.Payload)
+}
+
+// DownloadPiece handles downloading a specific piece from a peer using the peer protocol.
+// The piece is assembled and checked against its hash before it is written,
+// so writer never receives unverified data; a piece that fails the check
+// gives a *PieceHashError. The Fast Extension is taken not to have been
+// negotiated, so its messages are errors.
 func DownloadPiece(conn io.ReadWriter, writer io.Writer, metadata *Metadata, pieceIndex int) error {
+	return downloadPiece(conn, writer, metadata, pieceIndex, false, nil, 0)
+}
+
+// downloadPiece is DownloadPiece, accepting Fast Extension messages if fast
+// was negotiated, passing extended messages to ext if it is not nil, and
+// keeping no more than maxRequests requests in flight if it is positive.
+func downloadPiece(conn io.ReadWriter, writer io.Writer, metadata *Metadata, pieceIndex int, fast bool, ext *Extensions, maxRequests int) error {
+	if pieceIndex < 0 || pieceIndex >= len(metadata.PieceHashes) {
+		return fmt.Errorf("invalid piece index %d: the torrent has %d pieces", pieceIndex, len(metadata.PieceHashes))
+	}
+
+	var piece bytes.Buffer
+	if err := receivePiece(conn, &piece, metadata, pieceIndex, fast, ext, maxRequests); err != nil {
+		return err
+	}
+	if err := VerifyPiece(metadata, pieceIndex, piece.Bytes()); err != nil {
+		return err
+	}
+	if _, err := writer.Write(piece.Bytes()); err != nil {
+		return fmt.Errorf("failed to write piece data: %w", err)
+	}
+	return nil
+}
+
+// receivePiece downloads a piece into writer without verifying it. A peer
+// that said it has no pieces may still announce ours, so the piece is only
+// requested once it has.
+func receivePiece(conn io.ReadWriter, writer io.Writer, metadata *Metadata, pieceIndex int, fast bool, ext *Extensions, maxRequests int) error {
 	// Send interested message
-	if err := writeMessage(conn, MessageTypeInterested, nil); err != nil {
+	if err := peerwire.WriteMessages(conn, peerwire.Interested{}); err != nil {
 		return fmt.Errorf("failed to send interested message: %w", err)
 	}
 
 	// Read messages until we get the piece we want
+	hasPiece := true
+	unchoked, allowedFast := false, false
 	for {
 		msg, err := readMessage(conn)
 		if err != nil {
 			return fmt.Errorf("failed to read message: %w", err)
 		}
+		if err := checkFastMessage(msg, fast); err != nil {
+			return err
+		}
 
 		switch msg.Type {
 		case MessageTypeBitfield:
 			if err := handleBitfieldMessage(msg, pieceIndex); err != nil {
 				return err
 			}
+		case MessageTypeHaveAll:
+			// The peer has every piece, including ours
+			hasPiece = true
+		case MessageTypeHaveNone:
+			// Wait for the peer to announce our piece
+			hasPiece = false
 		case MessageTypeHave:
+			index, err := parseIndex(msg)
+			if err != nil {
+				return err
+			}
+			if int(index) == pieceIndex && !hasPiece {
+				hasPiece = true
+				if unchoked || allowedFast {
+					return handleUnchokeMessage(conn, writer, metadata, pieceIndex, fast, !unchoked, ext, maxRequests)
+				}
+			}
+		case MessageTypeSuggestPiece, MessageTypePort:
 			// Just continue the loop, waiting for unchoke
 			continue
+		case MessageTypeExtended:
+			if err := handleExtendedMessage(msg, ext); err != nil {
+				return err
+			}
+		case MessageTypeAllowedFast:
+			index, err := parseIndex(msg)
+			if err != nil {
+				return err
+			}
+			if int(index) == pieceIndex {
+				allowedFast = true
+				if hasPiece {
+					return handleUnchokeMessage(conn, writer, metadata, pieceIndex, fast, true, ext, maxRequests)
+				}
+			}
 		case MessageTypeUnchoke:
-			return handleUnchokeMessage(conn, writer, metadata, pieceIndex)
+			unchoked = true
+			if hasPiece {
+				return handleUnchokeMessage(conn, writer, metadata, pieceIndex, fast, false, ext, maxRequests)
+			}
 		case MessageTypeChoke:
 			return fmt.Errorf("peer choked us")
 		case MessageTypePiece:
 			return fmt.Errorf("unexpected piece message received")
+		case MessageTypeRejectRequest:
+			return fmt.Errorf("unexpected reject request message received")
 		default:
 			return fmt.Errorf("invalid message type: %d", msg.Type)
 		}
 	}
 }
+
+// checkFastMessage returns an error for a Fast Extension message from a
+// peer that did not negotiate the extension, which BEP 6 requires closing
+// the connection for.
+func checkFastMessage(msg *Message, fast bool) error {
+	switch msg.Type {
+	case MessageTypeSuggestPiece, MessageTypeHaveAll, MessageTypeHaveNone, MessageTypeRejectRequest, MessageTypeAllowedFast:
+		if !fast {
+			return fmt.Errorf("peer sent Fast Extension message type %d without negotiating it", msg.Type)
+		}
+	}
+	return nil
+}
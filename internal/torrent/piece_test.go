@@ -15,6 +15,9 @@ func TestDownloadPiece(t *testing.T) {
 	// Create a buffer to capture written data
 	var outputBuffer bytes.Buffer
 
+	// A single block of piece data
+	block := bytes.Repeat([]byte{0x01}, 16384)
+
 	// Create test metadata
 	metadata := &Metadata{
 		InfoHash: [20]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A,
@@ -22,7 +25,7 @@ func TestDownloadPiece(t *testing.T) {
 		PieceLength: 16384, // Match the block size used in the request message
 		Length:      16384, // One piece worth of data
 		PieceHashes: []string{
-			"0123456789abcdef0123456789abcdef01234567", // Hash for piece 0
+			pieceHash(block), // Hash for piece 0
 		},
 	}
 
@@ -43,12 +46,12 @@ func TestDownloadPiece(t *testing.T) {
 		0x01, // message type (unchoke)
 
 		// Piece message
-		0x00, 0x00, 0x00, 0x0A, // length prefix (10 bytes)
+		0x00, 0x00, 0x40, 0x09, // length prefix (16393 bytes)
 		0x07,                   // message type (piece)
 		0x00, 0x00, 0x00, 0x00, // piece index
 		0x00, 0x00, 0x00, 0x00, // block offset
-		0x01, // data (1 byte)
 	}
+	peerMessages = append(peerMessages, block...) // data (16384 bytes)
 	mockConn.SetReadData(peerMessages)
 
 	expectedInput := []byte{
@@ -78,7 +81,7 @@ func TestDownloadPiece(t *testing.T) {
 	}
 
 	// Verify the piece data was written to the output buffer
-	expectedOutput := []byte{0x01} // The data from the piece message
+	expectedOutput := block // The data from the piece message
 	if !bytes.Equal(outputBuffer.Bytes(), expectedOutput) {
 		t.Errorf("Expected output buffer to contain %v, got %v", expectedOutput, outputBuffer.Bytes())
 	}
@@ -95,9 +98,6 @@ func TestPieceBlockRequesting(t *testing.T) {
 			0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10, 0x11, 0x12, 0x13, 0x14},
 		PieceLength: 32768, // 32KB piece
 		Length:      32768,
-		PieceHashes: []string{
-			"0123456789abcdef0123456789abcdef01234567", // Hash for piece 0
-		},
 	}
 
 	// Create the piece message data (16384 bytes for each block)
@@ -108,6 +108,7 @@ func TestPieceBlockRequesting(t *testing.T) {
 		firstBlockData[i] = 0x01
 		secondBlockData[i] = 0x02
 	}
+	metadata.PieceHashes = []string{pieceHash(firstBlockData, secondBlockData)}
 
 	// Set up peer messages:
 	// 1. Bitfield message (has piece 0)
@@ -208,6 +209,9 @@ func TestDownloadPiece1(t *testing.T) {
 	// Create a buffer to capture written data
 	var outputBuffer bytes.Buffer
 
+	// A single block of piece data
+	block := bytes.Repeat([]byte{0x01}, 16384)
+
 	// Create test metadata
 	metadata := &Metadata{
 		InfoHash: [20]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A,
@@ -215,8 +219,8 @@ func TestDownloadPiece1(t *testing.T) {
 		PieceLength: 16384, // Match the block size used in the request message
 		Length:      32768, // Two pieces worth of data
 		PieceHashes: []string{
-			"0123456789abcdef0123456789abcdef01234567", // Hash for piece 0
-			"abcdef0123456789abcdef0123456789abcdef01", // Hash for piece 1
+			pieceHash([]byte{0x00}), // Hash for piece 0
+			pieceHash(block),        // Hash for piece 1
 		},
 	}
 
@@ -237,12 +241,12 @@ func TestDownloadPiece1(t *testing.T) {
 		0x01, // message type (unchoke)
 
 		// Piece message
-		0x00, 0x00, 0x00, 0x0A, // length prefix (10 bytes)
+		0x00, 0x00, 0x40, 0x09, // length prefix (16393 bytes)
 		0x07,                   // message type (piece)
 		0x00, 0x00, 0x00, 0x01, // piece index (1)
 		0x00, 0x00, 0x00, 0x00, // block offset
-		0x01, // data (1 byte)
 	}
+	peerMessages = append(peerMessages, block...) // data (16384 bytes)
 	mockConn.SetReadData(peerMessages)
 
 	expectedInput := []byte{
@@ -272,8 +276,123 @@ func TestDownloadPiece1(t *testing.T) {
 	}
 
 	// Verify the piece data was written to the output buffer
-	expectedOutput := []byte{0x01} // The data from the piece message
+	expectedOutput := block // The data from the piece message
 	if !bytes.Equal(outputBuffer.Bytes(), expectedOutput) {
 		t.Errorf("Expected output buffer to contain %v, got %v", expectedOutput, outputBuffer.Bytes())
 	}
 }
+
+func TestDownloadPieceSkipsExtendedMessages(t *testing.T) {
+	mockConn := testutil.NewMockTCPConn()
+	defer mockConn.Close()
+
+	var outputBuffer bytes.Buffer
+	metadata := &Metadata{
+		PieceLength: 16384,
+		Length:      1,
+		PieceHashes: []string{pieceHash([]byte{0x01})},
+	}
+
+	peerMessages := []byte{
+		// Extension handshake
+		0x00, 0x00, 0x00, 0x04, // length prefix (4 bytes)
+		0x14,     // message type (extended)
+		0x00,     // extended message ID (handshake)
+		'd', 'e', // empty dictionary
+
+		// Unchoke message
+		0x00, 0x00, 0x00, 0x01, // length prefix (1 byte)
+		0x01, // message type (unchoke)
+
+		// Extended message for some extension
+		0x00, 0x00, 0x00, 0x02, // length prefix (2 bytes)
+		0x14, // message type (extended)
+		0x03, // extended message ID
+
+		// Piece message
+		0x00, 0x00, 0x00, 0x0A, // length prefix (10 bytes)
+		0x07,                   // message type (piece)
+		0x00, 0x00, 0x00, 0x00, // piece index
+		0x00, 0x00, 0x00, 0x00, // block offset
+		0x01, // data (1 byte)
+	}
+	mockConn.SetReadData(peerMessages)
+
+	if err := DownloadPiece(mockConn, &outputBuffer, metadata, 0); err != nil {
+		t.Fatalf("Peer message handling failed: %v", err)
+	}
+	if !bytes.Equal(outputBuffer.Bytes(), []byte{0x01}) {
+		t.Errorf("Expected output buffer to contain %v, got %v", []byte{0x01}, outputBuffer.Bytes())
+	}
+}
+
+func TestDownloadPieceKeepAliveAndPort(t *testing.T) {
+	mockConn := testutil.NewMockTCPConn()
+	defer mockConn.Close()
+
+	var outputBuffer bytes.Buffer
+	metadata := &Metadata{
+		PieceLength: 16384,
+		Length:      1,
+		PieceHashes: []string{pieceHash([]byte{0x01})},
+	}
+
+	peerMessages := []byte{
+		0x00, 0x00, 0x00, 0x00, // keep-alive
+		0x00, 0x00, 0x00, 0x03, 0x09, 0x1a, 0xe1, // port 6881
+		0x00, 0x00, 0x00, 0x01, 0x01, // unchoke
+		0x00, 0x00, 0x00, 0x00, // keep-alive
+		0x00, 0x00, 0x00, 0x0A, 0x07, // piece
+		0x00, 0x00, 0x00, 0x00, // piece index
+		0x00, 0x00, 0x00, 0x00, // block offset
+		0x01, // data (1 byte)
+	}
+	mockConn.SetReadData(peerMessages)
+
+	if err := DownloadPiece(mockConn, &outputBuffer, metadata, 0); err != nil {
+		t.Fatalf("Peer message handling failed: %v", err)
+	}
+	if !bytes.Equal(outputBuffer.Bytes(), []byte{0x01}) {
+		t.Errorf("Expected output buffer to contain %v, got %v", []byte{0x01}, outputBuffer.Bytes())
+	}
+}
+
+func TestDownloadPieceWrongBlock(t *testing.T) {
+	mockConn := testutil.NewMockTCPConn()
+	defer mockConn.Close()
+
+	metadata := &Metadata{PieceLength: 16384, Length: 16384 * 2, PieceHashes: make([]string, 2)}
+	mockConn.SetReadData([]byte{
+		0x00, 0x00, 0x00, 0x01, 0x01, // unchoke
+		0x00, 0x00, 0x00, 0x0A, 0x07, // piece
+		0x00, 0x00, 0x00, 0x01, // piece index 1, but 0 was requested
+		0x00, 0x00, 0x00, 0x00, // block offset
+		0x01, // data (1 byte)
+	})
+
+	var outputBuffer bytes.Buffer
+	if err := DownloadPiece(mockConn, &outputBuffer, metadata, 0); err == nil {
+		t.Errorf("expected error for a block of another piece")
+	}
+	if outputBuffer.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %v", outputBuffer.Bytes())
+	}
+}
+
+func TestDownloadPieceInvalidIndex(t *testing.T) {
+	metadata := &Metadata{PieceLength: 16384, Length: 16384 * 2, PieceHashes: make([]string, 2)}
+
+	for _, pieceIndex := range []int{-1, 2, 100} {
+		mockConn := testutil.NewMockTCPConn()
+		mockConn.SetReadData([]byte{0x00, 0x00, 0x00, 0x01, 0x01}) // unchoke
+
+		var outputBuffer bytes.Buffer
+		if err := DownloadPiece(mockConn, &outputBuffer, metadata, pieceIndex); err == nil {
+			t.Errorf("DownloadPiece(%d) succeeded, want an error", pieceIndex)
+		}
+		if written := mockConn.GetWrittenData(); len(written) != 0 {
+			t.Errorf("DownloadPiece(%d) sent %v, want nothing sent to the peer", pieceIndex, written)
+		}
+		mockConn.Close()
+	}
+}
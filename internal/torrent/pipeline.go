@@ -0,0 +1,96 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+// DefaultMaxRequests is how many block requests are kept in flight to a peer
+// that does not say in its extension handshake how many it allows.
+const DefaultMaxRequests = 16
+
+// requestDepth returns how many block requests to keep in flight to a peer:
+// the reqq of its extension handshake, or DefaultMaxRequests, but no more
+// than limit if limit is positive.
+func requestDepth(ext *Extensions, limit int) int {
+	depth := DefaultMaxRequests
+	if ext != nil {
+		if peer := ext.Peer(); peer != nil && peer.Reqq > 0 {
+			depth = peer.Reqq
+		}
+	}
+	if limit > 0 {
+		depth = min(depth, limit)
+	}
+	return depth
+}
+
+// A pipeline downloads the blocks of a piece, keeping several requests in
+// flight. Blocks may arrive in any order; they are written out in order.
+type pipeline struct {
+	index   uint32
+	length  uint32
+	next    uint32            // Offset of the next block to request.
+	pending map[uint32]bool   // Offsets of the blocks in flight.
+	blocks  map[uint32][]byte // Blocks received ahead of written, by offset.
+	written uint32            // Offset up to which the piece is written.
+}
+
+func newPipeline(index int, length uint32) *pipeline {
+	return &pipeline{
+		index:   uint32(index),
+		length:  length,
+		pending: make(map[uint32]bool),
+		blocks:  make(map[uint32][]byte),
+	}
+}
+
+// done reports whether every block has been written.
+func (p *pipeline) done() bool {
+	return p.written == p.length
+}
+
+// requests returns the requests to send so that depth are in flight.
+func (p *pipeline) requests(depth int) []peerwire.Request {
+	var requests []peerwire.Request
+	for p.next < p.length && len(p.pending) < depth {
+		request := peerwire.Request{Index: p.index, Begin: p.next, Length: p.blockLength(p.next)}
+		requests = append(requests, request)
+		p.pending[request.Begin] = true
+		p.next += request.Length
+	}
+	return requests
+}
+
+// receive matches a block to its request by index, offset and length, and
+// writes out the blocks that are now in order.
+func (p *pipeline) receive(piece peerwire.Piece, writer io.Writer) error {
+	if piece.Index != p.index || !p.pending[piece.Begin] {
+		return fmt.Errorf("received unrequested block at offset %d of piece %d", piece.Begin, piece.Index)
+	}
+	if want := p.blockLength(piece.Begin); uint32(len(piece.Block)) != want {
+		return fmt.Errorf("received %d bytes for the %d-byte block at offset %d of piece %d", len(piece.Block), want, piece.Begin, piece.Index)
+	}
+	delete(p.pending, piece.Begin)
+	p.blocks[piece.Begin] = piece.Block
+
+	for {
+		block, ok := p.blocks[p.written]
+		if !ok {
+			return nil
+		}
+		if _, err := writer.Write(block); err != nil {
+			return fmt.Errorf("failed to write piece data: %w", err)
+		}
+		delete(p.blocks, p.written)
+		p.written += uint32(len(block))
+	}
+}
+
+// blockLength returns the length of the block at offset: blockSize, except
+// for the last block of the piece.
+func (p *pipeline) blockLength(offset uint32) uint32 {
+	return min(blockSize, p.length-offset)
+}
@@ -0,0 +1,211 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+func TestPipeline(t *testing.T) {
+	p := newPipeline(3, 2*blockSize+100)
+	var output bytes.Buffer
+	a := bytes.Repeat([]byte("a"), blockSize)
+	b := bytes.Repeat([]byte("b"), blockSize)
+	c := bytes.Repeat([]byte("c"), 100)
+
+	want := []peerwire.Request{{Index: 3, Begin: 0, Length: blockSize}, {Index: 3, Begin: blockSize, Length: blockSize}}
+	if got := p.requests(2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("requests(2) = %v, want %v", got, want)
+	}
+	if got := p.requests(2); got != nil {
+		t.Errorf("requests(2) with 2 in flight = %v, want none", got)
+	}
+
+	// A block that arrives early is held until the blocks before it.
+	if err := p.receive(peerwire.Piece{Index: 3, Begin: blockSize, Block: b}, &output); err != nil {
+		t.Fatalf("receive() error = %v", err)
+	}
+	if output.Len() != 0 {
+		t.Errorf("receive() wrote %d bytes out of order", output.Len())
+	}
+	if got, want := p.requests(2), []peerwire.Request{{Index: 3, Begin: 2 * blockSize, Length: 100}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests(2) = %v, want %v", got, want)
+	}
+	if err := p.receive(peerwire.Piece{Index: 3, Begin: 0, Block: a}, &output); err != nil {
+		t.Fatalf("receive() error = %v", err)
+	}
+	if want := slices.Concat(a, b); !bytes.Equal(output.Bytes(), want) {
+		t.Errorf("output has %d bytes, want the first two blocks", output.Len())
+	}
+
+	for _, tt := range []struct {
+		name  string
+		piece peerwire.Piece
+	}{
+		{name: "already received", piece: peerwire.Piece{Index: 3, Begin: 0, Block: a}},
+		{name: "another piece", piece: peerwire.Piece{Index: 4, Begin: 2 * blockSize, Block: c}},
+		{name: "not a block offset", piece: peerwire.Piece{Index: 3, Begin: 1, Block: c}},
+		{name: "short block", piece: peerwire.Piece{Index: 3, Begin: 2 * blockSize, Block: c[:99]}},
+		{name: "long block", piece: peerwire.Piece{Index: 3, Begin: 2 * blockSize, Block: append(c, 'c')}},
+	} {
+		if err := p.receive(tt.piece, &output); err == nil {
+			t.Errorf("receive() with %s expected error", tt.name)
+		}
+	}
+
+	if p.done() {
+		t.Errorf("done() = true before the last block")
+	}
+	if err := p.receive(peerwire.Piece{Index: 3, Begin: 2 * blockSize, Block: c}, &output); err != nil {
+		t.Fatalf("receive() error = %v", err)
+	}
+	if want := slices.Concat(a, b, c); !p.done() || !bytes.Equal(output.Bytes(), want) {
+		t.Errorf("done() = %v, output has %d bytes, want true, the whole piece", p.done(), output.Len())
+	}
+}
+
+func TestRequestDepth(t *testing.T) {
+	withReqq := func(reqq int) *Extensions {
+		ext := NewExtensions(&bytes.Buffer{})
+		payload, _ := bencode.Marshal(ExtendedHandshake{Reqq: reqq})
+		if err := ext.Handle(append([]byte{extendedHandshakeID}, payload...)); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+		return ext
+	}
+
+	tests := []struct {
+		name  string
+		ext   *Extensions
+		limit int
+		want  int
+	}{
+		{name: "no extensions", want: DefaultMaxRequests},
+		{name: "no handshake yet", ext: NewExtensions(&bytes.Buffer{}), want: DefaultMaxRequests},
+		{name: "no reqq", ext: withReqq(0), want: DefaultMaxRequests},
+		{name: "lower reqq", ext: withReqq(4), want: 4},
+		{name: "higher reqq", ext: withReqq(250), want: 250},
+		{name: "limited", ext: withReqq(250), limit: 32, want: 32},
+		{name: "limited default", limit: 2, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestDepth(tt.ext, tt.limit); got != tt.want {
+				t.Errorf("requestDepth() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakePipelinePeer serves piece over conn, letting requests pile up until
+// depth are in flight, or all that are left, and then answering them in
+// reverse order. It fails if more than depth requests arrive.
+func fakePipelinePeer(conn net.Conn, index uint32, piece []byte, depth int) error {
+	r := peerwire.NewReader(conn)
+	if _, err := r.ReadMessage(); err != nil { // interested
+		return err
+	}
+	if err := peerwire.WriteMessages(conn, peerwire.Unchoke{}); err != nil {
+		return err
+	}
+
+	numBlocks := (len(piece) + blockSize - 1) / blockSize
+	for served := 0; served < numBlocks; {
+		var requests []peerwire.Request
+		for len(requests) < min(depth, numBlocks-served) {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return err
+			}
+			request, ok := msg.(peerwire.Request)
+			if !ok || request.Index != index {
+				return errors.New("expected a request for the piece")
+			}
+			requests = append(requests, request)
+		}
+		conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		if _, err := r.ReadMessage(); !errors.Is(err, os.ErrDeadlineExceeded) {
+			return errors.New("more requests in flight than allowed")
+		}
+		conn.SetReadDeadline(time.Time{})
+
+		var responses []peerwire.Message
+		for i := len(requests) - 1; i >= 0; i-- {
+			request := requests[i]
+			block := piece[request.Begin : request.Begin+request.Length]
+			responses = append(responses, peerwire.Piece{Index: index, Begin: request.Begin, Block: block})
+		}
+		if err := peerwire.WriteMessages(conn, responses...); err != nil {
+			return err
+		}
+		served += len(requests)
+	}
+	return nil
+}
+
+func TestDownloadPiecePipelined(t *testing.T) {
+	piece := make([]byte, 4*blockSize+100)
+	rand.Read(piece)
+	metadata := &Metadata{PieceLength: len(piece), Length: int64(len(piece)) * 2, PieceHashes: []string{"", pieceHash(piece)}}
+
+	tests := []struct {
+		name        string
+		reqq        int
+		maxRequests int
+		depth       int
+	}{
+		{name: "default depth", depth: DefaultMaxRequests},
+		{name: "peer reqq", reqq: 2, depth: 2},
+		{name: "one at a time", reqq: 250, maxRequests: 1, depth: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			served := make(chan error, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					served <- err
+					return
+				}
+				defer conn.Close()
+				served <- fakePipelinePeer(conn, 1, piece, tt.depth)
+			}()
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			ext := NewExtensions(conn)
+			if tt.reqq > 0 {
+				payload, _ := bencode.Marshal(ExtendedHandshake{Reqq: tt.reqq})
+				ext.Handle(append([]byte{extendedHandshakeID}, payload...))
+			}
+
+			var output bytes.Buffer
+			if err := downloadPiece(conn, &output, metadata, 1, false, ext, tt.maxRequests); err != nil {
+				t.Fatalf("downloadPiece() error = %v", err)
+			}
+			if err := <-served; err != nil {
+				t.Fatalf("fake peer: %v", err)
+			}
+			if !bytes.Equal(output.Bytes(), piece) {
+				t.Errorf("downloadPiece() wrote %d bytes that differ from the piece", output.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package torrent
+
+import (
+	"net/netip"
+	"sync"
+)
+
+// PeerFlags describe a peer as advertised in peer exchange (BEP 11).
+type PeerFlags byte
+
+const (
+	PeerPrefersEncryption PeerFlags = 0x01
+	PeerIsSeed            PeerFlags = 0x02
+	PeerSupportsUTP       PeerFlags = 0x04
+	PeerSupportsHolepunch PeerFlags = 0x08
+	PeerIsReachable       PeerFlags = 0x10
+)
+
+// PeerSource records where a peer was learned from.
+type PeerSource byte
+
+const (
+	SourceTracker PeerSource = 1 << iota
+	SourcePEX
+	SourceMagnet
+)
+
+// PoolPeer is a peer in a PeerPool.
+type PoolPeer struct {
+	Addr    netip.AddrPort
+	Flags   PeerFlags
+	Sources PeerSource
+}
+
+// MaxHashFailures is how many pieces from one IP address may fail their hash
+// check before the pool bans the address.
+const MaxHashFailures = 2
+
+// PeerPool is the set of peers known for a swarm, in the order they were
+// learned. It is safe for concurrent use.
+type PeerPool struct {
+	mu       sync.Mutex
+	peers    []PoolPeer
+	index    map[netip.AddrPort]int
+	failures map[netip.Addr]int // Hash failures by IP address.
+}
+
+// NewPeerPool returns an empty pool.
+func NewPeerPool() *PeerPool {
+	return &PeerPool{index: make(map[netip.AddrPort]int), failures: make(map[netip.Addr]int)}
+}
+
+// Add records a peer learned from source, merging its flags with those
+// already known. It reports whether the peer is new to the pool.
+func (p *PeerPool) Add(addr netip.AddrPort, flags PeerFlags, source PeerSource) bool {
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+	if !addr.IsValid() || addr.Port() == 0 {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failures[addr.Addr()] >= MaxHashFailures {
+		return false
+	}
+	if i, ok := p.index[addr]; ok {
+		p.peers[i].Flags |= flags
+		p.peers[i].Sources |= source
+		return false
+	}
+	p.index[addr] = len(p.peers)
+	p.peers = append(p.peers, PoolPeer{Addr: addr, Flags: flags, Sources: source})
+	return true
+}
+
+// Drop forgets that a peer was learned from source, removing it from the
+// pool once no source vouches for it.
+func (p *PeerPool) Drop(addr netip.AddrPort, source PeerSource) {
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i, ok := p.index[addr]
+	if !ok {
+		return
+	}
+	p.peers[i].Sources &^= source
+	if p.peers[i].Sources != 0 {
+		return
+	}
+
+	delete(p.index, addr)
+	p.peers = append(p.peers[:i], p.peers[i+1:]...)
+	for j := i; j < len(p.peers); j++ {
+		p.index[p.peers[j].Addr] = j
+	}
+}
+
+// RecordHashFailure charges the peer at addr with a piece that failed its
+// hash check. Failures are counted by IP address, so that reconnecting from
+// another port does not help. Once an address reaches MaxHashFailures it is
+// banned: its peers leave the pool and are never added again. It reports
+// whether the address is banned.
+func (p *PeerPool) RecordHashFailure(addr netip.AddrPort) bool {
+	ip := addr.Addr().Unmap()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures[ip]++
+	if p.failures[ip] < MaxHashFailures {
+		return false
+	}
+
+	peers := p.peers[:0]
+	clear(p.index)
+	for _, peer := range p.peers {
+		if peer.Addr.Addr() != ip {
+			p.index[peer.Addr] = len(peers)
+			peers = append(peers, peer)
+		}
+	}
+	p.peers = peers
+	return true
+}
+
+// HashFailures returns the number of pieces from the IP address of addr
+// that failed their hash check.
+func (p *PeerPool) HashFailures(addr netip.AddrPort) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failures[addr.Addr().Unmap()]
+}
+
+// Banned reports whether the IP address of addr is banned.
+func (p *PeerPool) Banned(addr netip.AddrPort) bool {
+	return p.HashFailures(addr) >= MaxHashFailures
+}
+
+// Peers returns the peers in the pool.
+func (p *PeerPool) Peers() []PoolPeer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PoolPeer(nil), p.peers...)
+}
+
+// Len returns the number of peers in the pool.
+func (p *PeerPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.peers)
+}
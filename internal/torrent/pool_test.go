@@ -0,0 +1,85 @@
+package torrent
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestPeerPool(t *testing.T) {
+	pool := NewPeerPool()
+	a := netip.MustParseAddrPort("10.0.0.1:6881")
+	b := netip.MustParseAddrPort("[2001:db8::1]:6881")
+
+	if !pool.Add(a, 0, SourceTracker) || !pool.Add(b, PeerIsSeed, SourcePEX) {
+		t.Fatalf("expected new peers to be added")
+	}
+	if pool.Add(netip.MustParseAddrPort("[::ffff:10.0.0.1]:6881"), PeerSupportsUTP, SourcePEX) {
+		t.Errorf("expected an IPv4-mapped address to match its IPv4 peer")
+	}
+	if pool.Add(netip.MustParseAddrPort("10.0.0.2:0"), 0, SourcePEX) || pool.Add(netip.AddrPort{}, 0, SourcePEX) {
+		t.Errorf("expected invalid addresses to be rejected")
+	}
+
+	want := []PoolPeer{
+		{Addr: a, Flags: PeerSupportsUTP, Sources: SourceTracker | SourcePEX},
+		{Addr: b, Flags: PeerIsSeed, Sources: SourcePEX},
+	}
+	if got := pool.Peers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Peers() = %+v, want %+v", got, want)
+	}
+
+	// a is still vouched for by the tracker, b only by peer exchange.
+	pool.Drop(a, SourcePEX)
+	pool.Drop(b, SourcePEX)
+	want = []PoolPeer{{Addr: a, Flags: PeerSupportsUTP, Sources: SourceTracker}}
+	if got := pool.Peers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Peers() after Drop = %+v, want %+v", got, want)
+	}
+
+	pool.Add(b, 0, SourcePEX)
+	pool.Drop(a, SourceTracker)
+	if pool.Len() != 1 || pool.Peers()[0].Addr != b {
+		t.Errorf("expected only %v to remain, got %+v", b, pool.Peers())
+	}
+}
+
+func TestPeerPoolHashFailures(t *testing.T) {
+	pool := NewPeerPool()
+	a := netip.MustParseAddrPort("10.0.0.1:6881")
+	aOtherPort := netip.MustParseAddrPort("10.0.0.1:7000")
+	b := netip.MustParseAddrPort("10.0.0.2:6881")
+	pool.Add(a, 0, SourceTracker)
+	pool.Add(aOtherPort, 0, SourcePEX)
+	pool.Add(b, 0, SourceTracker)
+
+	for i := 1; i < MaxHashFailures; i++ {
+		if pool.RecordHashFailure(a) {
+			t.Fatalf("RecordHashFailure() = true after %d failures, want false", i)
+		}
+	}
+	if pool.Banned(a) || pool.Len() != 3 {
+		t.Errorf("expected %v to stay in the pool below %d failures", a, MaxHashFailures)
+	}
+
+	// Failures count against the IP address, whatever the port.
+	if !pool.RecordHashFailure(netip.MustParseAddrPort("[::ffff:10.0.0.1]:7000")) {
+		t.Errorf("RecordHashFailure() = false at %d failures, want true", MaxHashFailures)
+	}
+	if got := pool.HashFailures(aOtherPort); got != MaxHashFailures {
+		t.Errorf("HashFailures() = %d, want %d", got, MaxHashFailures)
+	}
+	if !pool.Banned(a) || pool.Banned(b) {
+		t.Errorf("Banned() = %v, %v, want true, false", pool.Banned(a), pool.Banned(b))
+	}
+	if want := []PoolPeer{{Addr: b, Sources: SourceTracker}}; !reflect.DeepEqual(pool.Peers(), want) {
+		t.Errorf("Peers() after a ban = %+v, want %+v", pool.Peers(), want)
+	}
+	if pool.Add(a, 0, SourcePEX) {
+		t.Errorf("expected a banned peer not to be added again")
+	}
+	pool.Drop(b, SourceTracker)
+	if pool.Len() != 0 {
+		t.Errorf("expected the index to stay consistent after a ban, got %+v", pool.Peers())
+	}
+}
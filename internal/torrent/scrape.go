@@ -0,0 +1,121 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// ScrapeStats are a tracker's counts for a torrent.
+type ScrapeStats struct {
+	Seeders    int
+	Leechers   int
+	Downloaded int // Number of times the torrent was downloaded.
+}
+
+// A Scraper asks a tracker for the counts of torrents without announcing.
+// HTTPTracker and UDPTracker implement it.
+type Scraper interface {
+	Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeStats, error)
+}
+
+// NewScraper returns the scraper for an announce URL, which for HTTP
+// trackers must have a scrape URL.
+func NewScraper(announce string, httpClient HTTPClient) (Scraper, error) {
+	tracker, err := NewTracker(announce, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tracker.(*HTTPTracker); ok {
+		if _, err := ScrapeURL(announce); err != nil {
+			return nil, err
+		}
+	}
+	return tracker.(Scraper), nil
+}
+
+// ScrapeURL derives the scrape URL of an HTTP tracker from its announce URL
+// by replacing "announce" at the start of the last path component with
+// "scrape" (BEP 48). Trackers whose announce URL does not have that form
+// do not support scraping.
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", fmt.Errorf("invalid tracker URL: %v", err)
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", fmt.Errorf("tracker %s does not support scraping", announce)
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// httpScrapeResponse is the dictionary an HTTP tracker responds to a
+// scrape with. Files are keyed by info hash.
+type httpScrapeResponse struct {
+	FailureReason *string `bencode:"failure reason,omitempty"`
+	Files         map[string]struct {
+		Complete   int `bencode:"complete"`
+		Incomplete int `bencode:"incomplete"`
+		Downloaded int `bencode:"downloaded"`
+	} `bencode:"files"`
+}
+
+// Scrape asks the tracker for the counts of torrents, all in one request.
+// Torrents the tracker does not know are missing from the result.
+func (t *HTTPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeStats, error) {
+	if len(infoHashes) == 0 {
+		return nil, fmt.Errorf("no info hashes to scrape")
+	}
+	scrapeURL, err := ScrapeURL(t.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", scrapeURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	q := request.URL.Query()
+	for _, infoHash := range infoHashes {
+		q.Add("info_hash", string(infoHash[:]))
+	}
+	request.URL.RawQuery = q.Encode()
+
+	response, err := t.Client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	var decoded httpScrapeResponse
+	if err := bencode.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("tracker responded with status %s", response.Status)
+		}
+		return nil, fmt.Errorf("failed to decode response body: %v", err)
+	}
+	if decoded.FailureReason != nil {
+		return nil, &TrackerError{Reason: *decoded.FailureReason}
+	}
+
+	stats := make(map[[20]byte]ScrapeStats, len(decoded.Files))
+	for infoHash, file := range decoded.Files {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("expected scraped info hash to be 20 bytes, but got %d", len(infoHash))
+		}
+		stats[[20]byte([]byte(infoHash))] = ScrapeStats{
+			Seeders:    file.Complete,
+			Leechers:   file.Incomplete,
+			Downloaded: file.Downloaded,
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,80 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/testutil"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		announce string
+		want     string
+		wantErr  bool
+	}{
+		{announce: "http://example.com/announce", want: "http://example.com/scrape"},
+		{announce: "http://example.com/x/announce", want: "http://example.com/x/scrape"},
+		{announce: "http://example.com/announce.php", want: "http://example.com/scrape.php"},
+		{announce: "http://example.com/announce?x2%0644", want: "http://example.com/scrape?x2%0644"},
+		{announce: "http://example.com/x%064announce", wantErr: true},
+		{announce: "http://example.com/a", wantErr: true},
+		{announce: "http://example.com/announce/x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ScrapeURL(tt.announce)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ScrapeURL(%q) error = %v, wantErr %v", tt.announce, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ScrapeURL(%q) = %q, want %q", tt.announce, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPTrackerScrape(t *testing.T) {
+	a, b := [20]byte{'a'}, [20]byte{'b'}
+	body, _ := bencode.Marshal(map[string]any{
+		"files": map[string]any{
+			string(a[:]): map[string]any{"complete": 5, "incomplete": 2, "downloaded": 50},
+		},
+	})
+	client := &testutil.MockHTTPClient{Response: body}
+	tracker := &HTTPTracker{URL: "http://tracker.example/announce?passkey=x", Client: client}
+
+	stats, err := tracker.Scrape(context.Background(), [][20]byte{a, b})
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	if want := map[[20]byte]ScrapeStats{a: {Seeders: 5, Leechers: 2, Downloaded: 50}}; !reflect.DeepEqual(stats, want) {
+		t.Errorf("Scrape() = %v, want %v", stats, want)
+	}
+
+	u := client.Requests.URL
+	if u.Path != "/scrape" || u.Query().Get("passkey") != "x" {
+		t.Errorf("scrape URL = %s, want /scrape with the passkey", u)
+	}
+	if got := u.Query()["info_hash"]; !reflect.DeepEqual(got, []string{string(a[:]), string(b[:])}) {
+		t.Errorf("info_hash parameters = %q, want both info hashes", got)
+	}
+
+	failure, _ := bencode.Marshal(map[string]any{"failure reason": "scrape disabled"})
+	tracker.Client = &testutil.MockHTTPClient{Response: failure}
+	var trackerErr *TrackerError
+	if _, err := tracker.Scrape(context.Background(), [][20]byte{a}); !errors.As(err, &trackerErr) {
+		t.Errorf("Scrape() error = %v, want a *TrackerError", err)
+	}
+}
+
+func TestNewScraper(t *testing.T) {
+	if _, err := NewScraper("http://tracker.example/a", nil); err == nil {
+		t.Errorf("expected an error for an HTTP tracker without a scrape URL")
+	}
+	if s, err := NewScraper("udp://tracker.example:1337", nil); err != nil || s == nil {
+		t.Errorf("NewScraper() = %v, %v, want a UDP scraper", s, err)
+	}
+}
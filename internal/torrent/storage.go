@@ -0,0 +1,158 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileStorage reads and writes the contiguous data of a torrent in its files
+// on disk.
+type FileStorage struct {
+	files   []FileEntry
+	handles []*os.File
+}
+
+// CreateFiles creates the files of a torrent and returns a FileStorage that
+// writes their data. A single-file torrent is written to path itself, while
+// the folder of a multi-file torrent is created inside the directory path.
+// Padding files are not created, and data written to them is discarded.
+func CreateFiles(metadata *Metadata, path string) (*FileStorage, error) {
+	return openFiles(metadata, path, func(name string, f FileEntry) (*os.File, error) {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		handle, err := os.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file: %w", err)
+		}
+		if err := handle.Truncate(f.Length); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("failed to size file %s: %w", name, err)
+		}
+		return handle, nil
+	})
+}
+
+// OpenFiles opens the existing files of a torrent, laid out as CreateFiles
+// lays them out, and returns a FileStorage that reads their data. Each file
+// must have the length the torrent gives it.
+func OpenFiles(metadata *Metadata, path string) (*FileStorage, error) {
+	return openFiles(metadata, path, func(name string, f FileEntry) (*os.File, error) {
+		handle, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		stat, err := handle.Stat()
+		if err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("failed to stat file %s: %w", name, err)
+		}
+		if stat.Size() != f.Length {
+			handle.Close()
+			return nil, fmt.Errorf("expected file %s to be %d bytes, but it is %d", name, f.Length, stat.Size())
+		}
+		return handle, nil
+	})
+}
+
+// openFiles opens a handle for each file of the torrent other than padding.
+func openFiles(metadata *Metadata, path string, open func(name string, f FileEntry) (*os.File, error)) (*FileStorage, error) {
+	storage := &FileStorage{files: metadata.Files}
+
+	for _, f := range metadata.Files {
+		if f.Padding {
+			storage.handles = append(storage.handles, nil)
+			continue
+		}
+
+		name := path
+		if metadata.IsMultiFile() {
+			name = filepath.Join(append([]string{path}, f.Path...)...)
+		}
+
+		handle, err := open(name, f)
+		if err != nil {
+			storage.Close()
+			return nil, err
+		}
+		storage.handles = append(storage.handles, handle)
+	}
+
+	return storage, nil
+}
+
+// ReadAt reads len(p) bytes at offset off within the torrent's data, joining
+// the files it spans. Padding files read as zeros.
+func (s *FileStorage) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for i, f := range s.files {
+		if len(p) == 0 {
+			break
+		}
+		if off >= f.Offset+f.Length {
+			continue
+		}
+
+		n := min(int64(len(p)), f.Offset+f.Length-off)
+		if handle := s.handles[i]; handle != nil {
+			if _, err := handle.ReadAt(p[:n], off-f.Offset); err != nil {
+				return read, fmt.Errorf("failed to read %s: %w", filepath.Join(f.Path...), err)
+			}
+		} else {
+			clear(p[:n])
+		}
+		read += int(n)
+		p = p[n:]
+		off += n
+	}
+
+	if len(p) > 0 {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// WriteAt writes p at offset off within the torrent's data, splitting it
+// across the files it spans.
+func (s *FileStorage) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+	for i, f := range s.files {
+		if len(p) == 0 {
+			break
+		}
+		if off >= f.Offset+f.Length {
+			continue
+		}
+
+		n := min(int64(len(p)), f.Offset+f.Length-off)
+		if handle := s.handles[i]; handle != nil {
+			if _, err := handle.WriteAt(p[:n], off-f.Offset); err != nil {
+				return written, fmt.Errorf("failed to write %s: %w", filepath.Join(f.Path...), err)
+			}
+		}
+		written += int(n)
+		p = p[n:]
+		off += n
+	}
+
+	if len(p) > 0 {
+		return written, fmt.Errorf("write of %d bytes beyond end of torrent data", len(p))
+	}
+	return written, nil
+}
+
+// Close closes all of the files.
+func (s *FileStorage) Close() error {
+	var errs []error
+	for _, handle := range s.handles {
+		if handle == nil {
+			continue
+		}
+		errs = append(errs, handle.Close())
+	}
+	return errors.Join(errs...)
+}
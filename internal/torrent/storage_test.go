@@ -0,0 +1,150 @@
+package torrent
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorageMultiFile(t *testing.T) {
+	dir := t.TempDir()
+	metadata := &Metadata{
+		Name:   "folder",
+		Length: 10,
+		Files: []FileEntry{
+			{Path: []string{"folder", "a.txt"}, Length: 3, Offset: 0},
+			{Path: []string{"folder", "empty"}, Length: 0, Offset: 3},
+			{Path: []string{"folder", "sub", "b.txt"}, Length: 7, Offset: 3},
+		},
+	}
+
+	storage, err := CreateFiles(metadata, dir)
+	if err != nil {
+		t.Fatalf("CreateFiles failed: %v", err)
+	}
+
+	// Write the second half first and span the file boundary.
+	if _, err := storage.WriteAt([]byte("efghij"), 4); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if _, err := storage.WriteAt([]byte("abcd"), 0); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if _, err := storage.WriteAt([]byte("x"), 10); err == nil {
+		t.Errorf("expected error writing beyond the end of the torrent")
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	want := map[string]string{
+		"folder/a.txt":     "abc",
+		"folder/empty":     "",
+		"folder/sub/b.txt": "defghij",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(got) != content {
+			t.Errorf("expected %s to contain %q, got %q", name, content, got)
+		}
+	}
+}
+
+func TestFileStorageSingleFile(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output.txt")
+	metadata := &Metadata{
+		Name:   "sample.txt",
+		Length: 5,
+		Files:  []FileEntry{{Path: []string{"sample.txt"}, Length: 5}},
+	}
+
+	storage, err := CreateFiles(metadata, output)
+	if err != nil {
+		t.Fatalf("CreateFiles failed: %v", err)
+	}
+	if _, err := storage.WriteAt([]byte("hello"), 0); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	storage.Close()
+
+	got, err := os.ReadFile(output)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("expected output to contain %q, got %q, %v", "hello", got, err)
+	}
+}
+
+func TestFileStoragePadding(t *testing.T) {
+	dir := t.TempDir()
+	metadata := &Metadata{
+		Name:   "folder",
+		Length: 6,
+		Files: []FileEntry{
+			{Path: []string{"folder", "a"}, Length: 2, Offset: 0},
+			{Path: []string{"folder", ".pad", "2"}, Length: 2, Offset: 2, Padding: true},
+			{Path: []string{"folder", "b"}, Length: 2, Offset: 4},
+		},
+	}
+
+	storage, err := CreateFiles(metadata, dir)
+	if err != nil {
+		t.Fatalf("CreateFiles failed: %v", err)
+	}
+	if n, err := storage.WriteAt([]byte("ab\x00\x00cd"), 0); err != nil || n != 6 {
+		t.Fatalf("WriteAt = %d, %v, want 6, nil", n, err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "folder", ".pad")); !os.IsNotExist(err) {
+		t.Errorf("expected no padding file to be created, got %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "folder", "b"))
+	if err != nil || string(got) != "cd" {
+		t.Errorf("expected folder/b to contain %q, got %q, %v", "cd", got, err)
+	}
+}
+
+func TestOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	metadata := &Metadata{
+		Name:   "folder",
+		Length: 7,
+		Files: []FileEntry{
+			{Path: []string{"folder", "a"}, Length: 2, Offset: 0},
+			{Path: []string{"folder", ".pad", "2"}, Length: 2, Offset: 2, Padding: true},
+			{Path: []string{"folder", "b"}, Length: 3, Offset: 4},
+		},
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "folder"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{"a": "ab", "b": "cde"} {
+		if err := os.WriteFile(filepath.Join(dir, "folder", name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	storage, err := OpenFiles(metadata, dir)
+	if err != nil {
+		t.Fatalf("OpenFiles failed: %v", err)
+	}
+	defer storage.Close()
+
+	got := make([]byte, 6)
+	if n, err := storage.ReadAt(got, 1); err != nil || n != 6 || string(got) != "b\x00\x00cde" {
+		t.Errorf("ReadAt = %d, %q, %v, want 6, %q, nil", n, got, err, "b\x00\x00cde")
+	}
+	if _, err := storage.ReadAt(got, 2); err != io.EOF {
+		t.Errorf("expected io.EOF reading past the end, got %v", err)
+	}
+
+	metadata.Files[2].Length = 4
+	if _, err := OpenFiles(metadata, dir); err == nil {
+		t.Errorf("expected OpenFiles to fail when a file has the wrong length")
+	}
+}
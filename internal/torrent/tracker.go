@@ -0,0 +1,220 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// A Tracker tells peers of a torrent about each other. HTTPTracker and
+// UDPTracker implement it for the two kinds of announce URL.
+type Tracker interface {
+	Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error)
+}
+
+// AnnounceEvent tells a tracker why we announce, if not just to ask for
+// more peers.
+type AnnounceEvent string
+
+const (
+	EventNone      AnnounceEvent = ""
+	EventStarted   AnnounceEvent = "started"   // The first announce of a download.
+	EventCompleted AnnounceEvent = "completed" // The download just finished.
+	EventStopped   AnnounceEvent = "stopped"   // We are leaving the swarm.
+)
+
+// AnnounceRequest describes us to a tracker.
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      AnnounceEvent
+	NumWant    int    // Number of peers wanted; zero leaves it to the tracker.
+	Key        uint32 // Identifies us across IP address changes; zero for none.
+	TrackerID  string // The tracker ID from a previous response, if any.
+}
+
+// AnnounceResponse is a tracker's answer to an announce.
+type AnnounceResponse struct {
+	Interval    time.Duration // How long to wait before announcing again.
+	MinInterval time.Duration // Announces must be at least this far apart; zero if unset.
+	TrackerID   string        // To be sent back with later announces, if set.
+	Seeders     int           // Zero if the tracker did not say.
+	Leechers    int           // Zero if the tracker did not say.
+	Warning     string        // A message for the user; the announce still succeeded.
+	Peers       []PeerAddr    // IPv4 and IPv6 peers.
+
+	// Trackers are the URLs of the trackers that answered, when announcing
+	// through a TrackerList.
+	Trackers []string
+}
+
+// TrackerError is the failure reason a tracker sent instead of peers.
+type TrackerError struct {
+	Reason string
+}
+
+func (e *TrackerError) Error() string {
+	return "tracker failure: " + e.Reason
+}
+
+// NewTracker returns the tracker for an http, https or udp announce URL.
+// HTTP trackers are contacted with httpClient.
+func NewTracker(announce string, httpClient HTTPClient) (Tracker, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &HTTPTracker{URL: announce, Client: httpClient}, nil
+	case "udp":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("invalid tracker URL %q: missing port", announce)
+		}
+		return &UDPTracker{Addr: u.Host}, nil
+	default:
+		return nil, fmt.Errorf("unsupported tracker URL scheme %q", u.Scheme)
+	}
+}
+
+// HTTPTracker is a tracker that is announced to with HTTP GET requests.
+type HTTPTracker struct {
+	URL    string
+	Client HTTPClient
+}
+
+// httpAnnounceResponse is the dictionary an HTTP tracker responds with.
+type httpAnnounceResponse struct {
+	FailureReason  *string            `bencode:"failure reason,omitempty"`
+	WarningMessage string             `bencode:"warning message,omitempty"`
+	Interval       int64              `bencode:"interval,omitempty"`
+	MinInterval    int64              `bencode:"min interval,omitempty"`
+	TrackerID      string             `bencode:"tracker id,omitempty"`
+	Complete       int                `bencode:"complete,omitempty"`
+	Incomplete     int                `bencode:"incomplete,omitempty"`
+	Peers          bencode.RawMessage `bencode:"peers,omitempty"`
+	Peers6         []byte             `bencode:"peers6,omitempty"`
+}
+
+// httpPeer is a peer in the non-compact form of the peers list.
+type httpPeer struct {
+	IP     string `bencode:"ip"`
+	Port   uint16 `bencode:"port"`
+	PeerID []byte `bencode:"peer id,omitempty"`
+}
+
+// parseHTTPPeers parses the peers of an HTTP tracker response: a compact
+// string of IPv4 peers (BEP 23), or a list of dictionaries (BEP 3). Listed
+// peers given by host name rather than IP address, or without a port, are
+// skipped.
+func parseHTTPPeers(data bencode.RawMessage) ([]PeerAddr, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] != 'l' {
+		var compact []byte
+		if err := bencode.Unmarshal(data, &compact); err != nil {
+			return nil, fmt.Errorf("expected peers to be a byte string or a list: %v", err)
+		}
+		addrs, err := parseCompactPeers(compact, 4)
+		if err != nil {
+			return nil, err
+		}
+		return peerAddrs(addrs), nil
+	}
+
+	var list []httpPeer
+	if err := bencode.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode peers: %v", err)
+	}
+	peers := make([]PeerAddr, 0, len(list))
+	for _, p := range list {
+		addr, err := netip.ParseAddr(p.IP)
+		if err != nil || p.Port == 0 {
+			continue
+		}
+		peer := PeerAddr{AddrPort: netip.AddrPortFrom(addr.Unmap(), p.Port)}
+		if len(p.PeerID) == len(peer.PeerID) {
+			copy(peer.PeerID[:], p.PeerID)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// Announce sends req to the tracker. A failure reason in the response is
+// returned as a *TrackerError.
+func (t *HTTPTracker) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", t.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	q := request.URL.Query()
+	q.Add("info_hash", string(req.InfoHash[:]))
+	q.Add("peer_id", string(req.PeerID[:]))
+	q.Add("port", fmt.Sprint(req.Port))
+	q.Add("uploaded", fmt.Sprint(req.Uploaded))
+	q.Add("downloaded", fmt.Sprint(req.Downloaded))
+	q.Add("left", fmt.Sprint(req.Left))
+	q.Add("compact", "1")
+	if req.Event != EventNone {
+		q.Add("event", string(req.Event))
+	}
+	if req.NumWant != 0 {
+		q.Add("numwant", fmt.Sprint(req.NumWant))
+	}
+	if req.Key != 0 {
+		q.Add("key", fmt.Sprintf("%08x", req.Key))
+	}
+	if req.TrackerID != "" {
+		q.Add("trackerid", req.TrackerID)
+	}
+	request.URL.RawQuery = q.Encode()
+
+	response, err := t.Client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	var decoded httpAnnounceResponse
+	if err := bencode.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("tracker responded with status %s", response.Status)
+		}
+		return nil, fmt.Errorf("failed to decode response body: %v", err)
+	}
+	if decoded.FailureReason != nil {
+		return nil, &TrackerError{Reason: *decoded.FailureReason}
+	}
+
+	peers, err := parseHTTPPeers(decoded.Peers)
+	if err != nil {
+		return nil, err
+	}
+	peers6, err := parseCompactPeers(decoded.Peers6, 16)
+	if err != nil {
+		return nil, err
+	}
+	peers = append(peers, peerAddrs(peers6)...)
+
+	return &AnnounceResponse{
+		Interval:    time.Duration(decoded.Interval) * time.Second,
+		MinInterval: time.Duration(decoded.MinInterval) * time.Second,
+		TrackerID:   decoded.TrackerID,
+		Seeders:     decoded.Complete,
+		Leechers:    decoded.Incomplete,
+		Warning:     decoded.WarningMessage,
+		Peers:       peers,
+	}, nil
+}
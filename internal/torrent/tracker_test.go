@@ -0,0 +1,165 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/testutil"
+)
+
+func TestHTTPTrackerAnnounce(t *testing.T) {
+	body, _ := bencode.Marshal(map[string]any{
+		"interval":        1800,
+		"min interval":    60,
+		"tracker id":      "abc",
+		"complete":        5,
+		"incomplete":      2,
+		"warning message": "slow down",
+		"peers":           []byte{10, 0, 0, 1, 0x1a, 0xe1},
+	})
+	client := &testutil.MockHTTPClient{Response: body}
+	tracker := &HTTPTracker{URL: "http://tracker.example/announce?passkey=x", Client: client}
+
+	resp, err := tracker.Announce(context.Background(), &AnnounceRequest{
+		InfoHash:   [20]byte{1},
+		PeerID:     [20]byte{'p'},
+		Port:       6881,
+		Uploaded:   1,
+		Downloaded: 2,
+		Left:       3,
+		Event:      EventStarted,
+		NumWant:    50,
+		Key:        0xbeef,
+		TrackerID:  "old",
+	})
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	want := &AnnounceResponse{
+		Interval:    30 * time.Minute,
+		MinInterval: time.Minute,
+		TrackerID:   "abc",
+		Seeders:     5,
+		Leechers:    2,
+		Warning:     "slow down",
+		Peers:       []PeerAddr{{AddrPort: netip.MustParseAddrPort("10.0.0.1:6881")}},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("Announce() = %+v, want %+v", resp, want)
+	}
+
+	q := client.Requests.URL.Query()
+	for key, value := range map[string]string{
+		"passkey":    "x",
+		"event":      "started",
+		"numwant":    "50",
+		"key":        "0000beef",
+		"trackerid":  "old",
+		"uploaded":   "1",
+		"downloaded": "2",
+		"left":       "3",
+	} {
+		if got := q.Get(key); got != value {
+			t.Errorf("query parameter %s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestHTTPTrackerPeers(t *testing.T) {
+	peerID := [20]byte{'-', 'T', 'R'}
+	tests := []struct {
+		name     string
+		response map[string]any
+		want     []PeerAddr
+	}{
+		{
+			name: "compact with peers6",
+			response: map[string]any{
+				"peers":  []byte{10, 0, 0, 1, 0x1a, 0xe1},
+				"peers6": append(netip.MustParseAddr("2001:db8::1").AsSlice(), 0x1a, 0xe2),
+			},
+			want: []PeerAddr{
+				{AddrPort: netip.MustParseAddrPort("10.0.0.1:6881")},
+				{AddrPort: netip.MustParseAddrPort("[2001:db8::1]:6882")},
+			},
+		},
+		{
+			name: "dictionaries",
+			response: map[string]any{
+				"peers": []any{
+					map[string]any{"ip": "10.0.0.2", "port": 6881, "peer id": peerID[:]},
+					map[string]any{"ip": "2001:db8::2", "port": 6882},
+					map[string]any{"ip": "::ffff:10.0.0.3", "port": 6883, "peer id": "short"},
+					map[string]any{"ip": "peer.example", "port": 6884},
+					map[string]any{"ip": "10.0.0.5"},
+				},
+			},
+			want: []PeerAddr{
+				{AddrPort: netip.MustParseAddrPort("10.0.0.2:6881"), PeerID: peerID},
+				{AddrPort: netip.MustParseAddrPort("[2001:db8::2]:6882")},
+				{AddrPort: netip.MustParseAddrPort("10.0.0.3:6883")},
+			},
+		},
+		{
+			name:     "no peers",
+			response: map[string]any{"interval": 1800},
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := bencode.Marshal(tt.response)
+			if err != nil {
+				t.Fatalf("failed to encode response: %v", err)
+			}
+			tracker := &HTTPTracker{URL: "http://tracker.example/announce", Client: &testutil.MockHTTPClient{Response: body}}
+			resp, err := tracker.Announce(context.Background(), &AnnounceRequest{})
+			if err != nil {
+				t.Fatalf("Announce failed: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Peers, tt.want) {
+				t.Errorf("Announce() peers = %v, want %v", resp.Peers, tt.want)
+			}
+		})
+	}
+
+	peer := PeerAddr{AddrPort: netip.MustParseAddrPort("[2001:db8::2]:6882")}
+	if peer.String() != "[2001:db8::2]:6882" || peer.HasPeerID() {
+		t.Errorf("PeerAddr = %s (has peer ID %v), want [2001:db8::2]:6882 without peer ID", peer, peer.HasPeerID())
+	}
+}
+
+func TestHTTPTrackerAnnounceErrors(t *testing.T) {
+	failure, _ := bencode.Marshal(map[string]any{"failure reason": "unregistered torrent"})
+	tracker := &HTTPTracker{URL: "http://tracker.example/announce", Client: &testutil.MockHTTPClient{Response: failure}}
+	_, err := tracker.Announce(context.Background(), &AnnounceRequest{})
+	var trackerErr *TrackerError
+	if !errors.As(err, &trackerErr) || trackerErr.Reason != "unregistered torrent" {
+		t.Errorf("Announce() error = %v, want the failure reason", err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "not bencode", body: "<html>"},
+		{name: "peers not a string", body: "d5:peersi1ee"},
+		{name: "truncated compact peers", body: "d5:peers5:12345e"},
+		{name: "truncated compact peers6", body: "d6:peers65:12345e"},
+		{name: "peer with invalid port", body: "d5:peersld2:ip8:10.0.0.14:porti70000eeee"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &HTTPTracker{URL: "http://tracker.example/announce", Client: &testutil.MockHTTPClient{Response: []byte(tt.body)}}
+			if _, err := tracker.Announce(context.Background(), &AnnounceRequest{}); err == nil {
+				t.Errorf("expected error for %s", tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTrackerTimeout is how long a TrackerList waits for a tracker to
+// answer before moving on to the next. Without it, the retries of a dead UDP
+// tracker would hold up failover for hours.
+const DefaultTrackerTimeout = 30 * time.Second
+
+// TrackerTiers returns the tiers of tracker URLs to announce to: the
+// announce-list if the torrent has one (BEP 12), or else its announce URL.
+func TrackerTiers(metadata *Metadata) [][]string {
+	var tiers [][]string
+	for _, tier := range metadata.AnnounceList {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	if len(tiers) == 0 && metadata.Announce != "" {
+		tiers = [][]string{{metadata.Announce}}
+	}
+	return tiers
+}
+
+// A TrackerList announces to tiers of trackers (BEP 12). The trackers in
+// each tier are tried in random order until one answers, which is moved to
+// the front of its tier for later announces. Later tiers are only tried if
+// every tracker in the earlier ones fails, unless Parallel is set.
+type TrackerList struct {
+	// Parallel makes announces go to every tier at once, merging the peers
+	// of all the tiers that answer.
+	Parallel bool
+	// Timeout is how long each tracker is given to answer; it defaults to
+	// DefaultTrackerTimeout.
+	Timeout time.Duration
+
+	httpClient HTTPClient
+
+	mu         sync.Mutex
+	tiers      [][]string
+	trackers   map[string]Tracker
+	trackerIDs map[string]string
+}
+
+// NewTrackerList returns a list of the tiers of tracker URLs. HTTP trackers
+// are contacted with httpClient.
+func NewTrackerList(tiers [][]string, httpClient HTTPClient) *TrackerList {
+	l := &TrackerList{
+		httpClient: httpClient,
+		trackers:   make(map[string]Tracker),
+		trackerIDs: make(map[string]string),
+	}
+	for _, tier := range tiers {
+		tier = append([]string(nil), tier...)
+		rand.Shuffle(len(tier), func(i, j int) { tier[i], tier[j] = tier[j], tier[i] })
+		l.tiers = append(l.tiers, tier)
+	}
+	return l
+}
+
+// Tiers returns the tiers in the order they are tried.
+func (l *TrackerList) Tiers() [][]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tiers := make([][]string, len(l.tiers))
+	for i, tier := range l.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// Announce sends req to the first tracker that answers, or to one in each
+// tier if Parallel is set. The response's Trackers lists the trackers that
+// answered, and its peers are free of duplicates. Tracker IDs are kept for
+// each tracker, so req.TrackerID is ignored and the response has none.
+func (l *TrackerList) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	l.mu.Lock()
+	numTiers := len(l.tiers)
+	l.mu.Unlock()
+	if numTiers == 0 {
+		return nil, fmt.Errorf("torrent has no tracker URL")
+	}
+
+	if !l.Parallel {
+		var errs []error
+		for i := range numTiers {
+			resp, err := l.announceTier(ctx, i, req)
+			if err == nil {
+				return resp, nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	resps := make([]*AnnounceResponse, numTiers)
+	errs := make([]error, numTiers)
+	var wg sync.WaitGroup
+	for i := range numTiers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i], errs[i] = l.announceTier(ctx, i, req)
+		}(i)
+	}
+	wg.Wait()
+
+	var merged *AnnounceResponse
+	for _, resp := range resps {
+		if resp != nil {
+			merged = mergeResponses(merged, resp)
+		}
+	}
+	if merged == nil {
+		return nil, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+// announceTier tries the trackers of a tier in order, and moves the first
+// one that answers to the front.
+func (l *TrackerList) announceTier(ctx context.Context, tier int, req *AnnounceRequest) (*AnnounceResponse, error) {
+	l.mu.Lock()
+	urls := append([]string(nil), l.tiers[tier]...)
+	l.mu.Unlock()
+
+	var errs []error
+	for _, url := range urls {
+		resp, err := l.announceTo(ctx, url, req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		l.mu.Lock()
+		current := l.tiers[tier]
+		for i, u := range current {
+			if u == url {
+				copy(current[1:i+1], current[:i])
+				current[0] = url
+				break
+			}
+		}
+		l.mu.Unlock()
+		return resp, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (l *TrackerList) announceTo(ctx context.Context, url string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	l.mu.Lock()
+	tracker, ok := l.trackers[url]
+	if !ok {
+		var err error
+		if tracker, err = NewTracker(url, l.httpClient); err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		l.trackers[url] = tracker
+	}
+	trackerReq := *req
+	trackerReq.TrackerID = l.trackerIDs[url]
+	timeout := l.Timeout
+	l.mu.Unlock()
+
+	if timeout <= 0 {
+		timeout = DefaultTrackerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := tracker.Announce(ctx, &trackerReq)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	if resp.TrackerID != "" {
+		l.trackerIDs[url] = resp.TrackerID
+	}
+	l.mu.Unlock()
+
+	merged := *resp
+	merged.TrackerID = ""
+	merged.Trackers = []string{url}
+	merged.Peers = mergePeers(nil, resp.Peers)
+	return &merged, nil
+}
+
+// mergeResponses combines the responses of two tiers: the peers of both,
+// the more frequent of their intervals, and the larger of the rest.
+func mergeResponses(a, b *AnnounceResponse) *AnnounceResponse {
+	if a == nil {
+		return b
+	}
+	merged := *a
+	if b.Interval > 0 && (merged.Interval == 0 || b.Interval < merged.Interval) {
+		merged.Interval = b.Interval
+	}
+	merged.MinInterval = max(merged.MinInterval, b.MinInterval)
+	merged.Seeders = max(merged.Seeders, b.Seeders)
+	merged.Leechers = max(merged.Leechers, b.Leechers)
+	if b.Warning != "" {
+		merged.Warning = strings.TrimPrefix(merged.Warning+"; "+b.Warning, "; ")
+	}
+	merged.Trackers = append(append([]string(nil), a.Trackers...), b.Trackers...)
+	merged.Peers = mergePeers(a.Peers, b.Peers)
+	return &merged
+}
+
+// mergePeers appends the peers of b to a that are not in it already. A peer
+// ID learned from b fills in one that a did not know.
+func mergePeers(a, b []PeerAddr) []PeerAddr {
+	merged := append([]PeerAddr(nil), a...)
+	index := make(map[PeerAddr]int, len(merged))
+	for i, peer := range merged {
+		index[PeerAddr{AddrPort: peer.AddrPort}] = i
+	}
+	for _, peer := range b {
+		key := PeerAddr{AddrPort: peer.AddrPort}
+		if i, ok := index[key]; ok {
+			if !merged[i].HasPeerID() {
+				merged[i].PeerID = peer.PeerID
+			}
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, peer)
+	}
+	return merged
+}
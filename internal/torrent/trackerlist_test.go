@@ -0,0 +1,238 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// trackerClient answers HTTP announces by the tracker's host name, failing
+// for hosts it has no response for, and records the requests.
+type trackerClient struct {
+	responses map[string]map[string]any
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (c *trackerClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+
+	response, ok := c.responses[req.URL.Host]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	body, err := bencode.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (c *trackerClient) hosts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var hosts []string
+	for _, req := range c.requests {
+		hosts = append(hosts, req.URL.Host)
+	}
+	return hosts
+}
+
+func compactResponse(peers ...string) map[string]any {
+	var compact []byte
+	for _, peer := range peers {
+		compact = appendCompactPeer(compact, netip.MustParseAddrPort(peer))
+	}
+	return map[string]any{"interval": 1800, "peers": compact}
+}
+
+func TestTrackerTiers(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata *Metadata
+		want     [][]string
+	}{
+		{
+			name:     "announce only",
+			metadata: &Metadata{Announce: "http://a/announce"},
+			want:     [][]string{{"http://a/announce"}},
+		},
+		{
+			name: "announce list",
+			metadata: &Metadata{
+				Announce:     "http://a/announce",
+				AnnounceList: [][]string{{"http://b/announce", "http://c/announce"}, {}, {"udp://d:1"}},
+			},
+			want: [][]string{{"http://b/announce", "http://c/announce"}, {"udp://d:1"}},
+		},
+		{
+			name:     "no trackers",
+			metadata: &Metadata{},
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TrackerTiers(tt.metadata); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrackerTiers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerListFailover(t *testing.T) {
+	client := &trackerClient{responses: map[string]map[string]any{
+		"good1": compactResponse("10.0.0.1:1", "10.0.0.1:1"),
+		"good2": compactResponse("10.0.0.2:2"),
+	}}
+	list := NewTrackerList([][]string{
+		{"http://dead1/announce", "http://good1/announce", "http://dead2/announce"},
+		{"http://good2/announce"},
+	}, client)
+
+	resp, err := list.Announce(context.Background(), &AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Trackers, []string{"http://good1/announce"}) {
+		t.Errorf("Trackers = %v, want the working tracker of the first tier", resp.Trackers)
+	}
+	if want := []PeerAddr{{AddrPort: netip.MustParseAddrPort("10.0.0.1:1")}}; !reflect.DeepEqual(resp.Peers, want) {
+		t.Errorf("Peers = %v, want %v", resp.Peers, want)
+	}
+	if slices.Contains(client.hosts(), "good2") {
+		t.Errorf("expected the second tier not to be contacted, got requests to %v", client.hosts())
+	}
+
+	// The working tracker is tried first from now on.
+	if tiers := list.Tiers(); tiers[0][0] != "http://good1/announce" || len(tiers[0]) != 3 {
+		t.Errorf("Tiers() = %v, want good1 first in the first tier", tiers)
+	}
+	client.requests = nil
+	if _, err := list.Announce(context.Background(), &AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if hosts := client.hosts(); !reflect.DeepEqual(hosts, []string{"good1"}) {
+		t.Errorf("second announce contacted %v, want only good1", hosts)
+	}
+
+	// A dead first tier falls through to the next.
+	delete(client.responses, "good1")
+	resp, err = list.Announce(context.Background(), &AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Trackers, []string{"http://good2/announce"}) {
+		t.Errorf("Trackers = %v, want the second tier's tracker", resp.Trackers)
+	}
+
+	delete(client.responses, "good2")
+	if _, err := list.Announce(context.Background(), &AnnounceRequest{}); err == nil {
+		t.Errorf("expected Announce to fail when every tracker does")
+	}
+	if _, err := NewTrackerList(nil, client).Announce(context.Background(), &AnnounceRequest{}); err == nil {
+		t.Errorf("expected Announce to fail without trackers")
+	}
+}
+
+func TestTrackerListTimeout(t *testing.T) {
+	// A UDP tracker that never answers would be retried for hours.
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+
+	client := &trackerClient{responses: map[string]map[string]any{"good": compactResponse("10.0.0.1:1")}}
+	list := NewTrackerList([][]string{{"udp://" + dead.LocalAddr().String()}, {"http://good/announce"}}, client)
+	list.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	resp, err := list.Announce(context.Background(), &AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Trackers, []string{"http://good/announce"}) {
+		t.Errorf("Trackers = %v, want the second tier's tracker", resp.Trackers)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Announce took %v to fail over from a dead tracker", elapsed)
+	}
+}
+
+func TestTrackerListParallel(t *testing.T) {
+	a := compactResponse("10.0.0.1:1", "10.0.0.2:2")
+	a["interval"] = 1800
+	a["tracker id"] = "id-a"
+	b := compactResponse("10.0.0.2:2", "10.0.0.3:3")
+	b["interval"] = 900
+	b["warning message"] = "b is busy"
+	client := &trackerClient{responses: map[string]map[string]any{"a": a, "b": b}}
+
+	list := NewTrackerList([][]string{{"http://a/announce"}, {"http://b/announce"}, {"http://dead/announce"}}, client)
+	list.Parallel = true
+
+	resp, err := list.Announce(context.Background(), &AnnounceRequest{TrackerID: "ignored"})
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	want := &AnnounceResponse{
+		Interval: 15 * time.Minute,
+		Warning:  "b is busy",
+		Trackers: []string{"http://a/announce", "http://b/announce"},
+		Peers: []PeerAddr{
+			{AddrPort: netip.MustParseAddrPort("10.0.0.1:1")},
+			{AddrPort: netip.MustParseAddrPort("10.0.0.2:2")},
+			{AddrPort: netip.MustParseAddrPort("10.0.0.3:3")},
+		},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("Announce() = %+v, want %+v", resp, want)
+	}
+
+	// Each tracker gets its own tracker ID back.
+	client.requests = nil
+	list.Announce(context.Background(), &AnnounceRequest{})
+	for _, req := range client.requests {
+		wantID := ""
+		if req.URL.Host == "a" {
+			wantID = "id-a"
+		}
+		if got := req.URL.Query().Get("trackerid"); got != wantID {
+			t.Errorf("tracker %s got tracker ID %q, want %q", req.URL.Host, got, wantID)
+		}
+	}
+}
+
+func TestMergePeers(t *testing.T) {
+	peerID := [20]byte{1}
+	a := []PeerAddr{{AddrPort: netip.MustParseAddrPort("10.0.0.1:1")}}
+	b := []PeerAddr{
+		{AddrPort: netip.MustParseAddrPort("10.0.0.1:1"), PeerID: peerID},
+		{AddrPort: netip.MustParseAddrPort("[2001:db8::1]:1")},
+	}
+	want := []PeerAddr{
+		{AddrPort: netip.MustParseAddrPort("10.0.0.1:1"), PeerID: peerID},
+		{AddrPort: netip.MustParseAddrPort("[2001:db8::1]:1")},
+	}
+	if got := mergePeers(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergePeers() = %v, want %v", got, want)
+	}
+	if a[0].HasPeerID() {
+		t.Errorf("expected mergePeers not to modify its arguments")
+	}
+}
@@ -0,0 +1,264 @@
+package torrent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// UDP tracker actions (BEP 15).
+const (
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+)
+
+const (
+	udpProtocolID = 0x41727101980
+
+	// udpConnectionIDLifetime is how long a connection ID may be used for.
+	udpConnectionIDLifetime = time.Minute
+
+	// DefaultUDPTimeout and DefaultUDPRetries give the retry schedule of BEP
+	// 15: a request is sent again after 15 * 2^n seconds, up to n = 8.
+	DefaultUDPTimeout = 15 * time.Second
+	DefaultUDPRetries = 8
+
+	maxUDPScrapeHashes = 74
+)
+
+// udpEvents are the numbers of announce events in UDP tracker requests.
+var udpEvents = map[AnnounceEvent]uint32{
+	EventNone:      0,
+	EventCompleted: 1,
+	EventStarted:   2,
+	EventStopped:   3,
+}
+
+// UDPTracker is a tracker that speaks the UDP tracker protocol (BEP 15).
+type UDPTracker struct {
+	Addr    string        // The tracker's "host:port".
+	Timeout time.Duration // Time to wait for the first response; defaults to DefaultUDPTimeout.
+	// Retries is the number of times to resend a request that gets no
+	// response. Zero means DefaultUDPRetries; a negative value sends each
+	// request only once.
+	Retries int
+
+	mu           sync.Mutex
+	connectionID uint64
+	connectedAt  time.Time
+}
+
+// Announce sends req to the tracker. Peers are IPv6 addresses if the
+// tracker is reached over IPv6. An error the tracker sends is returned as a
+// *TrackerError. UDP trackers have no tracker IDs, minimum intervals or
+// warnings.
+func (t *UDPTracker) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	body := make([]byte, 0, 82)
+	body = append(body, req.InfoHash[:]...)
+	body = append(body, req.PeerID[:]...)
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Downloaded))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Left))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Uploaded))
+	body = binary.BigEndian.AppendUint32(body, udpEvents[req.Event])
+	body = binary.BigEndian.AppendUint32(body, 0) // IP address: the sender's
+	body = binary.BigEndian.AppendUint32(body, req.Key)
+	numWant := int32(-1) // the tracker's default
+	if req.NumWant > 0 {
+		numWant = int32(req.NumWant)
+	}
+	body = binary.BigEndian.AppendUint32(body, uint32(numWant))
+	body = binary.BigEndian.AppendUint16(body, req.Port)
+
+	resp, ipLength, err := t.request(ctx, udpActionAnnounce, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 12 {
+		return nil, fmt.Errorf("announce response too short: %d bytes", len(resp))
+	}
+	peers, err := parseCompactPeers(resp[12:], ipLength)
+	if err != nil {
+		return nil, err
+	}
+	return &AnnounceResponse{
+		Interval: time.Duration(binary.BigEndian.Uint32(resp[0:4])) * time.Second,
+		Leechers: int(binary.BigEndian.Uint32(resp[4:8])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[8:12])),
+		Peers:    peerAddrs(peers),
+	}, nil
+}
+
+// Scrape asks the tracker for the counts of torrents, in batches of up to
+// 74 info hashes, which fit in one packet.
+func (t *UDPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeStats, error) {
+	if len(infoHashes) == 0 {
+		return nil, fmt.Errorf("no info hashes to scrape")
+	}
+
+	stats := make(map[[20]byte]ScrapeStats, len(infoHashes))
+	for start := 0; start < len(infoHashes); start += maxUDPScrapeHashes {
+		batch := infoHashes[start:min(start+maxUDPScrapeHashes, len(infoHashes))]
+		body := make([]byte, 0, 20*len(batch))
+		for _, infoHash := range batch {
+			body = append(body, infoHash[:]...)
+		}
+
+		resp, _, err := t.request(ctx, udpActionScrape, body)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp) < 12*len(batch) {
+			return nil, fmt.Errorf("scrape response too short: %d bytes for %d torrents", len(resp), len(batch))
+		}
+		for i, infoHash := range batch {
+			entry := resp[12*i:]
+			stats[infoHash] = ScrapeStats{
+				Seeders:    int(binary.BigEndian.Uint32(entry[0:4])),
+				Downloaded: int(binary.BigEndian.Uint32(entry[4:8])),
+				Leechers:   int(binary.BigEndian.Uint32(entry[8:12])),
+			}
+		}
+	}
+	return stats, nil
+}
+
+// request sends an action with body, connecting first if there is no valid
+// connection ID, and returns the body of the response. It also returns the
+// length of IP addresses on this connection: 4, or 16 over IPv6.
+func (t *UDPTracker) request(ctx context.Context, action uint32, body []byte) ([]byte, int, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", t.Addr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to connect to tracker: %w", err)
+	}
+	defer conn.Close()
+
+	ipLength := 4
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.AddrPort().Addr().Unmap().Is6() {
+		ipLength = 16
+	}
+
+	timeout, retries := t.Timeout, t.Retries
+	if timeout == 0 {
+		timeout = DefaultUDPTimeout
+	}
+	switch {
+	case retries == 0:
+		retries = DefaultUDPRetries
+	case retries < 0:
+		retries = 0
+	}
+
+	for n := 0; n <= retries; n++ {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		wait := timeout << n
+
+		connectionID, ok := t.validConnectionID()
+		if !ok {
+			resp, err := t.exchange(ctx, conn, udpProtocolID, udpActionConnect, nil, wait)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			if len(resp) < 8 {
+				return nil, 0, fmt.Errorf("connect response too short: %d bytes", len(resp))
+			}
+			connectionID = binary.BigEndian.Uint64(resp)
+			t.setConnectionID(connectionID)
+		}
+
+		resp, err := t.exchange(ctx, conn, connectionID, action, body, wait)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		return resp, ipLength, err
+	}
+	return nil, 0, fmt.Errorf("tracker %s did not respond", t.Addr)
+}
+
+// exchange sends one request and waits up to wait for the response with
+// its transaction ID, returning the response's body.
+func (t *UDPTracker) exchange(ctx context.Context, conn net.Conn, connectionID uint64, action uint32, body []byte, wait time.Duration) ([]byte, error) {
+	var tid [4]byte
+	rand.Read(tid[:])
+
+	packet := make([]byte, 0, 16+len(body))
+	packet = binary.BigEndian.AppendUint64(packet, connectionID)
+	packet = binary.BigEndian.AppendUint32(packet, action)
+	packet = append(packet, tid[:]...)
+	packet = append(packet, body...)
+	if _, err := conn.Write(packet); err != nil {
+		return nil, fmt.Errorf("failed to send request to tracker: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	ctxDeadline, hasCtxDeadline := ctx.Deadline()
+	if hasCtxDeadline && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if hasCtxDeadline && deadline.Equal(ctxDeadline) && errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, context.DeadlineExceeded
+			}
+			return nil, err
+		}
+		resp := buf[:n]
+		// Packets of other transactions are stale responses to requests
+		// that timed out.
+		if len(resp) < 8 || string(resp[4:8]) != string(tid[:]) {
+			continue
+		}
+
+		switch got := binary.BigEndian.Uint32(resp[0:4]); got {
+		case action:
+			return append([]byte(nil), resp[8:]...), nil
+		case udpActionError:
+			if action != udpActionConnect {
+				// The connection ID may have been rejected.
+				t.forgetConnectionID()
+			}
+			return nil, &TrackerError{Reason: string(resp[8:])}
+		default:
+			return nil, fmt.Errorf("unexpected action %d in tracker response", got)
+		}
+	}
+}
+
+func (t *UDPTracker) validConnectionID() (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.connectedAt.IsZero() || time.Since(t.connectedAt) >= udpConnectionIDLifetime {
+		return 0, false
+	}
+	return t.connectionID, true
+}
+
+func (t *UDPTracker) setConnectionID(connectionID uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connectionID = connectionID
+	t.connectedAt = time.Now()
+}
+
+func (t *UDPTracker) forgetConnectionID() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connectedAt = time.Time{}
+}
@@ -0,0 +1,308 @@
+package torrent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker is a UDP tracker on loopback that answers with fixed peers
+// and counts, dropping the first drop packets it receives, or failing
+// every request with failWith if it is set.
+type fakeUDPTracker struct {
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	drop     int
+	failWith string
+	connects int
+	requests [][]byte // Announce and scrape packets.
+}
+
+const fakeConnectionID = 0x1122334455667788
+
+func newFakeUDPTracker(t *testing.T) *fakeUDPTracker {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	tracker := &fakeUDPTracker{conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	go tracker.serve()
+	return tracker
+}
+
+func (f *fakeUDPTracker) addr() string {
+	return f.conn.LocalAddr().String()
+}
+
+func (f *fakeUDPTracker) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := f.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		packet := append([]byte(nil), buf[:n]...)
+
+		f.mu.Lock()
+		if f.drop > 0 {
+			f.drop--
+			f.mu.Unlock()
+			continue
+		}
+		connectionID := binary.BigEndian.Uint64(packet[0:8])
+		action := binary.BigEndian.Uint32(packet[8:12])
+		resp := binary.BigEndian.AppendUint32(nil, action)
+		resp = append(resp, packet[12:16]...)
+
+		switch {
+		case action == udpActionConnect && connectionID == udpProtocolID:
+			f.connects++
+			resp = binary.BigEndian.AppendUint64(resp, fakeConnectionID)
+		case connectionID != fakeConnectionID:
+			resp = binary.BigEndian.AppendUint32(resp[:0], udpActionError)
+			resp = append(append(resp, packet[12:16]...), "bad connection ID"...)
+		case f.failWith != "":
+			resp = binary.BigEndian.AppendUint32(resp[:0], udpActionError)
+			resp = append(append(resp, packet[12:16]...), f.failWith...)
+		case action == udpActionAnnounce:
+			f.requests = append(f.requests, packet)
+			resp = binary.BigEndian.AppendUint32(resp, 1800) // interval
+			resp = binary.BigEndian.AppendUint32(resp, 3)    // leechers
+			resp = binary.BigEndian.AppendUint32(resp, 7)    // seeders
+			resp = append(resp, 10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2)
+		case action == udpActionScrape:
+			f.requests = append(f.requests, packet)
+			for i := 16; i < len(packet); i += 20 {
+				resp = binary.BigEndian.AppendUint32(resp, uint32(packet[i]))   // seeders
+				resp = binary.BigEndian.AppendUint32(resp, 100)                 // completed
+				resp = binary.BigEndian.AppendUint32(resp, uint32(packet[i]+1)) // leechers
+			}
+		}
+		f.mu.Unlock()
+
+		// A stale response to another transaction comes first.
+		stale := append([]byte(nil), resp...)
+		stale[4] ^= 0xff
+		f.conn.WriteToUDPAddrPort(stale, from)
+		f.conn.WriteToUDPAddrPort(resp, from)
+	}
+}
+
+func TestUDPTrackerAnnounce(t *testing.T) {
+	fake := newFakeUDPTracker(t)
+	tracker := &UDPTracker{Addr: fake.addr(), Timeout: time.Second}
+	req := &AnnounceRequest{
+		InfoHash:   [20]byte{1, 2, 3},
+		PeerID:     [20]byte{4, 5, 6},
+		Port:       6881,
+		Uploaded:   10,
+		Downloaded: 20,
+		Left:       30,
+		Event:      EventStarted,
+		Key:        0xabcd,
+		NumWant:    20,
+	}
+
+	resp, err := tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	want := &AnnounceResponse{
+		Interval: 30 * time.Minute,
+		Leechers: 3,
+		Seeders:  7,
+		Peers: []PeerAddr{
+			{AddrPort: netip.MustParseAddrPort("10.0.0.1:6881")},
+			{AddrPort: netip.MustParseAddrPort("10.0.0.2:6882")},
+		},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("Announce() = %+v, want %+v", resp, want)
+	}
+
+	fake.mu.Lock()
+	packet := fake.requests[0]
+	fake.mu.Unlock()
+	if len(packet) != 98 {
+		t.Fatalf("expected a 98 byte announce request, got %d", len(packet))
+	}
+	if [20]byte(packet[16:36]) != req.InfoHash || [20]byte(packet[36:56]) != req.PeerID {
+		t.Errorf("announce request has wrong info hash or peer ID: %x", packet[16:56])
+	}
+	if got := []uint64{
+		binary.BigEndian.Uint64(packet[56:64]),
+		binary.BigEndian.Uint64(packet[64:72]),
+		binary.BigEndian.Uint64(packet[72:80]),
+	}; !reflect.DeepEqual(got, []uint64{20, 30, 10}) {
+		t.Errorf("downloaded, left, uploaded = %v, want [20 30 10]", got)
+	}
+	if got := []uint32{
+		binary.BigEndian.Uint32(packet[80:84]),
+		binary.BigEndian.Uint32(packet[88:92]),
+		binary.BigEndian.Uint32(packet[92:96]),
+	}; !reflect.DeepEqual(got, []uint32{2, 0xabcd, 20}) {
+		t.Errorf("event, key, num want = %v, want [2 43981 20]", got)
+	}
+	if port := binary.BigEndian.Uint16(packet[96:98]); port != 6881 {
+		t.Errorf("port = %d, want 6881", port)
+	}
+
+	// The connection ID is reused.
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.connects != 1 {
+		t.Errorf("expected 1 connect for 2 announces, got %d", fake.connects)
+	}
+}
+
+func TestUDPTrackerRetries(t *testing.T) {
+	fake := newFakeUDPTracker(t)
+	fake.mu.Lock()
+	fake.drop = 2
+	fake.mu.Unlock()
+	tracker := &UDPTracker{Addr: fake.addr(), Timeout: 20 * time.Millisecond, Retries: 2}
+
+	if _, err := tracker.Announce(context.Background(), &AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce failed after dropped packets: %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.drop = 10
+	fake.mu.Unlock()
+	start := time.Now()
+	if _, err := tracker.Announce(context.Background(), &AnnounceRequest{}); err == nil {
+		t.Errorf("expected Announce to fail when the tracker never responds")
+	}
+	// 20ms, 40ms and 80ms.
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("expected Announce to back off exponentially, gave up after %v", elapsed)
+	}
+
+	// A negative Retries sends the request once.
+	fake.mu.Lock()
+	fake.drop = 10
+	fake.mu.Unlock()
+	tracker.Retries = -1
+	if _, err := tracker.Announce(context.Background(), &AnnounceRequest{}); err == nil {
+		t.Errorf("expected Announce to fail when the tracker never responds")
+	}
+	fake.mu.Lock()
+	if sent := 10 - fake.drop; sent != 1 {
+		t.Errorf("Announce with Retries -1 sent %d requests, want 1", sent)
+	}
+	fake.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	tracker.Timeout, tracker.Retries = time.Second, 2
+	if _, err := tracker.Announce(ctx, &AnnounceRequest{}); err != context.DeadlineExceeded {
+		t.Errorf("Announce() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestUDPTrackerError(t *testing.T) {
+	fake := newFakeUDPTracker(t)
+	fake.mu.Lock()
+	fake.failWith = "torrent not registered"
+	fake.mu.Unlock()
+	tracker := &UDPTracker{Addr: fake.addr(), Timeout: time.Second}
+
+	_, err := tracker.Announce(context.Background(), &AnnounceRequest{})
+	var trackerErr *TrackerError
+	if !errors.As(err, &trackerErr) || trackerErr.Reason != "torrent not registered" {
+		t.Errorf("Announce() error = %v, want the tracker's failure reason", err)
+	}
+}
+
+func TestUDPTrackerScrape(t *testing.T) {
+	fake := newFakeUDPTracker(t)
+	tracker := &UDPTracker{Addr: fake.addr(), Timeout: time.Second}
+
+	stats, err := tracker.Scrape(context.Background(), [][20]byte{{5}, {9}})
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	want := map[[20]byte]ScrapeStats{
+		{5}: {Seeders: 5, Downloaded: 100, Leechers: 6},
+		{9}: {Seeders: 9, Downloaded: 100, Leechers: 10},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("Scrape() = %+v, want %+v", stats, want)
+	}
+
+	// More torrents than fit in a packet are scraped in batches.
+	many := make([][20]byte, 100)
+	for i := range many {
+		many[i] = [20]byte{byte(i), 1}
+	}
+	stats, err = tracker.Scrape(context.Background(), many)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	if len(stats) != 100 || stats[many[99]].Seeders != 99 {
+		t.Errorf("expected counts for 100 torrents, got %d", len(stats))
+	}
+	fake.mu.Lock()
+	if scrapes := len(fake.requests) - 1; scrapes != 2 {
+		t.Errorf("expected 2 scrape requests for 100 torrents, got %d", scrapes)
+	}
+	fake.mu.Unlock()
+
+	if _, err := tracker.Scrape(context.Background(), nil); err == nil {
+		t.Errorf("expected Scrape to fail without info hashes")
+	}
+}
+
+func TestNewTracker(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Tracker
+		wantErr bool
+	}{
+		{url: "http://tracker.example/announce", want: &HTTPTracker{URL: "http://tracker.example/announce"}},
+		{url: "https://tracker.example/announce?key=1", want: &HTTPTracker{URL: "https://tracker.example/announce?key=1"}},
+		{url: "udp://tracker.example:1337/announce", want: &UDPTracker{Addr: "tracker.example:1337"}},
+		{url: "udp://tracker.example/announce", wantErr: true},
+		{url: "wss://tracker.example", wantErr: true},
+		{url: "://", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NewTracker(tt.url, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewTracker(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NewTracker(%q) = %+v, want %+v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPeersUDP(t *testing.T) {
+	fake := newFakeUDPTracker(t)
+	metadata := &Metadata{Announce: "udp://" + fake.addr(), Length: 100, Files: []FileEntry{{Length: 100}}}
+
+	resp, err := Peers(nil, metadata)
+	if err != nil {
+		t.Fatalf("Peers failed: %v", err)
+	}
+	want := []PeerAddr{
+		{AddrPort: netip.MustParseAddrPort("10.0.0.1:6881")},
+		{AddrPort: netip.MustParseAddrPort("10.0.0.2:6882")},
+	}
+	if !reflect.DeepEqual(resp.Peers, want) {
+		t.Errorf("Peers() = %v, want %v", resp.Peers, want)
+	}
+}
@@ -0,0 +1,244 @@
+package torrent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// blockSize is the size of the blocks that pieces are requested in, which
+// are also the leaves of a v2 file's merkle tree.
+const blockSize = 16 << 10
+
+// fileTreeLeaf describes one file in a v2 file tree.
+type fileTreeLeaf struct {
+	Length     int64  `bencode:"length"`
+	PiecesRoot []byte `bencode:"pieces root"`
+}
+
+// TruncatedInfoHashV2 returns the first 20 bytes of the v2 info hash, which
+// stand in for the info hash where only 20 bytes fit, such as in handshakes
+// and tracker requests.
+func (m *Metadata) TruncatedInfoHashV2() [20]byte {
+	var truncated [20]byte
+	copy(truncated[:], m.InfoHashV2[:])
+	return truncated
+}
+
+// IsV2 reports whether the torrent carries v2 metadata (BEP 52).
+func (m *Metadata) IsV2() bool {
+	return m.MetaVersion >= 2
+}
+
+// IsHybrid reports whether the torrent carries both v1 and v2 metadata.
+func (m *Metadata) IsHybrid() bool {
+	return m.IsV2() && m.PieceHashes != nil
+}
+
+// v2FileEntries lays out the files of a v2 file tree in the order they appear
+// in it, padding each file to start on a piece boundary as hybrid torrents
+// do, and returns them with their total length. Piece layers of files that
+// have one are checked against the file's pieces root.
+func (info *infoDict) v2FileEntries(pieceLayers map[string][]byte) ([]FileEntry, int64, error) {
+	if err := checkPathComponent(info.Name); err != nil {
+		return nil, 0, fmt.Errorf("invalid name: %v", err)
+	}
+	if info.PieceLength < blockSize || info.PieceLength&(info.PieceLength-1) != 0 {
+		return nil, 0, fmt.Errorf("invalid piece length %d for a v2 torrent", info.PieceLength)
+	}
+
+	var files []FileEntry
+	err := walkFileTree(info.FileTree, nil, func(path []string, leaf fileTreeLeaf) error {
+		f, err := leaf.fileEntry(path, int64(info.PieceLength), pieceLayers)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(files) == 0 {
+		return nil, 0, fmt.Errorf("file tree has no files")
+	}
+
+	if len(files) == 1 && len(files[0].Path) == 1 {
+		return files, files[0].Length, nil
+	}
+
+	laidOut := make([]FileEntry, 0, len(files))
+	var offset int64
+	for _, f := range files {
+		if gap := offset % int64(info.PieceLength); gap != 0 && f.Length > 0 {
+			padding := int64(info.PieceLength) - gap
+			laidOut = append(laidOut, FileEntry{
+				Path:    []string{info.Name, ".pad", strconv.FormatInt(padding, 10)},
+				Length:  padding,
+				Offset:  offset,
+				Padding: true,
+			})
+			offset += padding
+		}
+
+		f.Path = append([]string{info.Name}, f.Path...)
+		f.Offset = offset
+		laidOut = append(laidOut, f)
+		offset += f.Length
+	}
+	return laidOut, offset, nil
+}
+
+// walkFileTree calls visit for each file in a v2 file tree, depth-first and
+// in key order, which is the order of the files' data.
+func walkFileTree(data []byte, path []string, visit func(path []string, leaf fileTreeLeaf) error) error {
+	var node map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(data, &node); err != nil {
+		return fmt.Errorf("invalid file tree at %q: %v", strings.Join(path, "/"), err)
+	}
+
+	if leafData, ok := node[""]; ok {
+		if len(path) == 0 || len(node) != 1 {
+			return fmt.Errorf("invalid file tree at %q: a file cannot have children", strings.Join(path, "/"))
+		}
+		var leaf fileTreeLeaf
+		if err := bencode.Unmarshal(leafData, &leaf); err != nil {
+			return fmt.Errorf("invalid file %q: %v", strings.Join(path, "/"), err)
+		}
+		return visit(path, leaf)
+	}
+
+	names := make([]string, 0, len(node))
+	for name := range node {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := checkPathComponent(name); err != nil {
+			return fmt.Errorf("invalid path in file tree: %v", err)
+		}
+		if err := walkFileTree(node[name], append(path[:len(path):len(path)], name), visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileEntry validates the leaf and returns its file entry, with the piece
+// layer attached when pieceLayers has one for it.
+func (leaf fileTreeLeaf) fileEntry(path []string, pieceLength int64, pieceLayers map[string][]byte) (FileEntry, error) {
+	name := strings.Join(path, "/")
+	if leaf.Length < 0 {
+		return FileEntry{}, fmt.Errorf("invalid length %d for file %q", leaf.Length, name)
+	}
+
+	f := FileEntry{Path: path, Length: leaf.Length}
+	if leaf.Length == 0 {
+		if leaf.PiecesRoot != nil {
+			return FileEntry{}, fmt.Errorf("empty file %q has a pieces root", name)
+		}
+		return f, nil
+	}
+	if len(leaf.PiecesRoot) != sha256.Size {
+		return FileEntry{}, fmt.Errorf("expected a 32-byte pieces root for file %q, but got %d bytes", name, len(leaf.PiecesRoot))
+	}
+	copy(f.PiecesRoot[:], leaf.PiecesRoot)
+
+	layer, ok := pieceLayers[string(leaf.PiecesRoot)]
+	if !ok || leaf.Length <= pieceLength {
+		return f, nil
+	}
+
+	pieces := (leaf.Length + pieceLength - 1) / pieceLength
+	if int64(len(layer)) != pieces*sha256.Size {
+		return FileEntry{}, fmt.Errorf("expected a piece layer of %d hashes for file %q, but got %d bytes", pieces, name, len(layer))
+	}
+
+	hashes := make([][32]byte, pieces)
+	f.PieceLayer = make([]string, pieces)
+	for i := range hashes {
+		copy(hashes[i][:], layer[i*sha256.Size:])
+		f.PieceLayer[i] = hex.EncodeToString(hashes[i][:])
+	}
+	if merkleRoot(hashes, pieceLayerPadding(pieceLength)) != f.PiecesRoot {
+		return FileEntry{}, fmt.Errorf("piece layer of file %q does not match its pieces root", name)
+	}
+	return f, nil
+}
+
+// checkHybrid verifies that the v1 and v2 file lists of a hybrid torrent
+// describe the same files at the same offsets, and copies the v2 hashes onto
+// the v1 entries.
+func checkHybrid(v1, v2 []FileEntry) error {
+	files1, files2 := dataFiles(v1), dataFiles(v2)
+	if len(files1) != len(files2) {
+		return fmt.Errorf("hybrid torrent has %d v1 files but %d v2 files", len(files1), len(files2))
+	}
+
+	for i := range files1 {
+		f1, f2 := &v1[files1[i]], v2[files2[i]]
+		if !slices.Equal(f1.Path, f2.Path) || f1.Length != f2.Length {
+			return fmt.Errorf("hybrid torrent file %d differs between v1 (%s, %d bytes) and v2 (%s, %d bytes)",
+				i, strings.Join(f1.Path, "/"), f1.Length, strings.Join(f2.Path, "/"), f2.Length)
+		}
+		if f1.Length > 0 && f1.Offset != f2.Offset {
+			return fmt.Errorf("hybrid torrent file %s is not aligned to a piece boundary", strings.Join(f1.Path, "/"))
+		}
+		f1.PiecesRoot = f2.PiecesRoot
+		f1.PieceLayer = f2.PieceLayer
+	}
+	return nil
+}
+
+// dataFiles returns the indices of the files that are not padding.
+func dataFiles(files []FileEntry) []int {
+	var indices []int
+	for i, f := range files {
+		if !f.Padding {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// merkleRoot returns the root of the SHA-256 merkle tree whose leaves are
+// hashes, padded to a power of two with pad.
+func merkleRoot(hashes [][32]byte, pad [32]byte) [32]byte {
+	n := 1
+	for n < len(hashes) {
+		n *= 2
+	}
+	layer := make([][32]byte, n)
+	copy(layer, hashes)
+	for i := len(hashes); i < n; i++ {
+		layer[i] = pad
+	}
+
+	for len(layer) > 1 {
+		for i := range len(layer) / 2 {
+			layer[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+// pieceLayerPadding returns the root of a subtree of zero blocks covering a
+// whole piece, which pads a piece layer beyond the end of its file.
+func pieceLayerPadding(pieceLength int64) [32]byte {
+	var hash [32]byte
+	for n := pieceLength / blockSize; n > 1; n /= 2 {
+		hash = hashPair(hash, hash)
+	}
+	return hash
+}
+
+func hashPair(left, right [32]byte) [32]byte {
+	return sha256.Sum256(append(left[:], right[:]...))
+}
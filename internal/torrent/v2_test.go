@@ -0,0 +1,234 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// v2TestLayer returns a piece layer of n made-up hashes and the pieces root
+// it belongs to for a piece length of 16 KiB.
+func v2TestLayer(n int) ([]byte, [32]byte) {
+	hashes := make([][32]byte, n)
+	var layer []byte
+	for i := range hashes {
+		hashes[i] = sha256.Sum256([]byte{byte(i)})
+		layer = append(layer, hashes[i][:]...)
+	}
+	return layer, merkleRoot(hashes, [32]byte{})
+}
+
+// v2TestFileTree returns a file tree with a three-piece file, a small file
+// in a directory and an empty file, along with the piece layers.
+func v2TestFileTree() (map[string]any, map[string]any) {
+	layer, root := v2TestLayer(3)
+	small := sha256.Sum256([]byte("small"))
+	tree := map[string]any{
+		"a.txt": map[string]any{"": map[string]any{"length": 40000, "pieces root": root[:]}},
+		"dir":   map[string]any{"b": map[string]any{"": map[string]any{"length": 100, "pieces root": small[:]}}},
+		"empty": map[string]any{"": map[string]any{"length": 0}},
+	}
+	return tree, map[string]any{string(root[:]): layer}
+}
+
+func encodeV2Torrent(t *testing.T, info map[string]any, layers map[string]any) ([]byte, []byte) {
+	t.Helper()
+	rawInfo, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatalf("failed to encode info: %v", err)
+	}
+	data, err := bencode.Marshal(map[string]any{
+		"announce":     "http://tracker/announce",
+		"info":         bencode.RawMessage(rawInfo),
+		"piece layers": layers,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode torrent: %v", err)
+	}
+	return data, rawInfo
+}
+
+func TestInfoV2(t *testing.T) {
+	tree, layers := v2TestFileTree()
+	data, rawInfo := encodeV2Torrent(t, map[string]any{
+		"name":         "folder",
+		"piece length": 16384,
+		"meta version": 2,
+		"file tree":    tree,
+	}, layers)
+
+	info, err := Info(data)
+	if err != nil {
+		t.Fatalf("failed to parse torrent file: %v", err)
+	}
+
+	if !info.IsV2() || info.IsHybrid() || info.MetaVersion != 2 {
+		t.Errorf("expected a v2-only torrent, got meta version %d and hybrid %v", info.MetaVersion, info.IsHybrid())
+	}
+	if want := sha256.Sum256(rawInfo); info.InfoHashV2 != want {
+		t.Errorf("expected v2 info hash %x, got %x", want, info.InfoHashV2)
+	}
+	if info.InfoHash != info.TruncatedInfoHashV2() || !reflect.DeepEqual(info.InfoHash[:], info.InfoHashV2[:20]) {
+		t.Errorf("expected info hash to be the truncated v2 hash, got %x", info.InfoHash)
+	}
+	if info.PieceHashes != nil {
+		t.Errorf("expected no v1 piece hashes, got %v", info.PieceHashes)
+	}
+	if info.Length != 49252 {
+		t.Errorf("expected Length to be 49252, but got %d", info.Length)
+	}
+
+	_, root := v2TestLayer(3)
+	small := sha256.Sum256([]byte("small"))
+	want := []FileEntry{
+		{Path: []string{"folder", "a.txt"}, Length: 40000, Offset: 0, PiecesRoot: root},
+		{Path: []string{"folder", ".pad", "9152"}, Length: 9152, Offset: 40000, Padding: true},
+		{Path: []string{"folder", "dir", "b"}, Length: 100, Offset: 49152, PiecesRoot: small},
+		{Path: []string{"folder", "empty"}, Length: 0, Offset: 49252},
+	}
+	if len(info.Files) != len(want) {
+		t.Fatalf("expected files %+v, got %+v", want, info.Files)
+	}
+	for i, f := range info.Files {
+		f.PieceLayer = nil
+		if !reflect.DeepEqual(f, want[i]) {
+			t.Errorf("expected file %d to be %+v, got %+v", i, want[i], f)
+		}
+	}
+
+	if got := info.Files[0].PieceLayer; len(got) != 3 {
+		t.Fatalf("expected a piece layer of 3 hashes, got %v", got)
+	}
+	if want := sha256.Sum256([]byte{1}); info.Files[0].PieceLayer[1] != hex.EncodeToString(want[:]) {
+		t.Errorf("expected second piece hash %x, got %s", want, info.Files[0].PieceLayer[1])
+	}
+}
+
+func TestInfoHybrid(t *testing.T) {
+	tree, layers := v2TestFileTree()
+	data, rawInfo := encodeV2Torrent(t, map[string]any{
+		"name":         "folder",
+		"piece length": 16384,
+		"meta version": 2,
+		"file tree":    tree,
+		"pieces":       make([]byte, 4*20),
+		"files": []any{
+			map[string]any{"length": 40000, "path": []string{"a.txt"}},
+			map[string]any{"length": 9152, "path": []string{".pad", "9152"}, "attr": "p"},
+			map[string]any{"length": 100, "path": []string{"dir", "b"}},
+			map[string]any{"length": 0, "path": []string{"empty"}},
+		},
+	}, layers)
+
+	info, err := Info(data)
+	if err != nil {
+		t.Fatalf("failed to parse torrent file: %v", err)
+	}
+
+	if !info.IsHybrid() {
+		t.Errorf("expected a hybrid torrent")
+	}
+	if want := sha1.Sum(rawInfo); info.InfoHash != want {
+		t.Errorf("expected v1 info hash %x, got %x", want, info.InfoHash)
+	}
+	if want := sha256.Sum256(rawInfo); info.InfoHashV2 != want {
+		t.Errorf("expected v2 info hash %x, got %x", want, info.InfoHashV2)
+	}
+	if len(info.PieceHashes) != 4 || info.Length != 49252 {
+		t.Errorf("expected 4 piece hashes and Length 49252, got %d and %d", len(info.PieceHashes), info.Length)
+	}
+	if !info.Files[1].Padding || info.Files[0].Padding {
+		t.Errorf("expected only the second file to be padding, got %+v", info.Files)
+	}
+	if _, root := v2TestLayer(3); info.Files[0].PiecesRoot != root || len(info.Files[0].PieceLayer) != 3 {
+		t.Errorf("expected v2 hashes on the v1 file entry, got %+v", info.Files[0])
+	}
+}
+
+func TestInfoV2Invalid(t *testing.T) {
+	tree, layers := v2TestFileTree()
+	badLayer, _ := v2TestLayer(3)
+	badLayer[0] ^= 0xff
+	_, root := v2TestLayer(3)
+
+	tests := []struct {
+		name   string
+		info   map[string]any
+		layers map[string]any
+	}{
+		{
+			name: "unsupported meta version",
+			info: map[string]any{"name": "x", "piece length": 16384, "meta version": 3, "file tree": tree},
+		},
+		{
+			name: "file tree without meta version",
+			info: map[string]any{"name": "x", "piece length": 16384, "file tree": tree},
+		},
+		{
+			name: "meta version without file tree",
+			info: map[string]any{"name": "x", "piece length": 16384, "meta version": 2},
+		},
+		{
+			name: "piece length not a power of two",
+			info: map[string]any{"name": "x", "piece length": 20000, "meta version": 2, "file tree": tree},
+		},
+		{
+			name: "short pieces root",
+			info: map[string]any{"name": "x", "piece length": 16384, "meta version": 2, "file tree": map[string]any{
+				"a": map[string]any{"": map[string]any{"length": 1, "pieces root": "short"}},
+			}},
+		},
+		{
+			name: "unsafe path",
+			info: map[string]any{"name": "x", "piece length": 16384, "meta version": 2, "file tree": map[string]any{
+				"..": map[string]any{"": map[string]any{"length": 0}},
+			}},
+		},
+		{
+			name:   "piece layer does not match root",
+			info:   map[string]any{"name": "x", "piece length": 16384, "meta version": 2, "file tree": tree},
+			layers: map[string]any{string(root[:]): badLayer},
+		},
+		{
+			name: "hybrid files differ",
+			info: map[string]any{"name": "x", "piece length": 16384, "meta version": 2, "file tree": tree,
+				"pieces": make([]byte, 20), "files": []any{map[string]any{"length": 40000, "path": []string{"a.txt"}}}},
+			layers: layers,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := encodeV2Torrent(t, tt.info, tt.layers)
+			if _, err := Info(data); err == nil {
+				t.Errorf("expected error for %v", tt.info)
+			}
+		})
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	h := func(data ...[32]byte) [32]byte {
+		var b []byte
+		for _, d := range data {
+			b = append(b, d[:]...)
+		}
+		return sha256.Sum256(b)
+	}
+	a, b, c := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b")), sha256.Sum256([]byte("c"))
+	var zero [32]byte
+
+	if got, want := merkleRoot([][32]byte{a, b, c}, zero), h(h(a, b), h(c, zero)); got != want {
+		t.Errorf("merkleRoot() = %x, want %x", got, want)
+	}
+	if got := merkleRoot([][32]byte{a}, zero); got != a {
+		t.Errorf("merkleRoot() of one hash = %x, want %x", got, a)
+	}
+	if got, want := pieceLayerPadding(4*blockSize), h(h(zero, zero), h(zero, zero)); got != want {
+		t.Errorf("pieceLayerPadding() = %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+)
+
+// PieceHashError is returned for a piece whose data does not match its
+// hash. The data is discarded; the peer that sent it is to blame.
+type PieceHashError struct {
+	Index int
+	Want  string // The hex SHA-1 hash from the torrent.
+	Got   string // The hex SHA-1 hash of the data received.
+}
+
+func (e *PieceHashError) Error() string {
+	return fmt.Sprintf("piece %d failed its hash check: got %s, want %s", e.Index, e.Got, e.Want)
+}
+
+// VerifyPiece checks the data of a piece against its SHA-1 hash, returning
+// a *PieceHashError if they do not match.
+func VerifyPiece(metadata *Metadata, pieceIndex int, data []byte) error {
+	if pieceIndex < 0 || pieceIndex >= len(metadata.PieceHashes) {
+		return fmt.Errorf("torrent has no hash for piece %d", pieceIndex)
+	}
+	sum := sha1.Sum(data)
+	got := hex.EncodeToString(sum[:])
+	if want := metadata.PieceHashes[pieceIndex]; got != want {
+		return &PieceHashError{Index: pieceIndex, Want: want, Got: got}
+	}
+	return nil
+}
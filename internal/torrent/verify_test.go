@@ -0,0 +1,70 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/testutil"
+)
+
+// pieceHash returns the hex SHA-1 hash of a piece made of blocks.
+func pieceHash(blocks ...[]byte) string {
+	sum := sha1.Sum(bytes.Join(blocks, nil))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyPiece(t *testing.T) {
+	metadata := &Metadata{PieceHashes: []string{pieceHash([]byte("good"))}}
+
+	tests := []struct {
+		name    string
+		index   int
+		data    []byte
+		wantErr bool
+	}{
+		{name: "match", index: 0, data: []byte("good")},
+		{name: "mismatch", index: 0, data: []byte("evil"), wantErr: true},
+		{name: "no such piece", index: 1, data: []byte("good"), wantErr: true},
+		{name: "negative index", index: -1, data: []byte("good"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyPiece(metadata, tt.index, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyPiece() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	var hashErr *PieceHashError
+	err := VerifyPiece(metadata, 0, []byte("evil"))
+	if !errors.As(err, &hashErr) || hashErr.Index != 0 || hashErr.Want != metadata.PieceHashes[0] || hashErr.Got != pieceHash([]byte("evil")) {
+		t.Errorf("VerifyPiece() error = %#v, want a *PieceHashError", err)
+	}
+}
+
+func TestDownloadPieceHashFailure(t *testing.T) {
+	mockConn := testutil.NewMockTCPConn()
+	defer mockConn.Close()
+	mockConn.SetReadData([]byte{
+		0x00, 0x00, 0x00, 0x01, 0x01, // unchoke
+		0x00, 0x00, 0x00, 0x0A, 0x07, // piece
+		0x00, 0x00, 0x00, 0x00, // piece index
+		0x00, 0x00, 0x00, 0x00, // block offset
+		0x66, // corrupt data
+	})
+	metadata := &Metadata{PieceLength: 16384, Length: 1, PieceHashes: []string{pieceHash([]byte{0x01})}}
+
+	var output bytes.Buffer
+	err := DownloadPiece(mockConn, &output, metadata, 0)
+	var hashErr *PieceHashError
+	if !errors.As(err, &hashErr) || hashErr.Index != 0 {
+		t.Errorf("DownloadPiece() error = %v, want a *PieceHashError for piece 0", err)
+	}
+	if output.Len() != 0 {
+		t.Errorf("DownloadPiece() wrote unverified data %v", output.Bytes())
+	}
+}
@@ -0,0 +1,174 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+)
+
+// announceRequest is a parsed announce request.
+type announceRequest struct {
+	infoHash [20]byte
+	peerID   [20]byte
+	addr     netip.AddrPort
+	left     int64
+	event    string
+	numWant  int
+	compact  bool
+	noPeerID bool
+}
+
+// parseAnnounce parses the query of an announce request. The peer's IP
+// address is the one the request came from.
+func parseAnnounce(r *http.Request) (*announceRequest, error) {
+	q := r.URL.Query()
+	req := &announceRequest{numWant: DefaultNumWant, compact: q.Get("compact") != "0", noPeerID: q.Get("no_peer_id") == "1"}
+
+	if err := parseHash(q, "info_hash", &req.infoHash); err != nil {
+		return nil, err
+	}
+	if err := parseHash(q, "peer_id", &req.peerID); err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+	req.addr = netip.AddrPortFrom(remote.Addr().Unmap(), uint16(port))
+
+	if req.left, err = strconv.ParseInt(q.Get("left"), 10, 64); err != nil || req.left < 0 {
+		return nil, fmt.Errorf("invalid left")
+	}
+
+	switch req.event = q.Get("event"); req.event {
+	case "", "started", "completed", "stopped", "paused":
+	default:
+		return nil, fmt.Errorf("invalid event %q", req.event)
+	}
+
+	if s := q.Get("numwant"); s != "" {
+		if req.numWant, err = strconv.Atoi(s); err != nil || req.numWant < 0 {
+			return nil, fmt.Errorf("invalid numwant")
+		}
+		req.numWant = min(req.numWant, maxNumWant)
+	}
+	return req, nil
+}
+
+// parseHash parses a 20-byte binary parameter.
+func parseHash(q url.Values, name string, hash *[20]byte) error {
+	values := q[name]
+	if len(values) != 1 || len(values[0]) != len(hash) {
+		return fmt.Errorf("invalid %s", name)
+	}
+	copy(hash[:], values[0])
+	return nil
+}
+
+// announceResponse is the response to an announce. Peers is either a
+// compact string or a list of dictPeer.
+type announceResponse struct {
+	Interval    int64  `bencode:"interval"`
+	MinInterval int64  `bencode:"min interval"`
+	Complete    int    `bencode:"complete"`
+	Incomplete  int    `bencode:"incomplete"`
+	Peers       any    `bencode:"peers"`
+	Peers6      []byte `bencode:"peers6,omitempty"`
+}
+
+// dictPeer is a peer in the non-compact form of the peers list.
+type dictPeer struct {
+	PeerID []byte `bencode:"peer id,omitempty"`
+	IP     string `bencode:"ip"`
+	Port   uint16 `bencode:"port"`
+}
+
+func (s *Server) announce(w http.ResponseWriter, r *http.Request) {
+	req, err := parseAnnounce(r)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+	if !s.isAllowed(req.infoHash) {
+		writeFailure(w, "torrent not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	now := s.now()
+	s.sweep(now)
+	sw := s.swarms[req.infoHash]
+	if sw == nil {
+		sw = newSwarm()
+		s.swarms[req.infoHash] = sw
+	}
+	sw.expire(now.Add(-s.config.PeerTimeout))
+
+	var peers []*peer
+	if req.event == "stopped" {
+		sw.remove(req.peerID, req.addr.Addr())
+	} else {
+		if req.event == "completed" {
+			sw.downloaded++
+		}
+		self := sw.update(req.peerID, req.addr, req.left, now)
+		peers = sw.pick(self, req.numWant)
+	}
+	response := announceResponse{
+		Interval:    int64(s.config.Interval.Seconds()),
+		MinInterval: int64(s.config.MinInterval.Seconds()),
+	}
+	response.Complete, response.Incomplete = sw.counts()
+	if sw.empty() {
+		delete(s.swarms, req.infoHash)
+	}
+
+	if req.compact {
+		compact := []byte{}
+		for _, p := range peers {
+			if p.addr.Addr().Is4() {
+				compact = appendCompactAddr(compact, p.addr)
+			} else {
+				response.Peers6 = appendCompactAddr(response.Peers6, p.addr)
+			}
+		}
+		response.Peers = compact
+	} else {
+		list := []dictPeer{}
+		for _, p := range peers {
+			entry := dictPeer{IP: p.addr.Addr().String(), Port: p.addr.Port()}
+			if !req.noPeerID {
+				entry.PeerID = p.id[:]
+			}
+			list = append(list, entry)
+		}
+		response.Peers = list
+	}
+	s.mu.Unlock()
+
+	writeResponse(w, response)
+}
+
+// appendCompactAddr appends the compact form of an address: the IP address
+// followed by the port, in network byte order.
+func appendCompactAddr(b []byte, addr netip.AddrPort) []byte {
+	b = append(b, addr.Addr().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+// parseCompactAddr parses an address in compact form.
+func parseCompactAddr(b []byte) (netip.AddrPort, bool) {
+	if len(b) != 6 && len(b) != 18 {
+		return netip.AddrPort{}, false
+	}
+	ip, _ := netip.AddrFromSlice(b[:len(b)-2])
+	return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(b[len(b)-2:])), true
+}
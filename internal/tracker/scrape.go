@@ -0,0 +1,57 @@
+package tracker
+
+import "net/http"
+
+// scrapeFile holds the counts of one torrent in a scrape response.
+type scrapeFile struct {
+	Complete   int   `bencode:"complete"`
+	Downloaded int64 `bencode:"downloaded"`
+	Incomplete int   `bencode:"incomplete"`
+}
+
+// scrapeResponse is the response to a scrape, keyed by info hash.
+type scrapeResponse struct {
+	Files map[string]scrapeFile `bencode:"files"`
+}
+
+// scrape reports the counts of the requested torrents, or of every torrent
+// if none is requested. Torrents the tracker has no peers for are left out,
+// unless they are on the allow list.
+func (s *Server) scrape(w http.ResponseWriter, r *http.Request) {
+	infoHashes := r.URL.Query()["info_hash"]
+	for _, infoHash := range infoHashes {
+		if len(infoHash) != 20 {
+			writeFailure(w, "invalid info_hash")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	now := s.now()
+	s.sweep(now)
+	if len(infoHashes) == 0 {
+		for infoHash := range s.swarms {
+			infoHashes = append(infoHashes, string(infoHash[:]))
+		}
+	}
+
+	response := scrapeResponse{Files: make(map[string]scrapeFile)}
+	for _, infoHash := range infoHashes {
+		key := [20]byte([]byte(infoHash))
+		sw := s.swarms[key]
+		if sw == nil {
+			if s.allowed[key] {
+				response.Files[infoHash] = scrapeFile{}
+			}
+			continue
+		}
+		sw.expire(now.Add(-s.config.PeerTimeout))
+		var file scrapeFile
+		file.Complete, file.Incomplete = sw.counts()
+		file.Downloaded = sw.downloaded
+		response.Files[infoHash] = file
+	}
+	s.mu.Unlock()
+
+	writeResponse(w, response)
+}
@@ -0,0 +1,160 @@
+// Package tracker implements an HTTP BitTorrent tracker: peers announce
+// themselves to it, and get the other peers of their swarm in return.
+package tracker
+
+import (
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// DefaultInterval is how often clients are asked to announce by default.
+const DefaultInterval = 30 * time.Minute
+
+// DefaultMinInterval is the shortest interval clients may announce at by
+// default.
+const DefaultMinInterval = 5 * time.Minute
+
+// DefaultNumWant is how many peers a response holds when the client does
+// not ask for a number.
+const DefaultNumWant = 50
+
+// maxNumWant caps the number of peers a client can ask for.
+const maxNumWant = 200
+
+// Config configures a Server.
+type Config struct {
+	// Interval defaults to DefaultInterval.
+	Interval time.Duration
+	// MinInterval defaults to DefaultMinInterval.
+	MinInterval time.Duration
+	// PeerTimeout is how long a peer stays listed after its last announce.
+	// It defaults to twice Interval.
+	PeerTimeout time.Duration
+	// AllowList, if not nil, holds the only info hashes the tracker
+	// serves.
+	AllowList [][20]byte
+	// StateFile, if set, is where the swarms are loaded from by NewServer
+	// and saved to by Save.
+	StateFile string
+}
+
+// A Server is an HTTP tracker. It serves announce requests on any path
+// ending in "/announce", and scrape requests on any path ending in
+// "/scrape".
+type Server struct {
+	config  Config
+	allowed map[[20]byte]bool
+	now     func() time.Time
+
+	mu        sync.Mutex
+	swarms    map[[20]byte]*swarm
+	lastSweep time.Time
+}
+
+// NewServer creates a tracker, with the swarms saved in config.StateFile
+// if there are any.
+func NewServer(config Config) (*Server, error) {
+	if config.Interval == 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MinInterval == 0 {
+		config.MinInterval = DefaultMinInterval
+	}
+	if config.PeerTimeout == 0 {
+		config.PeerTimeout = 2 * config.Interval
+	}
+
+	s := &Server{
+		config: config,
+		now:    time.Now,
+		swarms: make(map[[20]byte]*swarm),
+	}
+	if config.AllowList != nil {
+		s.allowed = make(map[[20]byte]bool, len(config.AllowList))
+		for _, infoHash := range config.AllowList {
+			s.allowed[infoHash] = true
+		}
+	}
+
+	if config.StateFile != "" {
+		saved, err := loadState(config.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil {
+			s.restore(saved)
+		}
+	}
+	return s, nil
+}
+
+// Save writes the swarms to the state file, if there is one.
+func (s *Server) Save() error {
+	if s.config.StateFile == "" {
+		return nil
+	}
+	return s.saveState(s.config.StateFile)
+}
+
+// ServeHTTP answers announce and scrape requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch path.Base(r.URL.Path) {
+	case "announce":
+		s.announce(w, r)
+	case "scrape":
+		s.scrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// isAllowed reports whether the tracker serves the torrent.
+func (s *Server) isAllowed(infoHash [20]byte) bool {
+	return s.allowed == nil || s.allowed[infoHash]
+}
+
+// sweep drops expired peers from every swarm, and the swarms that are left
+// with nothing worth keeping. Announces only expire the peers of their own
+// swarm, so this runs every PeerTimeout to clean up abandoned torrents.
+// s.mu must be held.
+func (s *Server) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.config.PeerTimeout {
+		return
+	}
+	s.lastSweep = now
+	for infoHash, sw := range s.swarms {
+		sw.expire(now.Add(-s.config.PeerTimeout))
+		if sw.empty() {
+			delete(s.swarms, infoHash)
+		}
+	}
+}
+
+// writeResponse writes a bencoded response.
+func writeResponse(w http.ResponseWriter, response any) {
+	body, err := bencode.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(body)
+}
+
+// failureResponse tells the client why its request was refused. Trackers
+// send it with status 200, as clients only look at the body.
+type failureResponse struct {
+	FailureReason string `bencode:"failure reason"`
+}
+
+func writeFailure(w http.ResponseWriter, reason string) {
+	writeResponse(w, failureResponse{FailureReason: reason})
+}
@@ -0,0 +1,315 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/torrent"
+)
+
+// newTestServer starts a tracker over HTTP, and returns a client for its
+// announce URL.
+func newTestServer(t *testing.T, config Config) (*Server, *torrent.HTTPTracker) {
+	t.Helper()
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	httpServer := httptest.NewServer(s)
+	t.Cleanup(httpServer.Close)
+	return s, &torrent.HTTPTracker{URL: httpServer.URL + "/announce", Client: http.DefaultClient}
+}
+
+// announceAs announces the peer with the given ID byte and port.
+func announceAs(t *testing.T, tracker *torrent.HTTPTracker, infoHash [20]byte, id byte, port uint16, left int64, event torrent.AnnounceEvent) *torrent.AnnounceResponse {
+	t.Helper()
+	req := &torrent.AnnounceRequest{InfoHash: infoHash, PeerID: [20]byte{id}, Port: port, Left: left, Event: event}
+	response, err := tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	return response
+}
+
+func sortedAddrs(peers []torrent.PeerAddr) []string {
+	addrs := make([]string, 0, len(peers))
+	for _, p := range peers {
+		addrs = append(addrs, p.String())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestServerAnnounce(t *testing.T) {
+	_, tracker := newTestServer(t, Config{Interval: time.Hour, MinInterval: time.Minute})
+	infoHash := [20]byte{1}
+
+	announceAs(t, tracker, infoHash, 1, 6001, 0, torrent.EventStarted)
+	announceAs(t, tracker, infoHash, 2, 6002, 100, torrent.EventStarted)
+	response := announceAs(t, tracker, infoHash, 3, 6003, 100, torrent.EventStarted)
+
+	if response.Interval != time.Hour || response.MinInterval != time.Minute {
+		t.Errorf("intervals = %v, %v, want %v, %v", response.Interval, response.MinInterval, time.Hour, time.Minute)
+	}
+	if response.Seeders != 1 || response.Leechers != 2 {
+		t.Errorf("counts = %d seeders, %d leechers, want 1, 2", response.Seeders, response.Leechers)
+	}
+	if got, want := sortedAddrs(response.Peers), []string{"127.0.0.1:6001", "127.0.0.1:6002"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("peers = %v, want %v", got, want)
+	}
+
+	// A seeder is only given leechers.
+	response = announceAs(t, tracker, infoHash, 1, 6001, 0, torrent.EventNone)
+	if got, want := sortedAddrs(response.Peers), []string{"127.0.0.1:6002", "127.0.0.1:6003"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("peers for a seeder = %v, want %v", got, want)
+	}
+
+	// A stopped peer is no longer listed.
+	response = announceAs(t, tracker, infoHash, 2, 6002, 100, torrent.EventStopped)
+	if len(response.Peers) != 0 {
+		t.Errorf("peers for a stopped peer = %v, want none", response.Peers)
+	}
+	response = announceAs(t, tracker, infoHash, 3, 6003, 100, torrent.EventNone)
+	if got, want := sortedAddrs(response.Peers), []string{"127.0.0.1:6001"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("peers after a peer stopped = %v, want %v", got, want)
+	}
+
+	// numwant limits the number of peers.
+	req := &torrent.AnnounceRequest{InfoHash: infoHash, PeerID: [20]byte{4}, Port: 6004, Left: 100, NumWant: 1}
+	if response, err := tracker.Announce(context.Background(), req); err != nil || len(response.Peers) != 1 {
+		t.Errorf("Announce() with numwant 1 = %v, %v, want 1 peer", response, err)
+	}
+}
+
+func TestServerPeers(t *testing.T) {
+	_, tracker := newTestServer(t, Config{})
+	metadata := &torrent.Metadata{Announce: tracker.URL, InfoHash: [20]byte{2}, Length: 100, Files: []torrent.FileEntry{{Length: 100}}}
+	announceAs(t, tracker, metadata.InfoHash, 1, 6001, 0, torrent.EventStarted)
+
+	response, err := torrent.Peers(http.DefaultClient, metadata)
+	if err != nil {
+		t.Fatalf("Peers() error = %v", err)
+	}
+	if got, want := sortedAddrs(response.Peers), []string{"127.0.0.1:6001"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Peers() = %v, want %v", got, want)
+	}
+	if response.Peers[0].PeerID != [20]byte{} {
+		t.Errorf("Peers() gave a peer ID %x in a compact response", response.Peers[0].PeerID)
+	}
+}
+
+// get sends an announce request with query from remote, and decodes the
+// response into v.
+func get(t *testing.T, s *Server, remote, target string, v any) {
+	t.Helper()
+	r := httptest.NewRequest("GET", target, nil)
+	r.RemoteAddr = remote
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d", target, w.Code)
+	}
+	if err := bencode.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode response %q: %v", w.Body.Bytes(), err)
+	}
+}
+
+func announceQuery(infoHash [20]byte, id byte, port string, extra string) string {
+	q := url.Values{}
+	q.Set("info_hash", string(infoHash[:]))
+	q.Set("peer_id", string([]byte{id, 19: 0}))
+	q.Set("port", port)
+	q.Set("left", "100")
+	return "/announce?" + q.Encode() + extra
+}
+
+func TestServerAnnounceNonCompact(t *testing.T) {
+	s, err := NewServer(Config{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	infoHash := [20]byte{3}
+	var discard any
+	get(t, s, "10.0.0.1:50000", announceQuery(infoHash, 1, "6001", ""), &discard)
+	get(t, s, "[2001:db8::1]:50000", announceQuery(infoHash, 1, "6001", ""), &discard)
+
+	type dictPeers struct {
+		Peers []struct {
+			PeerID []byte `bencode:"peer id"`
+			IP     string `bencode:"ip"`
+			Port   int    `bencode:"port"`
+		} `bencode:"peers"`
+	}
+	var response dictPeers
+	get(t, s, "10.0.0.2:50000", announceQuery(infoHash, 2, "6002", "&compact=0"), &response)
+	if len(response.Peers) != 2 {
+		t.Fatalf("peers = %+v, want both addresses of one peer", response.Peers)
+	}
+	sort.Slice(response.Peers, func(i, j int) bool { return response.Peers[i].IP < response.Peers[j].IP })
+	for i, wantIP := range []string{"10.0.0.1", "2001:db8::1"} {
+		p := response.Peers[i]
+		if p.IP != wantIP || p.Port != 6001 || string(p.PeerID) != string([]byte{1, 19: 0}) {
+			t.Errorf("peers[%d] = %s:%d %x, want %s:6001 with peer ID 01", i, p.IP, p.Port, p.PeerID, wantIP)
+		}
+	}
+
+	response = dictPeers{}
+	get(t, s, "10.0.0.2:50000", announceQuery(infoHash, 2, "6002", "&compact=0&no_peer_id=1"), &response)
+	if len(response.Peers) != 2 || response.Peers[0].PeerID != nil {
+		t.Errorf("peers with no_peer_id = %+v, want no peer IDs", response.Peers)
+	}
+}
+
+func TestServerAnnounceSpoofedPeerID(t *testing.T) {
+	s, err := NewServer(Config{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	infoHash := [20]byte{6}
+	var discard any
+	get(t, s, "10.0.0.1:50000", announceQuery(infoHash, 1, "6001", ""), &discard)
+
+	// Announcing the victim's peer ID from elsewhere neither redirects nor
+	// removes it.
+	get(t, s, "10.0.0.66:50000", announceQuery(infoHash, 1, "6666", ""), &discard)
+	get(t, s, "10.0.0.66:50000", announceQuery(infoHash, 1, "6666", "&event=stopped"), &discard)
+	get(t, s, "10.0.0.77:50000", announceQuery(infoHash, 1, "6001", "&event=stopped"), &discard)
+
+	var response struct {
+		Peers []byte `bencode:"peers"`
+	}
+	get(t, s, "10.0.0.2:50000", announceQuery(infoHash, 2, "6002", ""), &response)
+	if want := appendCompactAddr(nil, netip.MustParseAddrPort("10.0.0.1:6001")); string(response.Peers) != string(want) {
+		t.Errorf("peers = %x, want only the victim's address %x", response.Peers, want)
+	}
+}
+
+func TestServerAnnouncePeers6(t *testing.T) {
+	s, err := NewServer(Config{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	infoHash := [20]byte{4}
+	var discard any
+	get(t, s, "[2001:db8::1]:50000", announceQuery(infoHash, 1, "6001", ""), &discard)
+	get(t, s, "[::ffff:10.0.0.1]:50000", announceQuery(infoHash, 2, "6002", ""), &discard)
+
+	var response struct {
+		Peers  []byte `bencode:"peers"`
+		Peers6 []byte `bencode:"peers6"`
+	}
+	get(t, s, "10.0.0.3:50000", announceQuery(infoHash, 3, "6003", ""), &response)
+	if want := appendCompactAddr(nil, netip.MustParseAddrPort("10.0.0.1:6002")); string(response.Peers) != string(want) {
+		t.Errorf("peers = %x, want %x", response.Peers, want)
+	}
+	if want := appendCompactAddr(nil, netip.MustParseAddrPort("[2001:db8::1]:6001")); string(response.Peers6) != string(want) {
+		t.Errorf("peers6 = %x, want %x", response.Peers6, want)
+	}
+}
+
+func TestServerAnnounceErrors(t *testing.T) {
+	allowed := [20]byte{5}
+	s, err := NewServer(Config{AllowList: [][20]byte{allowed}})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "allowed", target: announceQuery(allowed, 1, "6001", "")},
+		{name: "not allowed", target: announceQuery([20]byte{6}, 1, "6001", ""), want: "torrent not allowed"},
+		{name: "missing info hash", target: "/announce?peer_id=aaaaaaaaaaaaaaaaaaaa&port=6001&left=0", want: "invalid info_hash"},
+		{name: "short peer ID", target: "/announce?info_hash=aaaaaaaaaaaaaaaaaaaa&peer_id=a&port=6001&left=0", want: "invalid peer_id"},
+		{name: "port zero", target: announceQuery(allowed, 1, "0", ""), want: "invalid port"},
+		{name: "port too large", target: announceQuery(allowed, 1, "65536", ""), want: "invalid port"},
+		{name: "invalid event", target: announceQuery(allowed, 1, "6001", "&event=finished"), want: `invalid event "finished"`},
+		{name: "invalid numwant", target: announceQuery(allowed, 1, "6001", "&numwant=-1"), want: "invalid numwant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response struct {
+				FailureReason string `bencode:"failure reason"`
+			}
+			get(t, s, "10.0.0.1:50000", tt.target, &response)
+			if response.FailureReason != tt.want {
+				t.Errorf("failure reason = %q, want %q", response.FailureReason, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerExpiry(t *testing.T) {
+	s, tracker := newTestServer(t, Config{Interval: time.Minute})
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	infoHash := [20]byte{7}
+
+	announceAs(t, tracker, infoHash, 1, 6001, 100, torrent.EventStarted)
+	now = now.Add(90 * time.Second)
+	if response := announceAs(t, tracker, infoHash, 2, 6002, 100, torrent.EventStarted); len(response.Peers) != 1 {
+		t.Errorf("peers before the timeout = %v, want 1 peer", response.Peers)
+	}
+	now = now.Add(90 * time.Second)
+	if response := announceAs(t, tracker, infoHash, 3, 6003, 100, torrent.EventStarted); len(response.Peers) != 1 || response.Peers[0].Port() != 6002 {
+		t.Errorf("peers after the first peer timed out = %v, want only port 6002", response.Peers)
+	}
+
+	// Swarms that nobody announces to any more are dropped too.
+	now = now.Add(time.Hour)
+	announceAs(t, tracker, [20]byte{8}, 1, 6001, 100, torrent.EventStarted)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.swarms[infoHash]; ok {
+		t.Errorf("expected the abandoned swarm to be dropped")
+	}
+}
+
+func TestServerScrape(t *testing.T) {
+	allowed := [20]byte{9}
+	_, tracker := newTestServer(t, Config{AllowList: [][20]byte{{10}, allowed}})
+	active := [20]byte{10}
+
+	announceAs(t, tracker, active, 1, 6001, 100, torrent.EventStarted)
+	announceAs(t, tracker, active, 2, 6002, 100, torrent.EventStarted)
+	announceAs(t, tracker, active, 2, 6002, 0, torrent.EventCompleted)
+
+	got, err := tracker.Scrape(context.Background(), [][20]byte{active, allowed, {11}})
+	if err != nil {
+		t.Fatalf("Scrape() error = %v", err)
+	}
+	want := map[[20]byte]torrent.ScrapeStats{
+		active:  {Seeders: 1, Leechers: 1, Downloaded: 1},
+		allowed: {},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Scrape() = %v, want %v", got, want)
+	}
+
+	// Scraping without info hashes gives every torrent with peers.
+	s, err := NewServer(Config{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	var discard any
+	get(t, s, "10.0.0.1:50000", announceQuery(active, 1, "6001", ""), &discard)
+	var response struct {
+		Files map[string]struct {
+			Incomplete int `bencode:"incomplete"`
+		} `bencode:"files"`
+	}
+	get(t, s, "10.0.0.1:50000", "/scrape", &response)
+	if len(response.Files) != 1 || response.Files[string(active[:])].Incomplete != 1 {
+		t.Errorf("scrape of every torrent = %+v, want 1 leecher of %x", response.Files, active)
+	}
+}
@@ -0,0 +1,98 @@
+package tracker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/statefile"
+)
+
+// state is what is saved across runs: the swarms, keyed by info hash.
+type state struct {
+	Torrents map[string]swarmState `bencode:"torrents"`
+}
+
+type swarmState struct {
+	Downloaded int64       `bencode:"downloaded"`
+	Peers      []peerState `bencode:"peers"`
+}
+
+// peerState is a saved peer, with its address in compact form.
+type peerState struct {
+	ID       [20]byte `bencode:"id"`
+	Addr     []byte   `bencode:"addr"`
+	Left     int64    `bencode:"left"`
+	LastSeen int64    `bencode:"last seen"`
+}
+
+// loadState reads the swarms saved by an earlier run. It gives a nil state
+// if there was no earlier run.
+func loadState(path string) (*state, error) {
+	var st state
+	ok, err := statefile.Load(path, &st)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tracker state: %w", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	for infoHash := range st.Torrents {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("failed to decode tracker state: invalid info hash %x", infoHash)
+		}
+	}
+	return &st, nil
+}
+
+// restore adds the saved swarms, leaving out the peers that have expired
+// since.
+func (s *Server) restore(st *state) {
+	deadline := s.now().Add(-s.config.PeerTimeout)
+	for infoHash, saved := range st.Torrents {
+		key := [20]byte([]byte(infoHash))
+		if !s.isAllowed(key) {
+			continue
+		}
+		sw := newSwarm()
+		sw.downloaded = saved.Downloaded
+		for _, ps := range saved.Peers {
+			lastSeen := time.Unix(ps.LastSeen, 0)
+			if lastSeen.Before(deadline) {
+				continue
+			}
+			if addr, ok := parseCompactAddr(ps.Addr); ok {
+				sw.update(ps.ID, addr, ps.Left, lastSeen)
+			}
+		}
+		if !sw.empty() {
+			s.swarms[key] = sw
+		}
+	}
+}
+
+// saveState writes the swarms to path, leaving out the peers that have
+// expired, so that a restarted tracker still knows its swarms.
+func (s *Server) saveState(path string) error {
+	s.mu.Lock()
+	now := s.now()
+	st := state{Torrents: make(map[string]swarmState, len(s.swarms))}
+	for infoHash, sw := range s.swarms {
+		sw.expire(now.Add(-s.config.PeerTimeout))
+		saved := swarmState{Downloaded: sw.downloaded, Peers: []peerState{}}
+		for _, p := range sw.peers {
+			saved.Peers = append(saved.Peers, peerState{
+				ID:       p.id,
+				Addr:     appendCompactAddr(nil, p.addr),
+				Left:     p.left,
+				LastSeen: p.lastSeen.Unix(),
+			})
+		}
+		st.Torrents[string(infoHash[:])] = saved
+	}
+	s.mu.Unlock()
+
+	if err := statefile.Save(path, st); err != nil {
+		return fmt.Errorf("failed to save tracker state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package tracker
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.dat")
+	now := time.Now().Add(-10 * time.Second).Truncate(time.Second)
+	s, err := NewServer(Config{StateFile: path, Interval: time.Minute})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	s.now = func() time.Time { return now }
+
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	sw := newSwarm()
+	sw.downloaded = 3
+	sw.update([20]byte{1}, netip.MustParseAddrPort("10.0.0.1:6001"), 0, now)
+	sw.update([20]byte{1}, netip.MustParseAddrPort("[2001:db8::1]:6001"), 0, now)
+	sw.update([20]byte{2}, netip.MustParseAddrPort("10.0.0.2:6002"), 100, now.Add(-90*time.Second))
+	s.swarms[[20]byte{1}] = sw
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	restored, err := NewServer(Config{StateFile: path, Interval: time.Minute})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	got := restored.swarms[[20]byte{1}]
+	if got == nil || got.downloaded != 3 {
+		t.Fatalf("restored swarm = %+v, want 3 downloads", got)
+	}
+	for _, addr := range []string{"10.0.0.1:6001", "[2001:db8::1]:6001"} {
+		addr := netip.MustParseAddrPort(addr)
+		p := got.peers[peerKey{id: [20]byte{1}, ip: addr.Addr()}]
+		if p == nil || p.addr != addr || !p.seeding() || !p.lastSeen.Equal(now) {
+			t.Errorf("restored peer at %v = %+v", addr, p)
+		}
+	}
+	if len(got.peers) != 3 {
+		t.Errorf("restored %d peers, want 3", len(got.peers))
+	}
+
+	// Peers that expired while the tracker was down are dropped.
+	restored, err = NewServer(Config{StateFile: path, Interval: time.Second})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if got := restored.swarms[[20]byte{1}]; got == nil || len(got.peers) != 0 {
+		t.Errorf("restored swarm = %+v, want no peers", got)
+	}
+
+	// Torrents that are no longer allowed are dropped.
+	restored, err = NewServer(Config{StateFile: path, AllowList: [][20]byte{{2}}})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if len(restored.swarms) != 0 {
+		t.Errorf("restored %d swarms, want none", len(restored.swarms))
+	}
+
+	if err := os.WriteFile(path, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewServer(Config{StateFile: path}); err == nil {
+		t.Errorf("NewServer() expected error for a corrupt state file")
+	}
+}
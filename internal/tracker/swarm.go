@@ -0,0 +1,99 @@
+package tracker
+
+import (
+	"net/netip"
+	"time"
+)
+
+// A peer is a client in a swarm, at one address.
+type peer struct {
+	id       [20]byte
+	addr     netip.AddrPort
+	left     int64
+	lastSeen time.Time
+}
+
+func (p *peer) seeding() bool {
+	return p.left == 0
+}
+
+// A peerKey identifies a peer by its peer ID and the IP address it
+// announces from, so that a client cannot redirect or remove the entry of
+// another by announcing its peer ID. A client that announces over both IPv4
+// and IPv6 has an entry for each address.
+type peerKey struct {
+	id [20]byte
+	ip netip.Addr
+}
+
+// A swarm holds the peers of a torrent.
+type swarm struct {
+	peers      map[peerKey]*peer
+	downloaded int64
+}
+
+func newSwarm() *swarm {
+	return &swarm{peers: make(map[peerKey]*peer)}
+}
+
+// update records an announce from the peer with the given ID and address.
+func (sw *swarm) update(id [20]byte, addr netip.AddrPort, left int64, now time.Time) *peer {
+	key := peerKey{id: id, ip: addr.Addr()}
+	p := sw.peers[key]
+	if p == nil {
+		p = &peer{id: id}
+		sw.peers[key] = p
+	}
+	p.addr = addr
+	p.left = left
+	p.lastSeen = now
+	return p
+}
+
+// remove drops a peer that stopped.
+func (sw *swarm) remove(id [20]byte, ip netip.Addr) {
+	delete(sw.peers, peerKey{id: id, ip: ip})
+}
+
+// expire drops the peers last seen before deadline.
+func (sw *swarm) expire(deadline time.Time) {
+	for key, p := range sw.peers {
+		if p.lastSeen.Before(deadline) {
+			delete(sw.peers, key)
+		}
+	}
+}
+
+// empty reports whether the swarm has nothing worth keeping.
+func (sw *swarm) empty() bool {
+	return len(sw.peers) == 0 && sw.downloaded == 0
+}
+
+// counts returns the number of seeders and leechers.
+func (sw *swarm) counts() (seeders, leechers int) {
+	for _, p := range sw.peers {
+		if p.seeding() {
+			seeders++
+		} else {
+			leechers++
+		}
+	}
+	return seeders, leechers
+}
+
+// pick returns up to n peers for the requesting peer, leaving out its
+// entries at any address. Seeders have no use for other seeders, so they are
+// only given leechers. Map iteration order spreads the picks over the swarm.
+func (sw *swarm) pick(self *peer, n int) []*peer {
+	var peers []*peer
+	for _, p := range sw.peers {
+		if len(peers) == n {
+			break
+		}
+		if p.id == self.id || self.seeding() && p.seeding() {
+			continue
+		}
+		peers = append(peers, p)
+	}
+	return peers
+}
@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSwarm(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	sw := newSwarm()
+	seeder := sw.update([20]byte{1}, netip.MustParseAddrPort("10.0.0.1:6001"), 0, now)
+	leecher := sw.update([20]byte{2}, netip.MustParseAddrPort("10.0.0.2:6002"), 100, now)
+	sw.update([20]byte{3}, netip.MustParseAddrPort("10.0.0.3:6003"), 0, now.Add(-time.Hour))
+
+	if seeders, leechers := sw.counts(); seeders != 2 || leechers != 1 {
+		t.Errorf("counts() = %d, %d, want 2, 1", seeders, leechers)
+	}
+	if got := sw.pick(seeder, 10); len(got) != 1 || got[0] != leecher {
+		t.Errorf("pick() for a seeder = %v, want only the leecher", got)
+	}
+	if got := sw.pick(leecher, 10); len(got) != 2 {
+		t.Errorf("pick() for a leecher = %d peers, want 2", len(got))
+	}
+	if got := sw.pick(leecher, 1); len(got) != 1 {
+		t.Errorf("pick(n=1) = %d peers, want 1", len(got))
+	}
+
+	// An announce over IPv6 adds an entry for the new address, which is
+	// not picked for the peer itself.
+	leecher6 := sw.update([20]byte{2}, netip.MustParseAddrPort("[2001:db8::2]:6002"), 50, now)
+	if leecher6 == leecher || leecher.addr.String() != "10.0.0.2:6002" {
+		t.Errorf("update() over IPv6 = %+v, want a new entry", leecher6)
+	}
+	if got := sw.pick(leecher6, 10); len(got) != 2 {
+		t.Errorf("pick() for a leecher's other address = %d peers, want 2", len(got))
+	}
+
+	sw.expire(now.Add(-time.Minute))
+	if len(sw.peers) != 3 {
+		t.Errorf("expire() left %d peers, want 3", len(sw.peers))
+	}
+
+	// Only the address a peer announced from can remove it.
+	sw.remove([20]byte{1}, netip.MustParseAddr("10.0.0.9"))
+	if len(sw.peers) != 3 {
+		t.Errorf("remove() from another address left %d peers, want 3", len(sw.peers))
+	}
+	sw.remove([20]byte{1}, seeder.addr.Addr())
+	sw.remove([20]byte{2}, leecher.addr.Addr())
+	sw.remove([20]byte{2}, leecher6.addr.Addr())
+	if !sw.empty() {
+		t.Errorf("empty() = false after every peer left")
+	}
+	sw.downloaded = 1
+	if sw.empty() {
+		t.Errorf("empty() = true for a swarm with downloads")
+	}
+}